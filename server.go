@@ -1,21 +0,0 @@
-// A basic HTTP server.
-// By default, it serves the current working directory on port 8080.
-package main
-
-import (
-	"flag"
-	"log"
-	"net/http"
-)
-
-var (
-	listen = flag.String("listen", ":8080", "listen address")
-	dir    = flag.String("dir", "./src/server", "directory to serve")
-)
-
-func mainn() {
-	flag.Parse()
-	log.Printf("listening on %q...", *listen)
-	err := http.ListenAndServe(*listen, http.FileServer(http.Dir(*dir)))
-	log.Fatalln(err)
-}
@@ -25,3 +25,32 @@ func TestString(t *testing.T) {
 		t.Errorf("program.String() wrong. got=%q", program.String())
 	}
 }
+
+func TestDump(t *testing.T) {
+	program := &Program{
+		Statements: []Statement{
+			&LetStatement{
+				Token: token.Token{Type: token.LET, Literal: "let", Pos: token.Position{Line: 1, Column: 1}},
+				Name:  &Identifier{Token: token.Token{Type: token.IDENT, Literal: "x"}, Value: "x"},
+				Value: &HashLiteral{
+					Keys:  []Expression{&StringLiteral{Value: "a"}},
+					Pairs: map[Expression]Expression{},
+				},
+			},
+		},
+	}
+	hash := program.Statements[0].(*LetStatement).Value.(*HashLiteral)
+	hash.Pairs[hash.Keys[0]] = &PrefixExpression{Operator: "-", Right: &IntegerLiteral{Value: 0}}
+
+	expected := `Program 1:1
+  Statements[0]: LetStatement 1:1
+    Name: Identifier Value="x"
+    Value: HashLiteral
+      Key[0]: StringLiteral Value="a"
+      Value[0]: PrefixExpression Operator="-"
+        Right: IntegerLiteral Value=0
+`
+	if got := Dump(program); got != expected {
+		t.Errorf("wrong dump.\nwant=%q\ngot= %q", expected, got)
+	}
+}
@@ -0,0 +1,89 @@
+package ast
+
+import (
+	"bytes"
+	"fmt"
+	"monkey/src/token"
+	"reflect"
+)
+
+var (
+	nodeType  = reflect.TypeOf((*Node)(nil)).Elem()
+	tokenType = reflect.TypeOf(token.Token{})
+)
+
+// Dump returns the tree of node, one node per line indented below its
+// parent. Each line gives the field of the parent holding the node, its
+// type and position, and its fields that are not nodes, leaving out those
+// that are zero.
+func Dump(node Node) string {
+	var out bytes.Buffer
+	dump(&out, "", node, 0)
+	return out.String()
+}
+
+func dump(out *bytes.Buffer, label string, node Node, depth int) {
+	v := reflect.ValueOf(node)
+	if !v.IsValid() || v.Kind() == reflect.Pointer && v.IsNil() {
+		return
+	}
+
+	for i := 0; i < depth; i++ {
+		out.WriteString("  ")
+	}
+	out.WriteString(label + v.Elem().Type().Name())
+	if pos := node.Pos(); pos.IsValid() {
+		out.WriteString(" " + pos.String())
+	}
+
+	// fields that are not nodes go on the node's line, nodes below it
+	type child struct {
+		label string
+		node  Node
+	}
+	children := []child{}
+
+	s := v.Elem()
+	for i := 0; i < s.NumField(); i++ {
+		field, name := s.Field(i), s.Type().Field(i).Name
+
+		switch {
+		case field.Type() == tokenType:
+
+		case field.Type().Implements(nodeType):
+			if n, ok := field.Interface().(Node); ok {
+				children = append(children, child{name + ": ", n})
+			}
+
+		case field.Kind() == reflect.Slice && field.Type().Elem().Implements(nodeType):
+			for j := 0; j < field.Len(); j++ {
+				children = append(children, child{fmt.Sprintf("%s[%d]: ", name, j), field.Index(j).Interface().(Node)})
+			}
+
+		case field.Kind() == reflect.Map:
+			// the pairs of a hash literal, listed below in the order of Keys
+
+		case field.IsZero() && name != "Value":
+
+		case field.Kind() == reflect.String:
+			fmt.Fprintf(out, " %s=%q", name, field.String())
+
+		default:
+			fmt.Fprintf(out, " %s=%v", name, field.Interface())
+		}
+	}
+	out.WriteString("\n")
+
+	if hash, ok := node.(*HashLiteral); ok {
+		children = children[:0]
+		for j, key := range hash.Keys {
+			children = append(children,
+				child{fmt.Sprintf("Key[%d]: ", j), key},
+				child{fmt.Sprintf("Value[%d]: ", j), hash.Pairs[key]})
+		}
+	}
+
+	for _, c := range children {
+		dump(out, c.label, c.node, depth+1)
+	}
+}
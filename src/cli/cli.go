@@ -0,0 +1,374 @@
+// Package cli implements the monkey command: running scripts, expressions
+// given with -e and programs read from stdin, formatting source files, and
+// starting the REPL, the playground and its API, or the language server.
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"monkey/src/ast"
+	"monkey/src/evaluator"
+	"monkey/src/format"
+	"monkey/src/lexer"
+	"monkey/src/lsp"
+	"monkey/src/object"
+	"monkey/src/parser"
+	"monkey/src/repl"
+	"monkey/src/server"
+	"monkey/src/vm"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Exit codes of Main.
+const (
+	ExitOK      = 0 // the program ran to the end
+	ExitError   = 1 // the program stopped with an error
+	ExitUsage   = 2 // the command line was wrong, or a file could not be read
+	ExitSyntax  = 3 // the program did not parse
+	ExitFailure = 4 // the server could not be started
+)
+
+const usage = `usage:
+  monkey [flags] run file.mky [args...]   run a script
+  monkey [flags] -e program [args...]     run program, printing its value
+  monkey [flags] - [args...]              run the program read from stdin
+  monkey [flags] repl                     start the REPL
+  monkey serve [-listen addr] [-dir dir]  serve the playground and its API
+  monkey fmt [-check] [paths...]          format files, or stdin to stdout
+  monkey lsp                              start the language server on stdio
+
+Without a command the program is read from stdin, or the REPL is started
+when stdin is a terminal. Scripts get their arguments as the array args.
+
+flags:
+`
+
+// importPathList is the -I flag, which may be given several times.
+type importPathList []string
+
+func (l *importPathList) String() string { return strings.Join(*l, string(os.PathListSeparator)) }
+
+func (l *importPathList) Set(dir string) error {
+	*l = append(*l, dir)
+	return nil
+}
+
+type command struct {
+	stdin          io.Reader
+	stdout, stderr io.Writer
+
+	engine     string
+	importPath importPathList
+}
+
+// Main runs the monkey command with args, the command line without the
+// program name, and returns its exit code.
+func Main(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	c := &command{stdin: stdin, stdout: stdout, stderr: stderr}
+
+	flags := flag.NewFlagSet("monkey", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprint(stderr, usage)
+		flags.PrintDefaults()
+	}
+
+	expr := flags.String("e", "", "run `program` and print its value")
+	flags.StringVar(&c.engine, "engine", repl.EngineEval, "run programs with the `engine` eval or vm")
+	flags.Var(&c.importPath, "I", "search `dir` for imported modules, after MONKEY_PATH")
+
+	if err := flags.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return ExitOK
+		}
+		return ExitUsage
+	}
+
+	if c.engine != repl.EngineEval && c.engine != repl.EngineVM {
+		fmt.Fprintf(stderr, "monkey: unknown engine %q\n", c.engine)
+		return ExitUsage
+	}
+
+	if path := os.Getenv("MONKEY_PATH"); path != "" {
+		c.importPath = append(filepath.SplitList(path), c.importPath...)
+	}
+
+	if isSet(flags, "e") {
+		return c.run("", *expr, flags.Args(), true)
+	}
+
+	rest := flags.Args()
+	if len(rest) == 0 {
+		if isTerminal(stdin) {
+			return c.repl()
+		}
+		return c.runStdin(nil)
+	}
+
+	switch rest[0] {
+	case "run":
+		// flags may also follow the command, before the file
+		if err := flags.Parse(rest[1:]); err != nil {
+			return ExitUsage
+		}
+		if flags.NArg() == 0 {
+			fmt.Fprintln(stderr, "monkey: run needs a file")
+			return ExitUsage
+		}
+		return c.runFile(flags.Arg(0), flags.Args()[1:])
+
+	case "-":
+		return c.runStdin(rest[1:])
+
+	case "repl":
+		return c.repl()
+
+	case "serve":
+		return c.serve(rest[1:])
+
+	case "fmt":
+		return c.fmt(rest[1:])
+
+	case "lsp":
+		return c.lsp()
+	}
+
+	fmt.Fprintf(stderr, "monkey: unknown command %q\n", rest[0])
+	flags.Usage()
+
+	return ExitUsage
+}
+
+func (c *command) runFile(file string, args []string) int {
+	src, err := os.ReadFile(file)
+	if err != nil {
+		fmt.Fprintf(c.stderr, "monkey: %s\n", err)
+		return ExitUsage
+	}
+
+	return c.run(file, string(src), args, false)
+}
+
+func (c *command) runStdin(args []string) int {
+	src, err := io.ReadAll(c.stdin)
+	if err != nil {
+		fmt.Fprintf(c.stderr, "monkey: cannot read stdin: %s\n", err)
+		return ExitUsage
+	}
+
+	// the program used up stdin, there is nothing left for gets
+	return c.run("", string(src), args, false)
+}
+
+// run runs the program src read from file, which is empty when it was not
+// read from one, and prints its value when print is set.
+func (c *command) run(file, src string, args []string, print bool) int {
+	p := parser.New(lexer.NewFile(file, src))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		for _, msg := range p.Errors() {
+			fmt.Fprintln(c.stderr, msg)
+		}
+		return ExitSyntax
+	}
+
+	interp := &evaluator.Interpreter{
+		Stdout:     c.stdout,
+		Stderr:     c.stderr,
+		Stdin:      c.stdin,
+		ImportPath: c.importPath,
+		Args:       args,
+	}
+
+	result := c.execute(program, interp)
+
+	if err, ok := result.(*object.Error); ok {
+		fmt.Fprintln(c.stderr, err.Traceback())
+		return ExitError
+	}
+
+	if print && result != nil && result != evaluator.NULL {
+		fmt.Fprintln(c.stdout, result.Inspect())
+	}
+
+	return ExitOK
+}
+
+func (c *command) execute(program *ast.Program, interp *evaluator.Interpreter) object.Object {
+	if c.engine == repl.EngineVM {
+		return vm.Run(context.Background(), program, interp.Globals(), interp)
+	}
+	return interp.Eval(program, interp.Globals())
+}
+
+func (c *command) repl() int {
+	repl.Start(c.stdin, c.stdout, repl.Options{
+		Engine:      c.engine,
+		ImportPath:  c.importPath,
+		HistoryFile: repl.HistoryFile(),
+	})
+	return ExitOK
+}
+
+func (c *command) serve(args []string) int {
+	flags := flag.NewFlagSet("monkey serve", flag.ContinueOnError)
+	flags.SetOutput(c.stderr)
+
+	listen := flags.String("listen", ":8080", "listen address")
+	dir := flags.String("dir", ".", "directory to serve")
+
+	if err := flags.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return ExitOK
+		}
+		return ExitUsage
+	}
+
+	cfg := server.DefaultConfig
+	cfg.Dir = *dir
+	cfg.AccessLog = c.stderr
+
+	if err := server.Start(*listen, cfg); err != nil {
+		fmt.Fprintf(c.stderr, "monkey: %s\n", err)
+		return ExitFailure
+	}
+
+	return ExitOK
+}
+
+// fmt formats the files given, and the .mky files under the directories
+// given, in place. With -check it only lists the files that are not
+// formatted, failing when there are some.
+func (c *command) fmt(args []string) int {
+	flags := flag.NewFlagSet("monkey fmt", flag.ContinueOnError)
+	flags.SetOutput(c.stderr)
+
+	check := flags.Bool("check", false, "list the files that are not formatted instead of formatting them")
+
+	if err := flags.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return ExitOK
+		}
+		return ExitUsage
+	}
+
+	if flags.NArg() == 0 || flags.NArg() == 1 && flags.Arg(0) == "-" {
+		return c.fmtStdin(*check)
+	}
+
+	code := ExitOK
+	fail := func(next int) {
+		code = max(code, next)
+	}
+
+	for _, path := range flags.Args() {
+		err := filepath.WalkDir(path, func(file string, entry os.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if entry.IsDir() || file != path && filepath.Ext(file) != ".mky" {
+				return nil
+			}
+			fail(c.fmtFile(file, *check))
+			return nil
+		})
+		if err != nil {
+			fmt.Fprintf(c.stderr, "monkey: %s\n", err)
+			fail(ExitUsage)
+		}
+	}
+
+	return code
+}
+
+func (c *command) fmtFile(file string, check bool) int {
+	src, err := os.ReadFile(file)
+	if err != nil {
+		fmt.Fprintf(c.stderr, "monkey: %s\n", err)
+		return ExitUsage
+	}
+
+	out, code := c.format(file, string(src))
+	if code != ExitOK || out == string(src) {
+		return code
+	}
+
+	if check {
+		fmt.Fprintln(c.stdout, file)
+		return ExitError
+	}
+
+	if err := os.WriteFile(file, []byte(out), 0o644); err != nil {
+		fmt.Fprintf(c.stderr, "monkey: %s\n", err)
+		return ExitUsage
+	}
+
+	return ExitOK
+}
+
+func (c *command) fmtStdin(check bool) int {
+	src, err := io.ReadAll(c.stdin)
+	if err != nil {
+		fmt.Fprintf(c.stderr, "monkey: cannot read stdin: %s\n", err)
+		return ExitUsage
+	}
+
+	out, code := c.format("", string(src))
+	if code != ExitOK {
+		return code
+	}
+
+	if check {
+		if out != string(src) {
+			fmt.Fprintln(c.stdout, "<stdin>")
+			return ExitError
+		}
+		return ExitOK
+	}
+
+	fmt.Fprint(c.stdout, out)
+	return ExitOK
+}
+
+// format formats src, read from file, printing its syntax errors when it
+// does not parse.
+func (c *command) format(file, src string) (string, int) {
+	out, err := format.Source(file, src)
+	if err != nil {
+		fmt.Fprintln(c.stderr, err)
+		return "", ExitSyntax
+	}
+	return out, ExitOK
+}
+
+func (c *command) lsp() int {
+	if err := lsp.Serve(c.stdin, c.stdout); err != nil {
+		fmt.Fprintf(c.stderr, "monkey: %s\n", err)
+		return ExitError
+	}
+
+	return ExitOK
+}
+
+func isSet(flags *flag.FlagSet, name string) bool {
+	set := false
+	flags.Visit(func(f *flag.Flag) {
+		set = set || f.Name == name
+	})
+	return set
+}
+
+// isTerminal reports whether r is a terminal rather than a pipe or a file.
+func isTerminal(r io.Reader) bool {
+	f, ok := r.(*os.File)
+	if !ok {
+		return false
+	}
+
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
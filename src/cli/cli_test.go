@@ -0,0 +1,81 @@
+package cli
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMain(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"greet.mky":  `for i, a in args { puts(i, a) }`,
+		"lib.mky":    `export let double = fn(x) { x * 2 };`,
+		"main.mky":   `import "lib.mky"; puts(lib.double(21))`,
+		"fail.mky":   "let f = fn() { 1 / 0 };\nf()",
+		"broken.mky": `let = 1`,
+		"messy.mky":  `let x=1`,
+	}
+	for name, src := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		args   []string
+		stdin  string
+		code   int
+		stdout string
+		stderr string
+	}{
+		{args: []string{"run", filepath.Join(dir, "greet.mky"), "a", "-b"}, code: ExitOK, stdout: "0, a\n1, -b\n"},
+		{args: []string{"-engine", "vm", "run", filepath.Join(dir, "greet.mky"), "x"}, code: ExitOK, stdout: "0, x\n"},
+		{args: []string{"run", "-engine", "vm", filepath.Join(dir, "main.mky")}, code: ExitOK, stdout: "42\n"},
+		{args: []string{"run", filepath.Join(dir, "main.mky")}, code: ExitOK, stdout: "42\n"},
+		{args: []string{"-e", "1 + 2"}, code: ExitOK, stdout: "3\n"},
+		{args: []string{"-e", "len(args)", "a", "b"}, code: ExitOK, stdout: "2\n"},
+		{args: []string{"-e", `puts("hi")`}, code: ExitOK, stdout: "hi\n"},
+		{args: []string{"-e", "let x = 1"}, code: ExitOK},
+		{args: []string{"-I", dir, "-e", `import "lib.mky"; lib.double(4)`}, code: ExitOK, stdout: "8\n"},
+		{args: nil, stdin: `puts(args)`, code: ExitOK, stdout: "[]\n"},
+		{args: []string{"-", "a"}, stdin: `puts(args[0])`, code: ExitOK, stdout: "a\n"},
+		{args: []string{"run", filepath.Join(dir, "fail.mky")}, code: ExitError,
+			stderr: "ZeroDivisionError: division by zero\n    at f (" + filepath.Join(dir, "fail.mky") + ":1:16)\n    at <main> (" + filepath.Join(dir, "fail.mky") + ":2:1)\n"},
+		{args: []string{"-e", "missing"}, code: ExitError, stderr: "NameError: identifier not found: missing\n    at <main> (1:1)\n"},
+		{args: []string{"run", filepath.Join(dir, "broken.mky")}, code: ExitSyntax,
+			stderr: filepath.Join(dir, "broken.mky") + ":1:5: expected next token to be IDENT, got = instead\n" +
+				filepath.Join(dir, "broken.mky") + ":1:5: no prefix parse func for = found\n"},
+		{args: []string{"run"}, code: ExitUsage, stderr: "monkey: run needs a file\n"},
+		{args: []string{"run", filepath.Join(dir, "none.mky")}, code: ExitUsage},
+		{args: []string{"-engine", "jit", "repl"}, code: ExitUsage, stderr: "monkey: unknown engine \"jit\"\n"},
+		{args: []string{"lsp"}, stdin: "Content-Length: 17\r\n\r\n{\"method\":\"exit\"}", code: ExitError, stderr: "monkey: exit without shutdown\n"},
+		{args: []string{"fmt"}, stdin: "puts( 1 )", code: ExitOK, stdout: "puts(1);\n"},
+		{args: []string{"fmt", "-check", "-"}, stdin: "puts(1);\n", code: ExitOK},
+		{args: []string{"fmt", "-check", filepath.Join(dir, "messy.mky"), filepath.Join(dir, "lib.mky")}, code: ExitError,
+			stdout: filepath.Join(dir, "messy.mky") + "\n" + filepath.Join(dir, "lib.mky") + "\n"},
+		{args: []string{"fmt", filepath.Join(dir, "messy.mky")}, code: ExitOK},
+		{args: []string{"fmt", "-check", filepath.Join(dir, "messy.mky")}, code: ExitOK},
+		{args: []string{"fmt", filepath.Join(dir, "broken.mky")}, code: ExitSyntax},
+		{args: []string{"build"}, code: ExitUsage},
+	}
+
+	for _, tt := range tests {
+		var stdout, stderr bytes.Buffer
+		code := Main(tt.args, strings.NewReader(tt.stdin), &stdout, &stderr)
+
+		if code != tt.code {
+			t.Errorf("%q: wrong exit code. want=%d, got=%d (stderr %q)", tt.args, tt.code, code, stderr.String())
+		}
+
+		if stdout.String() != tt.stdout {
+			t.Errorf("%q: wrong stdout. want=%q, got=%q", tt.args, tt.stdout, stdout.String())
+		}
+
+		if tt.stderr != "" && stderr.String() != tt.stderr {
+			t.Errorf("%q: wrong stderr. want=%q, got=%q", tt.args, tt.stderr, stderr.String())
+		}
+	}
+}
@@ -0,0 +1,270 @@
+package code
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"monkey/src/token"
+	"sort"
+)
+
+type Instructions []byte
+
+func (ins Instructions) String() string {
+	var out bytes.Buffer
+
+	i := 0
+	for i < len(ins) {
+		def, err := Lookup(ins[i])
+		if err != nil {
+			fmt.Fprintf(&out, "ERROR: %s\n", err)
+			i++
+			continue
+		}
+
+		operands, read := ReadOperands(def, ins[i+1:])
+
+		fmt.Fprintf(&out, "%04d %s\n", i, ins.fmtInstruction(def, operands))
+
+		i += 1 + read
+	}
+
+	return out.String()
+}
+
+func (ins Instructions) fmtInstruction(def *Definition, operands []int) string {
+	operandCount := len(def.OperandWidths)
+
+	if len(operands) != operandCount {
+		return fmt.Sprintf("ERROR: operand len %d does not match defined %d\n", len(operands), operandCount)
+	}
+
+	switch operandCount {
+	case 0:
+		return def.Name
+	case 1:
+		return fmt.Sprintf("%s %d", def.Name, operands[0])
+	case 2:
+		return fmt.Sprintf("%s %d %d", def.Name, operands[0], operands[1])
+	}
+
+	return fmt.Sprintf("ERROR: unhandled operandCount for %s\n", def.Name)
+}
+
+type Opcode byte
+
+const (
+	OpConstant Opcode = iota
+	OpPop
+	OpTrue
+	OpFalse
+	OpNull
+	OpNil // the absent value of statements such as let
+
+	OpAdd
+	OpSub
+	OpMul
+	OpDiv
+	OpMod
+	OpEqual
+	OpNotEqual
+	OpLessThan
+	OpGreaterThan
+	OpLessEqual
+	OpGreaterEqual
+	OpMinus
+	OpBang
+	OpToBool
+
+	OpJump
+	OpJumpNotTruthy
+
+	OpGetGlobal
+	OpDefineGlobal
+	OpSetGlobal
+	OpGetLocal
+	OpSetLocal
+	OpGetFree
+	OpSetFree
+	OpLocalCell
+	OpFreeCell
+	OpClearLocals
+
+	OpArray
+	OpHash
+	OpHashKey // fails unless the value on top of the stack can be a hash key
+	OpIndex
+	OpSetIndex
+
+	OpClosure
+	OpCall
+	OpReturnValue
+
+	OpIter
+	OpIterNext
+
+	OpSetupTry
+	OpPopTry
+	OpThrow
+
+	OpImport
+)
+
+type Definition struct {
+	Name          string
+	OperandWidths []int
+}
+
+var definitions = map[Opcode]*Definition{
+	OpConstant: {"OpConstant", []int{2}},
+	OpPop:      {"OpPop", []int{}},
+	OpTrue:     {"OpTrue", []int{}},
+	OpFalse:    {"OpFalse", []int{}},
+	OpNull:     {"OpNull", []int{}},
+	OpNil:      {"OpNil", []int{}},
+
+	OpAdd:          {"OpAdd", []int{}},
+	OpSub:          {"OpSub", []int{}},
+	OpMul:          {"OpMul", []int{}},
+	OpDiv:          {"OpDiv", []int{}},
+	OpMod:          {"OpMod", []int{}},
+	OpEqual:        {"OpEqual", []int{}},
+	OpNotEqual:     {"OpNotEqual", []int{}},
+	OpLessThan:     {"OpLessThan", []int{}},
+	OpGreaterThan:  {"OpGreaterThan", []int{}},
+	OpLessEqual:    {"OpLessEqual", []int{}},
+	OpGreaterEqual: {"OpGreaterEqual", []int{}},
+	OpMinus:        {"OpMinus", []int{}},
+	OpBang:         {"OpBang", []int{}},
+	OpToBool:       {"OpToBool", []int{}},
+
+	OpJump:          {"OpJump", []int{2}},
+	OpJumpNotTruthy: {"OpJumpNotTruthy", []int{2}},
+
+	// globals are looked up by name, the operand is the constant holding it
+	OpGetGlobal:    {"OpGetGlobal", []int{2}},
+	OpDefineGlobal: {"OpDefineGlobal", []int{2}},
+	OpSetGlobal:    {"OpSetGlobal", []int{2}},
+	OpGetLocal:     {"OpGetLocal", []int{2}},
+	OpSetLocal:     {"OpSetLocal", []int{2}},
+	OpGetFree:      {"OpGetFree", []int{2}},
+	OpSetFree:      {"OpSetFree", []int{2}},
+	OpLocalCell:    {"OpLocalCell", []int{2}},
+	OpFreeCell:     {"OpFreeCell", []int{2}},
+	OpClearLocals:  {"OpClearLocals", []int{2, 2}},
+
+	OpArray:    {"OpArray", []int{2}},
+	OpHash:     {"OpHash", []int{2}},
+	OpHashKey:  {"OpHashKey", []int{}},
+	OpIndex:    {"OpIndex", []int{}},
+	OpSetIndex: {"OpSetIndex", []int{}},
+
+	OpClosure:     {"OpClosure", []int{2, 2}},
+	OpCall:        {"OpCall", []int{2}},
+	OpReturnValue: {"OpReturnValue", []int{}},
+
+	OpIter:     {"OpIter", []int{}},
+	OpIterNext: {"OpIterNext", []int{2}},
+
+	OpSetupTry: {"OpSetupTry", []int{2}},
+	OpPopTry:   {"OpPopTry", []int{}},
+	OpThrow:    {"OpThrow", []int{}},
+
+	// the operand is the constant holding the path of the module, which is
+	// looked up from the file of the instruction
+	OpImport: {"OpImport", []int{2}},
+}
+
+func Lookup(op byte) (*Definition, error) {
+	def, ok := definitions[Opcode(op)]
+	if !ok {
+		return nil, fmt.Errorf("opcode %d undefined", op)
+	}
+
+	return def, nil
+}
+
+func Make(op Opcode, operands ...int) []byte {
+	def, ok := definitions[op]
+	if !ok {
+		return []byte{}
+	}
+
+	instructionLen := 1
+	for _, w := range def.OperandWidths {
+		instructionLen += w
+	}
+
+	instruction := make([]byte, instructionLen)
+	instruction[0] = byte(op)
+
+	offset := 1
+	for i, o := range operands {
+		width := def.OperandWidths[i]
+		switch width {
+		case 2:
+			binary.BigEndian.PutUint16(instruction[offset:], uint16(o))
+		case 1:
+			instruction[offset] = byte(o)
+		}
+		offset += width
+	}
+
+	return instruction
+}
+
+func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
+	operands := make([]int, len(def.OperandWidths))
+	offset := 0
+
+	for i, width := range def.OperandWidths {
+		switch width {
+		case 2:
+			operands[i] = int(ReadUint16(ins[offset:]))
+		case 1:
+			operands[i] = int(ReadUint8(ins[offset:]))
+		}
+
+		offset += width
+	}
+
+	return operands, offset
+}
+
+func ReadUint16(ins Instructions) uint16 {
+	return binary.BigEndian.Uint16(ins)
+}
+
+func ReadUint8(ins Instructions) uint8 { return uint8(ins[0]) }
+
+// SourceMap maps instruction offsets back to the source position of the
+// node they were compiled from.
+type SourceMap struct {
+	offsets   []int
+	positions []token.Position
+}
+
+// Add records that the instructions from offset on belong to pos.
+func (sm *SourceMap) Add(offset int, pos token.Position) {
+	n := len(sm.offsets)
+	if n > 0 && sm.positions[n-1] == pos {
+		return
+	}
+	if n > 0 && sm.offsets[n-1] == offset {
+		sm.positions[n-1] = pos
+		return
+	}
+
+	sm.offsets = append(sm.offsets, offset)
+	sm.positions = append(sm.positions, pos)
+}
+
+// Lookup returns the position of the instruction at offset.
+func (sm *SourceMap) Lookup(offset int) token.Position {
+	i := sort.Search(len(sm.offsets), func(i int) bool { return sm.offsets[i] > offset })
+	if i == 0 {
+		return token.Position{}
+	}
+
+	return sm.positions[i-1]
+}
@@ -0,0 +1,987 @@
+package compiler
+
+import (
+	"errors"
+	"fmt"
+	"monkey/src/ast"
+	"monkey/src/code"
+	"monkey/src/object"
+	"monkey/src/token"
+)
+
+// Compiler lowers a program to bytecode for the vm package. The bytecode
+// behaves like the evaluator: globals live in the object.Environment the
+// program runs against, everything bound inside a function or block scope
+// gets a slot in the function's frame.
+type Compiler struct {
+	constants []object.Object
+	names     map[string]int // constants holding names, which are shared
+
+	err error // the first operand found too wide for the bytecode
+
+	symbolTable *SymbolTable
+
+	scopes     []*CompilationScope
+	scopeIndex int
+
+	pos token.Position // of the node being compiled, for the source map
+}
+
+// CompilationScope holds the code of one function being compiled.
+type CompilationScope struct {
+	instructions code.Instructions
+	sourceMap    *code.SourceMap
+
+	depth int // values the code emitted so far leaves on the stack
+	loops []*loop
+	tries []*tryBlock
+}
+
+type loop struct {
+	depth  int   // stack depth at the start of every iteration
+	start  int   // where continue jumps to
+	breaks []int // jumps to patch to the end of the loop
+	tries  int   // try statements around the loop
+}
+
+type tryBlock struct {
+	finally *ast.BlockStatement
+	handler bool // whether the code being compiled runs under its handler
+}
+
+// ErrTooLarge is wrapped by the errors of Compile for programs the
+// bytecode cannot encode, such as a function with more locals than an
+// operand can number.
+var ErrTooLarge = errors.New("program too large")
+
+type Bytecode struct {
+	Main      *object.CompiledFunction
+	Constants []object.Object
+}
+
+func New() *Compiler {
+	mainScope := &CompilationScope{
+		instructions: code.Instructions{},
+		sourceMap:    &code.SourceMap{},
+	}
+
+	return &Compiler{
+		constants:   []object.Object{},
+		names:       map[string]int{},
+		symbolTable: NewSymbolTable(),
+		scopes:      []*CompilationScope{mainScope},
+		scopeIndex:  0,
+	}
+}
+
+func (c *Compiler) Compile(node ast.Node) (err error) {
+	prev := c.pos
+	c.pos = node.Pos()
+	defer func() {
+		c.pos = prev
+		if err == nil {
+			err = c.err
+		}
+	}()
+
+	switch node := node.(type) {
+	case *ast.Program:
+		// every statement leaves its value for OpPop, the VM keeps the
+		// last popped value as the result of the program
+		for _, s := range node.Statements {
+			if err := c.compileStatementValue(s); err != nil {
+				return err
+			}
+			c.emit(code.OpPop)
+		}
+
+	case *ast.ExpressionStatement:
+		if err := c.Compile(node.Expression); err != nil {
+			return err
+		}
+		c.emit(code.OpPop)
+
+	case *ast.LetStatement:
+		return c.compileLetStatement(node)
+
+	case *ast.ExportStatement:
+		return c.compileLetStatement(node.Statement)
+
+	case *ast.ImportStatement:
+		return c.compileImportStatement(node)
+
+	case *ast.AssignStatement:
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		c.storeSymbol(c.symbolTable.Resolve(node.Variable.Value), false)
+
+	case *ast.ReturnStatement:
+		if err := c.Compile(node.ReturnValue); err != nil {
+			return err
+		}
+		if err := c.leaveTries(0); err != nil {
+			return err
+		}
+		c.emit(code.OpReturnValue)
+
+	case *ast.ForStatement:
+		return c.compileForStatement(node)
+
+	case *ast.WhileStatement:
+		return c.compileWhileStatement(node)
+
+	case *ast.BreakStatement:
+		return c.compileLoopExit(node, true)
+
+	case *ast.ContinueStatement:
+		return c.compileLoopExit(node, false)
+
+	case *ast.TryStatement:
+		if err := c.compileTryStatement(node); err != nil {
+			return err
+		}
+		c.emit(code.OpPop)
+
+	case *ast.ThrowStatement:
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		c.emit(code.OpThrow)
+
+	case *ast.IntegerLiteral:
+		c.emit(code.OpConstant, c.addConstant(&object.Integer{Value: node.Value}))
+
+	case *ast.FloatLiteral:
+		c.emit(code.OpConstant, c.addConstant(&object.Float{Value: node.Value}))
+
+	case *ast.StringLiteral:
+		c.emit(code.OpConstant, c.addConstant(&object.String{Value: node.Value}))
+
+	case *ast.Boolean:
+		if node.Value {
+			c.emit(code.OpTrue)
+		} else {
+			c.emit(code.OpFalse)
+		}
+
+	case *ast.PrefixExpression:
+		if err := c.Compile(node.Right); err != nil {
+			return err
+		}
+
+		switch node.Operator {
+		case "!":
+			c.emit(code.OpBang)
+		case "-":
+			c.emit(code.OpMinus)
+		default:
+			return c.errorf("unknown operator %s", node.Operator)
+		}
+
+	case *ast.InfixExpression:
+		if node.Operator == "&&" || node.Operator == "||" {
+			return c.compileLogicalExpression(node)
+		}
+
+		op, ok := infixOperators[node.Operator]
+		if !ok {
+			return c.errorf("unknown operator %s", node.Operator)
+		}
+
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+		if err := c.Compile(node.Right); err != nil {
+			return err
+		}
+
+		c.emit(op)
+
+	case *ast.IfExpression:
+		return c.compileIfExpression(node)
+
+	case *ast.Identifier:
+		c.loadSymbol(c.symbolTable.Resolve(node.Value))
+
+	case *ast.ArrayLiteral:
+		for _, el := range node.Elements {
+			if err := c.Compile(el); err != nil {
+				return err
+			}
+		}
+
+		c.emit(code.OpArray, len(node.Elements))
+
+	case *ast.HashLiteral:
+		// same order as the evaluator: a key is checked before its value
+		// runs
+		for _, k := range node.Keys {
+			if err := c.Compile(k); err != nil {
+				return err
+			}
+			c.emit(code.OpHashKey)
+			if err := c.Compile(node.Pairs[k]); err != nil {
+				return err
+			}
+		}
+
+		c.emit(code.OpHash, len(node.Pairs)*2)
+
+	case *ast.IndexExpression:
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+		if err := c.Compile(node.Index); err != nil {
+			return err
+		}
+
+		c.emit(code.OpIndex)
+
+	case *ast.MemberExpression:
+		if err := c.Compile(node.Object); err != nil {
+			return err
+		}
+		c.emit(code.OpConstant, c.addName(node.Property.Value))
+		c.emit(code.OpIndex)
+
+	case *ast.IndexAssignmentExpression:
+		// same order as the evaluator: index, value, then the container
+		if err := c.Compile(node.Index.Index); err != nil {
+			return err
+		}
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		if err := c.Compile(node.Index.Left); err != nil {
+			return err
+		}
+
+		c.emit(code.OpSetIndex)
+
+	case *ast.FunctionLiteral:
+		return c.compileFunctionLiteral(node)
+
+	case *ast.CallExpression:
+		if err := c.Compile(node.Function); err != nil {
+			return err
+		}
+
+		for _, a := range node.Arguments {
+			if err := c.Compile(a); err != nil {
+				return err
+			}
+		}
+
+		c.emit(code.OpCall, len(node.Arguments))
+
+	default:
+		return c.errorf("cannot compile %T", node)
+	}
+
+	return nil
+}
+
+var infixOperators = map[string]code.Opcode{
+	"+":  code.OpAdd,
+	"-":  code.OpSub,
+	"*":  code.OpMul,
+	"/":  code.OpDiv,
+	"%":  code.OpMod,
+	"==": code.OpEqual,
+	"!=": code.OpNotEqual,
+	"<":  code.OpLessThan,
+	">":  code.OpGreaterThan,
+	"<=": code.OpLessEqual,
+	">=": code.OpGreaterEqual,
+}
+
+// compileStatementValue compiles s so that it leaves what evaluating it
+// yields on the stack. Statements the evaluator gives no value leave
+// OpNil.
+func (c *Compiler) compileStatementValue(s ast.Statement) error {
+	switch s := s.(type) {
+	case *ast.ExpressionStatement:
+		prev := c.pos
+		c.pos = s.Pos()
+		defer func() { c.pos = prev }()
+
+		return c.Compile(s.Expression)
+
+	case *ast.TryStatement:
+		prev := c.pos
+		c.pos = s.Pos()
+		defer func() { c.pos = prev }()
+
+		return c.compileTryStatement(s)
+
+	case *ast.ForStatement, *ast.WhileStatement:
+		if err := c.Compile(s); err != nil {
+			return err
+		}
+		c.emit(code.OpNull)
+
+	default:
+		if err := c.Compile(s); err != nil {
+			return err
+		}
+		c.emit(code.OpNil)
+	}
+
+	return nil
+}
+
+// compileBlockValue compiles the statements of a block whose value is
+// used, such as the branches of an if or a function body. Like in the
+// evaluator, a block with no value is NULL.
+func (c *Compiler) compileBlockValue(block *ast.BlockStatement) error {
+	if block == nil || len(block.Statements) == 0 {
+		c.emit(code.OpNull)
+		return nil
+	}
+
+	last := len(block.Statements) - 1
+	for _, s := range block.Statements[:last] {
+		if err := c.Compile(s); err != nil {
+			return err
+		}
+	}
+
+	switch s := block.Statements[last].(type) {
+	case *ast.ExpressionStatement, *ast.TryStatement, *ast.ForStatement, *ast.WhileStatement:
+		return c.compileStatementValue(s)
+	default:
+		if err := c.Compile(s); err != nil {
+			return err
+		}
+		c.emit(code.OpNull)
+	}
+
+	return nil
+}
+
+// declareLets binds, as pending, the names the statements of block define
+// with let in the current scope. The evaluator looks names up when a
+// function runs, so a function may call one bound by a later let; declaring
+// them up front lets nested functions resolve such names to their slots.
+func (c *Compiler) declareLets(block *ast.BlockStatement) {
+	if block == nil || c.symbolTable.IsGlobal() {
+		return
+	}
+
+	for _, s := range block.Statements {
+		switch s := s.(type) {
+		case *ast.LetStatement:
+			c.symbolTable.DefinePending(s.Name.Value)
+		case *ast.ImportStatement:
+			c.symbolTable.DefinePending(s.Name.Value)
+		case *ast.ExpressionStatement:
+			for ie, ok := s.Expression.(*ast.IfExpression); ok && ie != nil; ie = ie.ElseIf {
+				c.declareLets(ie.Consequence)
+				c.declareLets(ie.Alternative)
+			}
+		case *ast.WhileStatement:
+			c.declareLets(s.Block)
+		case *ast.TryStatement:
+			c.declareLets(s.Block)
+			c.declareLets(s.Finally)
+		}
+	}
+}
+
+func (c *Compiler) compileStatements(block *ast.BlockStatement) error {
+	for _, s := range block.Statements {
+		if err := c.Compile(s); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *Compiler) compileLetStatement(node *ast.LetStatement) error {
+	table := c.symbolTable
+
+	var symbol Symbol
+	if table.IsGlobal() {
+		symbol = table.Define(node.Name.Value)
+	} else {
+		symbol = table.DefinePending(node.Name.Value)
+	}
+
+	err := c.Compile(node.Value)
+	table.Settle(node.Name.Value)
+	if err != nil {
+		return err
+	}
+
+	c.storeSymbol(symbol, true)
+
+	return nil
+}
+
+func (c *Compiler) compileImportStatement(node *ast.ImportStatement) error {
+	table := c.symbolTable
+
+	var symbol Symbol
+	if table.IsGlobal() {
+		symbol = table.Define(node.Name.Value)
+	} else {
+		symbol = table.DefinePending(node.Name.Value)
+		table.Settle(node.Name.Value)
+	}
+
+	c.emit(code.OpImport, c.addName(node.Path.Value))
+	c.storeSymbol(symbol, true)
+
+	return nil
+}
+
+func (c *Compiler) compileLogicalExpression(node *ast.InfixExpression) error {
+	if err := c.Compile(node.Left); err != nil {
+		return err
+	}
+
+	depth := c.scope().depth
+	shortCircuit := c.emit(code.OpJumpNotTruthy, 9999)
+
+	if node.Operator == "&&" {
+		if err := c.Compile(node.Right); err != nil {
+			return err
+		}
+		c.emit(code.OpToBool)
+		end := c.emit(code.OpJump, 9999)
+
+		c.changeOperand(shortCircuit, len(c.currentInstructions()))
+		c.scope().depth = depth - 1
+		c.emit(code.OpFalse)
+
+		c.changeOperand(end, len(c.currentInstructions()))
+		return nil
+	}
+
+	c.emit(code.OpTrue)
+	end := c.emit(code.OpJump, 9999)
+
+	c.changeOperand(shortCircuit, len(c.currentInstructions()))
+	c.scope().depth = depth - 1
+	if err := c.Compile(node.Right); err != nil {
+		return err
+	}
+	c.emit(code.OpToBool)
+
+	c.changeOperand(end, len(c.currentInstructions()))
+	return nil
+}
+
+func (c *Compiler) compileIfExpression(node *ast.IfExpression) error {
+	if err := c.Compile(node.Condition); err != nil {
+		return err
+	}
+
+	jumpNotTruthy := c.emit(code.OpJumpNotTruthy, 9999)
+	depth := c.scope().depth
+
+	if err := c.compileBlockValue(node.Consequence); err != nil {
+		return err
+	}
+
+	jump := c.emit(code.OpJump, 9999)
+
+	c.changeOperand(jumpNotTruthy, len(c.currentInstructions()))
+	c.scope().depth = depth
+
+	switch {
+	case node.ElseIf != nil:
+		if err := c.Compile(node.ElseIf); err != nil {
+			return err
+		}
+	case node.Alternative != nil:
+		if err := c.compileBlockValue(node.Alternative); err != nil {
+			return err
+		}
+	default:
+		c.emit(code.OpNull)
+	}
+
+	c.changeOperand(jump, len(c.currentInstructions()))
+
+	return nil
+}
+
+func (c *Compiler) compileWhileStatement(node *ast.WhileStatement) error {
+	start := len(c.currentInstructions())
+
+	if err := c.Compile(node.Condition); err != nil {
+		return err
+	}
+
+	exit := c.emit(code.OpJumpNotTruthy, 9999)
+
+	l := c.enterLoop(start)
+	if err := c.compileStatements(node.Block); err != nil {
+		return err
+	}
+	c.emit(code.OpJump, start)
+	c.leaveLoop(l, exit)
+
+	return nil
+}
+
+func (c *Compiler) compileForStatement(node *ast.ForStatement) error {
+	if err := c.Compile(node.Iterator); err != nil {
+		return err
+	}
+
+	c.emit(code.OpIter)
+
+	// like the evaluator, every iteration gets fresh slots for the loop
+	// variables and the body's bindings: clearing them drops the cells of
+	// the closures made in the previous iteration
+	c.enterBlock()
+	firstLocal := c.symbolTable.NumLocals()
+
+	index := c.symbolTable.Define(node.Index.Value)
+	value := c.symbolTable.Define(node.Value.Value)
+	c.declareLets(node.Block)
+
+	start := len(c.currentInstructions())
+	next := c.emit(code.OpIterNext, 9999)
+	clear := c.emit(code.OpClearLocals, firstLocal, 0)
+	c.storeSymbol(index, true)
+	c.storeSymbol(value, true)
+
+	l := c.enterLoop(start)
+	if err := c.compileStatements(node.Block); err != nil {
+		return err
+	}
+	c.emit(code.OpJump, start)
+
+	c.changeOperand(next, len(c.currentInstructions()))
+	c.scope().depth = l.depth
+	c.leaveLoop(l, -1)
+
+	c.emit(code.OpPop) // the iterator
+
+	c.replaceInstruction(clear, c.instruction(code.OpClearLocals, firstLocal, c.symbolTable.NumLocals()-firstLocal))
+	c.leaveBlock()
+
+	return nil
+}
+
+func (c *Compiler) enterLoop(start int) *loop {
+	scope := c.scope()
+	l := &loop{depth: scope.depth, start: start, tries: len(scope.tries)}
+	scope.loops = append(scope.loops, l)
+
+	return l
+}
+
+// leaveLoop ends the innermost loop, pointing its breaks and the given
+// exit jump, if any, past it.
+func (c *Compiler) leaveLoop(l *loop, exit int) {
+	scope := c.scope()
+	scope.loops = scope.loops[:len(scope.loops)-1]
+
+	end := len(c.currentInstructions())
+	if exit >= 0 {
+		c.changeOperand(exit, end)
+	}
+	for _, pos := range l.breaks {
+		c.changeOperand(pos, end)
+	}
+
+	scope.depth = l.depth
+}
+
+func (c *Compiler) compileLoopExit(node ast.Statement, isBreak bool) error {
+	scope := c.scope()
+	if len(scope.loops) == 0 {
+		return c.errorf("%s outside loop", node.TokenLiteral())
+	}
+	l := scope.loops[len(scope.loops)-1]
+
+	if err := c.leaveTries(l.tries); err != nil {
+		return err
+	}
+
+	depth := scope.depth
+	for i := l.depth; i < depth; i++ {
+		c.emit(code.OpPop)
+	}
+
+	if isBreak {
+		l.breaks = append(l.breaks, c.emit(code.OpJump, 9999))
+	} else {
+		c.emit(code.OpJump, l.start)
+	}
+
+	// what follows is unreachable, compile it as if nothing was popped
+	scope.depth = depth
+
+	return nil
+}
+
+func (c *Compiler) compileTryStatement(node *ast.TryStatement) error {
+	scope := c.scope()
+	depth := scope.depth
+
+	t := &tryBlock{finally: node.Finally, handler: true}
+	scope.tries = append(scope.tries, t)
+
+	setup := c.emit(code.OpSetupTry, 9999)
+	if err := c.compileBlockValue(node.Block); err != nil {
+		return err
+	}
+	c.emit(code.OpPopTry)
+	t.handler = false
+
+	// where errors go that the finally block has to see first
+	finallySetup := setup
+
+	if node.Catch != nil {
+		over := c.emit(code.OpJump, 9999)
+
+		// the VM unwinds to depth and pushes the caught exception
+		c.changeOperand(setup, len(c.currentInstructions()))
+		scope.depth = depth + 1
+
+		c.enterBlock()
+		clear := c.emit(code.OpClearLocals, c.symbolTable.NumLocals(), 0)
+		firstLocal := c.symbolTable.NumLocals()
+
+		c.storeSymbol(c.symbolTable.Define(node.Param.Value), true)
+		c.declareLets(node.Catch)
+
+		if node.Finally != nil {
+			t.handler = true
+			finallySetup = c.emit(code.OpSetupTry, 9999)
+		}
+
+		if err := c.compileBlockValue(node.Catch); err != nil {
+			return err
+		}
+
+		if node.Finally != nil {
+			c.emit(code.OpPopTry)
+			t.handler = false
+		}
+
+		c.replaceInstruction(clear, c.instruction(code.OpClearLocals, firstLocal, c.symbolTable.NumLocals()-firstLocal))
+		c.leaveBlock()
+
+		c.changeOperand(over, len(c.currentInstructions()))
+	}
+
+	scope.tries = scope.tries[:len(scope.tries)-1]
+
+	if node.Finally != nil {
+		if err := c.compileStatements(node.Finally); err != nil {
+			return err
+		}
+		end := c.emit(code.OpJump, 9999)
+
+		// an error nobody caught: run the finally block and raise it again
+		c.changeOperand(finallySetup, len(c.currentInstructions()))
+		scope.depth = depth + 1
+
+		if err := c.compileStatements(node.Finally); err != nil {
+			return err
+		}
+		c.emit(code.OpThrow)
+
+		scope.depth = depth + 1
+		c.changeOperand(end, len(c.currentInstructions()))
+	}
+
+	return nil
+}
+
+// leaveTries emits what jumping out of the try statements from the
+// innermost one down to tries[level] takes: removing their handlers and
+// running their finally blocks.
+func (c *Compiler) leaveTries(level int) error {
+	scope := c.scope()
+	tries, loops := scope.tries, scope.loops
+	defer func() { scope.tries, scope.loops = tries, loops }()
+
+	for i := len(tries) - 1; i >= level; i-- {
+		t := tries[i]
+
+		if t.handler {
+			c.emit(code.OpPopTry)
+		}
+
+		if t.finally == nil {
+			continue
+		}
+
+		// break and continue in the finally block refer to loops around
+		// the try statement
+		scope.tries = tries[:i]
+		scope.loops = loops
+		for j, l := range loops {
+			if l.tries > i {
+				scope.loops = loops[:j]
+				break
+			}
+		}
+
+		if err := c.compileStatements(t.finally); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *Compiler) compileFunctionLiteral(node *ast.FunctionLiteral) error {
+	c.enterScope()
+
+	for _, p := range node.Parameters {
+		c.symbolTable.DefineParameter(p.Value)
+	}
+	c.declareLets(node.Body)
+
+	if err := c.compileBlockValue(node.Body); err != nil {
+		return err
+	}
+	c.emit(code.OpReturnValue)
+
+	table := c.symbolTable
+	freeSymbols := table.FreeSymbols
+	scope := c.leaveScope()
+
+	freeNames := []string{}
+	for _, s := range freeSymbols {
+		freeNames = append(freeNames, s.Name)
+
+		switch s.Scope {
+		case LocalScope:
+			c.emit(code.OpLocalCell, s.Index)
+		case FreeScope:
+			c.emit(code.OpFreeCell, s.Index)
+		}
+	}
+
+	fn := &object.CompiledFunction{
+		Instructions:  scope.instructions,
+		SourceMap:     scope.sourceMap,
+		NumLocals:     table.NumLocals(),
+		NumParameters: len(node.Parameters),
+		Name:          node.Name,
+		LocalNames:    table.LocalNames(),
+		FreeNames:     freeNames,
+		Literal:       node,
+	}
+
+	c.emit(code.OpClosure, c.addConstant(fn), len(freeSymbols))
+
+	return nil
+}
+
+func (c *Compiler) loadSymbol(s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(code.OpGetGlobal, c.addName(s.Name))
+	case LocalScope:
+		c.emit(code.OpGetLocal, s.Index)
+	case FreeScope:
+		c.emit(code.OpGetFree, s.Index)
+	}
+}
+
+// storeSymbol pops the top of the stack into s. Globals are either defined
+// or assigned, which fails when they do not exist yet.
+func (c *Compiler) storeSymbol(s Symbol, define bool) {
+	switch s.Scope {
+	case GlobalScope:
+		name := c.addName(s.Name)
+		if define {
+			c.emit(code.OpDefineGlobal, name)
+		} else {
+			c.emit(code.OpSetGlobal, name)
+		}
+	case LocalScope:
+		c.emit(code.OpSetLocal, s.Index)
+	case FreeScope:
+		c.emit(code.OpSetFree, s.Index)
+	}
+}
+
+func (c *Compiler) Bytecode() *Bytecode {
+	for _, constant := range c.constants {
+		if fn, ok := constant.(*object.CompiledFunction); ok {
+			fn.Constants = c.constants
+		}
+	}
+
+	return &Bytecode{
+		Main: &object.CompiledFunction{
+			Instructions: c.currentInstructions(),
+			SourceMap:    c.scope().sourceMap,
+			NumLocals:    c.symbolTable.NumLocals(),
+			LocalNames:   c.symbolTable.LocalNames(),
+			Constants:    c.constants,
+		},
+		Constants: c.constants,
+	}
+}
+
+func (c *Compiler) addConstant(obj object.Object) int {
+	c.constants = append(c.constants, obj)
+	return len(c.constants) - 1
+}
+
+// addName returns the constant holding name, adding it the first time.
+func (c *Compiler) addName(name string) int {
+	if i, ok := c.names[name]; ok {
+		return i
+	}
+
+	i := c.addConstant(&object.String{Value: name})
+	c.names[name] = i
+
+	return i
+}
+
+func (c *Compiler) emit(op code.Opcode, operands ...int) int {
+	ins := c.instruction(op, operands...)
+	pos := c.addInstruction(ins)
+
+	scope := c.scope()
+	scope.sourceMap.Add(pos, c.pos)
+	scope.depth += stackEffect(op, operands)
+
+	return pos
+}
+
+// stackEffect is how many values op leaves on the stack minus how many it
+// takes. For jumps it is the effect when execution falls through.
+func stackEffect(op code.Opcode, operands []int) int {
+	switch op {
+	case code.OpConstant, code.OpTrue, code.OpFalse, code.OpNull, code.OpNil,
+		code.OpGetGlobal, code.OpGetLocal, code.OpGetFree, code.OpLocalCell, code.OpFreeCell,
+		code.OpImport:
+		return 1
+	case code.OpPop, code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpMod,
+		code.OpEqual, code.OpNotEqual, code.OpLessThan, code.OpGreaterThan,
+		code.OpLessEqual, code.OpGreaterEqual, code.OpJumpNotTruthy,
+		code.OpDefineGlobal, code.OpSetGlobal, code.OpSetLocal, code.OpSetFree,
+		code.OpIndex, code.OpReturnValue, code.OpThrow:
+		return -1
+	case code.OpSetIndex:
+		return -2
+	case code.OpArray, code.OpHash:
+		return 1 - operands[0]
+	case code.OpClosure:
+		return 1 - operands[1]
+	case code.OpCall:
+		return -operands[0]
+	case code.OpIterNext:
+		return 2
+	}
+
+	return 0
+}
+
+func (c *Compiler) addInstruction(ins []byte) int {
+	scope := c.scope()
+	posNewInstruction := len(scope.instructions)
+	scope.instructions = append(scope.instructions, ins...)
+
+	return posNewInstruction
+}
+
+func (c *Compiler) replaceInstruction(pos int, newInstruction []byte) {
+	ins := c.currentInstructions()
+
+	for i := 0; i < len(newInstruction); i++ {
+		ins[pos+i] = newInstruction[i]
+	}
+}
+
+func (c *Compiler) changeOperand(opPos int, operand int) {
+	op := code.Opcode(c.currentInstructions()[opPos])
+	newInstruction := c.instruction(op, operand)
+
+	c.replaceInstruction(opPos, newInstruction)
+}
+
+// instruction is code.Make, failing the compilation when an operand does
+// not fit in its width.
+func (c *Compiler) instruction(op code.Opcode, operands ...int) []byte {
+	def, err := code.Lookup(byte(op))
+	if err != nil {
+		return code.Make(op, operands...)
+	}
+
+	for i, operand := range operands {
+		if operand < 1<<(8*def.OperandWidths[i]) || c.err != nil {
+			continue
+		}
+
+		what := "constants"
+		switch op {
+		case code.OpGetLocal, code.OpSetLocal, code.OpLocalCell, code.OpClearLocals:
+			what = "local bindings in a function"
+		case code.OpGetFree, code.OpSetFree, code.OpFreeCell:
+			what = "free variables in a function"
+		case code.OpClosure:
+			if i == 1 {
+				what = "free variables in a function"
+			}
+		case code.OpCall:
+			what = "arguments in a call"
+		case code.OpArray, code.OpHash:
+			what = "elements in a literal"
+		case code.OpJump, code.OpJumpNotTruthy, code.OpIterNext, code.OpSetupTry:
+			what = "instructions in a function"
+		}
+		c.err = fmt.Errorf("%s: %w: too many %s", c.pos, ErrTooLarge, what)
+	}
+
+	return code.Make(op, operands...)
+}
+
+func (c *Compiler) scope() *CompilationScope {
+	return c.scopes[c.scopeIndex]
+}
+
+func (c *Compiler) currentInstructions() code.Instructions {
+	return c.scope().instructions
+}
+
+func (c *Compiler) enterScope() {
+	scope := &CompilationScope{
+		instructions: code.Instructions{},
+		sourceMap:    &code.SourceMap{},
+	}
+	c.scopes = append(c.scopes, scope)
+	c.scopeIndex++
+
+	c.symbolTable = NewEnclosedSymbolTable(c.symbolTable)
+}
+
+func (c *Compiler) leaveScope() *CompilationScope {
+	scope := c.scope()
+
+	c.scopes = c.scopes[:len(c.scopes)-1]
+	c.scopeIndex--
+
+	c.symbolTable = c.symbolTable.Outer
+
+	return scope
+}
+
+func (c *Compiler) enterBlock() {
+	c.symbolTable = NewBlockSymbolTable(c.symbolTable)
+}
+
+func (c *Compiler) leaveBlock() {
+	c.symbolTable = c.symbolTable.Outer
+}
+
+func (c *Compiler) errorf(format string, a ...interface{}) error {
+	return fmt.Errorf("%s: %s", c.pos, fmt.Sprintf(format, a...))
+}
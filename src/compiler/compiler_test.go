@@ -0,0 +1,185 @@
+package compiler
+
+import (
+	"monkey/src/code"
+	"monkey/src/lexer"
+	"monkey/src/object"
+	"monkey/src/parser"
+	"testing"
+)
+
+type compilerTestCase struct {
+	input                string
+	expectedConstants    []interface{}
+	expectedInstructions []code.Instructions
+}
+
+func TestCompile(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "1 + 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "let x = 3; x",
+			expectedConstants: []interface{}{3, "x"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpDefineGlobal, 1),
+				code.Make(code.OpNil),
+				code.Make(code.OpPop),
+				code.Make(code.OpGetGlobal, 1),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `import "lib.mky"; lib.x`,
+			expectedConstants: []interface{}{"lib.mky", "lib", "x"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpImport, 0),
+				code.Make(code.OpDefineGlobal, 1),
+				code.Make(code.OpNil),
+				code.Make(code.OpPop),
+				code.Make(code.OpGetGlobal, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpIndex),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "while true { break }",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpJumpNotTruthy, 10),
+				code.Make(code.OpJump, 10),
+				code.Make(code.OpJump, 0),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "fn(a) { let b = a; fn() { b } }",
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpSetLocal, 1),
+					code.Make(code.OpLocalCell, 1),
+					code.Make(code.OpClosure, 0, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+
+		compiler := New()
+		if err := compiler.Compile(program); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		bytecode := compiler.Bytecode()
+
+		testInstructions(t, tt.input, tt.expectedInstructions, bytecode.Main.Instructions)
+
+		if len(bytecode.Constants) != len(tt.expectedConstants) {
+			t.Fatalf("%q: wrong number of constants. want=%d, got=%d", tt.input, len(tt.expectedConstants), len(bytecode.Constants))
+		}
+
+		for i, constant := range tt.expectedConstants {
+			switch constant := constant.(type) {
+			case int:
+				integer, ok := bytecode.Constants[i].(*object.Integer)
+				if !ok || integer.Value != int64(constant) {
+					t.Errorf("%q: constant %d is not %d. got=%s", tt.input, i, constant, bytecode.Constants[i].Inspect())
+				}
+			case string:
+				str, ok := bytecode.Constants[i].(*object.String)
+				if !ok || str.Value != constant {
+					t.Errorf("%q: constant %d is not %q. got=%s", tt.input, i, constant, bytecode.Constants[i].Inspect())
+				}
+			case []code.Instructions:
+				fn, ok := bytecode.Constants[i].(*object.CompiledFunction)
+				if !ok {
+					t.Fatalf("%q: constant %d is not a function. got=%T", tt.input, i, bytecode.Constants[i])
+				}
+				testInstructions(t, tt.input, constant, fn.Instructions)
+			}
+		}
+	}
+}
+
+func testInstructions(t *testing.T, input string, expected []code.Instructions, actual code.Instructions) {
+	t.Helper()
+
+	concatted := code.Instructions{}
+	for _, ins := range expected {
+		concatted = append(concatted, ins...)
+	}
+
+	if concatted.String() != actual.String() {
+		t.Errorf("%q: wrong instructions.\nwant=\n%s\ngot=\n%s", input, concatted, actual)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("a")
+
+	outer := NewEnclosedSymbolTable(global)
+	outer.DefineParameter("b")
+	outer.DefinePending("c")
+
+	block := NewBlockSymbolTable(outer)
+	block.Define("d")
+
+	inner := NewEnclosedSymbolTable(block)
+	inner.DefineParameter("e")
+
+	tests := []struct {
+		table    *SymbolTable
+		name     string
+		expected Symbol
+	}{
+		{global, "a", Symbol{Name: "a", Scope: GlobalScope}},
+		{outer, "b", Symbol{Name: "b", Scope: LocalScope, Index: 0}},
+		{outer, "c", Symbol{Name: "c", Scope: GlobalScope}}, // still pending
+		{block, "d", Symbol{Name: "d", Scope: LocalScope, Index: 2}},
+		{inner, "e", Symbol{Name: "e", Scope: LocalScope, Index: 0}},
+		{inner, "a", Symbol{Name: "a", Scope: GlobalScope}},
+		{inner, "c", Symbol{Name: "c", Scope: FreeScope, Index: 0}},
+		{inner, "d", Symbol{Name: "d", Scope: FreeScope, Index: 1}},
+		{inner, "undefined", Symbol{Name: "undefined", Scope: GlobalScope}},
+	}
+
+	for _, tt := range tests {
+		if got := tt.table.Resolve(tt.name); got != tt.expected {
+			t.Errorf("%s resolved wrongly. want=%+v, got=%+v", tt.name, tt.expected, got)
+		}
+	}
+
+	if outer.NumLocals() != 3 || block.NumLocals() != 3 {
+		t.Errorf("block locals should take slots in the function's frame. got=%d", outer.NumLocals())
+	}
+
+	outer.Settle("c")
+	if got := outer.Resolve("c"); got.Scope != LocalScope || got.Index != 1 {
+		t.Errorf("c should resolve to its slot once settled. got=%+v", got)
+	}
+}
@@ -0,0 +1,176 @@
+package compiler
+
+type SymbolScope string
+
+const (
+	GlobalScope SymbolScope = "GLOBAL"
+	LocalScope  SymbolScope = "LOCAL"
+	FreeScope   SymbolScope = "FREE"
+)
+
+// Symbol is what a name resolved to. Globals live in the environment the
+// program runs against and are looked up by name, so their Index is unused.
+type Symbol struct {
+	Name  string
+	Scope SymbolScope
+	Index int
+}
+
+// SymbolTable holds the names of one scope. A function gets a table of its
+// own; the body of a for loop and a catch block get a block table, whose
+// locals take slots in the frame of the function they belong to.
+type SymbolTable struct {
+	Outer *SymbolTable
+
+	FreeSymbols []Symbol // the enclosing scopes' symbols a function captures
+
+	store    map[string]Symbol
+	free     map[string]Symbol
+	pending  map[string]bool
+	function bool
+	frame    *SymbolTable // the table owning the slots, itself for functions
+
+	numLocals  int
+	localNames []string
+}
+
+// NewSymbolTable returns the table for the top level of a program.
+func NewSymbolTable() *SymbolTable {
+	s := newSymbolTable(nil)
+	s.frame = s
+	return s
+}
+
+// NewEnclosedSymbolTable returns the table for a function body.
+func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
+	s := newSymbolTable(outer)
+	s.function = true
+	s.frame = s
+	return s
+}
+
+// NewBlockSymbolTable returns the table for a block scope inside outer.
+func NewBlockSymbolTable(outer *SymbolTable) *SymbolTable {
+	s := newSymbolTable(outer)
+	s.frame = outer.frame
+	return s
+}
+
+func newSymbolTable(outer *SymbolTable) *SymbolTable {
+	return &SymbolTable{
+		Outer:   outer,
+		store:   make(map[string]Symbol),
+		free:    make(map[string]Symbol),
+		pending: make(map[string]bool),
+	}
+}
+
+// IsGlobal reports whether names defined in s are globals.
+func (s *SymbolTable) IsGlobal() bool {
+	return s.frame == s && !s.function
+}
+
+// NumLocals is how many slots the frame owning s needs.
+func (s *SymbolTable) NumLocals() int {
+	return s.frame.numLocals
+}
+
+// LocalNames are the names of the frame's slots, by index.
+func (s *SymbolTable) LocalNames() []string {
+	return s.frame.localNames
+}
+
+// Define binds name in this scope. Defining a name again reuses its slot.
+func (s *SymbolTable) Define(name string) Symbol {
+	if symbol, ok := s.store[name]; ok {
+		return symbol
+	}
+
+	if s.IsGlobal() {
+		symbol := Symbol{Name: name, Scope: GlobalScope}
+		s.store[name] = symbol
+		return symbol
+	}
+
+	return s.defineSlot(name)
+}
+
+func (s *SymbolTable) defineSlot(name string) Symbol {
+	symbol := Symbol{Name: name, Scope: LocalScope, Index: s.frame.numLocals}
+	s.frame.numLocals++
+	s.frame.localNames = append(s.frame.localNames, name)
+
+	s.store[name] = symbol
+
+	return symbol
+}
+
+// DefineParameter binds a function parameter to the next slot. Unlike
+// Define it always takes a new slot, as every argument needs one even when
+// parameters repeat a name.
+func (s *SymbolTable) DefineParameter(name string) Symbol {
+	return s.defineSlot(name)
+}
+
+// DefinePending binds name like Define, but until Settle is called only
+// functions nested in this scope resolve to it. That is
+// how `let x = ...` behaves when evaluated: the right side still sees any
+// outer x, while functions created there see the new one once called.
+func (s *SymbolTable) DefinePending(name string) Symbol {
+	if symbol, ok := s.store[name]; ok {
+		return symbol
+	}
+
+	s.pending[name] = true
+
+	return s.Define(name)
+}
+
+// Settle ends the pending state of name set by DefinePending.
+func (s *SymbolTable) Settle(name string) {
+	delete(s.pending, name)
+}
+
+func (s *SymbolTable) defineFree(original Symbol) Symbol {
+	s.FreeSymbols = append(s.FreeSymbols, original)
+
+	symbol := Symbol{Name: original.Name, Scope: FreeScope, Index: len(s.FreeSymbols) - 1}
+	s.free[original.Name] = symbol
+
+	return symbol
+}
+
+// Resolve looks name up from this scope outwards. Names that are not bound
+// in any enclosing function resolve to globals.
+func (s *SymbolTable) Resolve(name string) Symbol {
+	symbol, ok := s.resolve(name, false)
+	if !ok {
+		return Symbol{Name: name, Scope: GlobalScope}
+	}
+	return symbol
+}
+
+func (s *SymbolTable) resolve(name string, nested bool) (Symbol, bool) {
+	if symbol, ok := s.store[name]; ok && (nested || !s.pending[name]) {
+		return symbol, true
+	}
+
+	if symbol, ok := s.free[name]; ok {
+		return symbol, true
+	}
+
+	if s.Outer == nil {
+		return Symbol{}, false
+	}
+
+	symbol, ok := s.Outer.resolve(name, nested || s.function)
+	if !ok || symbol.Scope == GlobalScope {
+		return symbol, ok
+	}
+
+	if s.function {
+		return s.defineFree(symbol), true
+	}
+
+	return symbol, true
+}
@@ -1,140 +0,0 @@
-package evaluator
-
-import (
-	"fmt"
-	"monkey/src/object"
-)
-
-var builtins = map[string]*object.Builtin{
-	"len": {
-		Name: "len",
-		Fn: func(args ...object.Object) object.Object {
-			if len(args) != 1 {
-				return newError("wrong number of arguments to `len`. got=%d, want=1", len(args))
-			}
-
-			switch arg := args[0].(type) {
-			case *object.String:
-				return &object.Integer{Value: int64(len(arg.Value))}
-			case *object.Array:
-				return &object.Integer{Value: int64(len(arg.Elements))}
-			case *object.Hash:
-				return &object.Integer{Value: int64(len(arg.Pairs))}
-			default:
-				return newError("argument to `len` not supported, got=%s", args[0].Type())
-			}
-		},
-	},
-	"first": {
-		Name: "first",
-		Fn: func(args ...object.Object) object.Object {
-			if len(args) != 1 {
-				return newError("wrong number of arguments to `len`. got=%d, want=1", len(args))
-			}
-
-			if args[0].Type() != object.ARRAY_OBJ {
-				return newError("argument to `first` must be ARRAY, got %s", args[0].Type())
-			}
-
-			arr := args[0].(*object.Array)
-			if len(arr.Elements) > 0 {
-				return arr.Elements[0]
-			}
-
-			return NULL
-		},
-	},
-	"last": {
-		Name: "last",
-		Fn: func(args ...object.Object) object.Object {
-			if len(args) != 1 {
-				return newError("wrong number of arguments to `len`. got=%d, want=1", len(args))
-			}
-
-			if args[0].Type() != object.ARRAY_OBJ {
-				return newError("argument to `last` must be ARRAY, got %s", args[0].Type())
-			}
-
-			arr := args[0].(*object.Array)
-			if len(arr.Elements) > 0 {
-				return arr.Elements[len(arr.Elements)-1]
-			}
-
-			return NULL
-		},
-	},
-	"rest": {
-		Name: "rest",
-		Fn: func(args ...object.Object) object.Object {
-			if len(args) != 1 {
-				return newError("wrong number of arguments to `len`. got=%d, want=1", len(args))
-			}
-
-			if args[0].Type() != object.ARRAY_OBJ {
-				return newError("argument to `rest` must be ARRAY, got %s", args[0].Type())
-			}
-
-			arr := args[0].(*object.Array)
-			if len(arr.Elements) > 0 {
-				return &object.Array{
-					Elements: arr.Elements[1:],
-				}
-			}
-
-			return NULL
-		},
-	},
-	"push": {
-		Name: "push",
-		Fn: func(args ...object.Object) object.Object {
-			if len(args) != 2 {
-				return newError("wrong number of arguments to `len`. got=%d, want=2", len(args))
-			}
-
-			if args[0].Type() != object.ARRAY_OBJ {
-				return newError("first argument to `push` must be ARRAY, got %s", args[0].Type())
-			}
-
-			arr := args[0].(*object.Array)
-			return &object.Array{
-				Elements: append(arr.Elements, args[1]),
-			}
-
-		},
-	},
-	"puts": {
-		Name: "puts",
-		Fn: func(args ...object.Object) object.Object {
-			for _, arg := range args {
-				fmt.Println(arg.Inspect())
-			}
-
-			return NULL
-		},
-	},
-	"range": {
-		Name: "range",
-		Fn: func(args ...object.Object) object.Object {
-			if len(args) != 2 {
-				return newError("wrong number of arguments to `range`. got=%d, want=2", len(args))
-			}
-
-			if args[0].Type() != object.INTEGER_OBJ || args[1].Type() != object.INTEGER_OBJ {
-				return newError("arg must be INTEGERS")
-			}
-
-			start := args[0].(*object.Integer).Value
-			end := args[1].(*object.Integer).Value
-
-			arr := make([]object.Object, end-start)
-
-			for i := range arr {
-				arr[i] = &object.Integer{Value: start + int64(i)}
-			}
-
-			return &object.Array{
-				Elements: arr,
-			}
-		},
-	},
-}
@@ -1,25 +1,47 @@
 package evaluator
 
 import (
-	"bytes"
 	"fmt"
+	"math"
 	"monkey/src/ast"
 	"monkey/src/object"
-	"strings"
+	"monkey/src/token"
 )
 
 var (
-	NULL  = &object.Null{}
-	TRUE  = &object.Boolean{Value: true}
-	FALSE = &object.Boolean{Value: false}
+	NULL     = object.NULL
+	TRUE     = object.TRUE
+	FALSE    = object.FALSE
+	BREAK    = &object.Break{}
+	CONTINUE = &object.Continue{}
 )
 
-func Eval(node ast.Node, env *object.Environment, buffer *bytes.Buffer) object.Object {
+func evalNode(node ast.Node, env *object.Environment, s *state) object.Object {
+	if err := s.Step(); err != nil {
+		err.Pos = node.Pos()
+		return err
+	}
+
+	result := eval(node, env, s)
+
+	// errors are raised without a position, the innermost node they pass
+	// through is where they happened.
+	if err, ok := result.(*object.Error); ok && !err.Pos.IsValid() {
+		err.Pos = node.Pos()
+	}
+
+	return result
+}
+
+func eval(node ast.Node, env *object.Environment, s *state) object.Object {
 	switch node := node.(type) {
 	case *ast.IntegerLiteral:
 
 		return &object.Integer{Value: node.Value}
 
+	case *ast.FloatLiteral:
+		return &object.Float{Value: node.Value}
+
 	case *ast.StringLiteral:
 		return &object.String{Value: node.Value}
 
@@ -27,48 +49,52 @@ func Eval(node ast.Node, env *object.Environment, buffer *bytes.Buffer) object.O
 		return nativeBoolToBooleanObject(node.Value)
 
 	case *ast.PrefixExpression:
-		right := Eval(node.Right, env, buffer)
-		if isError(right) {
+		right := evalNode(node.Right, env, s)
+		if isAbrupt(right) {
 			return right
 		}
 		return evalPrefixExpression(node.Operator, right)
 
 	case *ast.InfixExpression:
-		left := Eval(node.Left, env, buffer)
-		if isError(left) {
+		if node.Operator == "&&" || node.Operator == "||" {
+			return evalLogicalExpression(node, env, s)
+		}
+
+		left := evalNode(node.Left, env, s)
+		if isAbrupt(left) {
 			return left
 		}
-		right := Eval(node.Right, env, buffer)
-		if isError(right) {
+		right := evalNode(node.Right, env, s)
+		if isAbrupt(right) {
 			return right
 		}
-		return evalInfixExpression(node.Operator, left, right)
+		return evalInfixExpression(node.Operator, left, right, s)
 
 	case *ast.ReturnStatement:
-		val := Eval(node.ReturnValue, env, buffer)
-		if isError(val) {
+		val := evalNode(node.ReturnValue, env, s)
+		if isAbrupt(val) {
 			return val
 		}
 		return &object.ReturnValue{Value: val}
 
 	case *ast.Program:
-		return evalProgram(node.Statements, env, buffer)
+		return evalProgram(node.Statements, env, s)
 
 	case *ast.BlockStatement:
-		return evalBlockStatement(node, env, buffer)
+		return evalBlockStatement(node, env, s)
 
 	case *ast.IfExpression:
-		return evalIfExpression(node, env, buffer)
+		return evalIfExpression(node, env, s)
 
 	case *ast.ExpressionStatement:
-		return Eval(node.Expression, env, buffer)
+		return evalNode(node.Expression, env, s)
 
 	case *ast.LetStatement:
-		val := Eval(node.Value, env, buffer)
-		if isError(val) {
+		val := evalNode(node.Value, env, s)
+		if isAbrupt(val) {
 			return val
 		}
-		env.Set(node.Name.Value, val)
+		setIdentifier(node.Name, val, env)
 
 	case *ast.Identifier:
 		return evalIdentifier(node, env)
@@ -76,35 +102,39 @@ func Eval(node ast.Node, env *object.Environment, buffer *bytes.Buffer) object.O
 	case *ast.FunctionLiteral:
 		params := node.Parameters
 		body := node.Body
-		return &object.Function{Parameters: params, Env: env, Body: body}
+		return &object.Function{Name: node.Name, Parameters: params, Env: env, Body: body, Slots: node.Slots}
 
 	case *ast.CallExpression:
-		function := Eval(node.Function, env, buffer)
-		if isError(function) {
+		function := evalNode(node.Function, env, s)
+		if isAbrupt(function) {
 			return function
 		}
-		args := evalExpressions(node.Arguments, env, buffer)
-		if len(args) == 1 && isError(args[0]) {
+		args := evalExpressions(node.Arguments, env, s)
+		if len(args) == 1 && isAbrupt(args[0]) {
 			return args[0]
 		}
 
-		return applyFunction(function, args, buffer)
+		return applyFunction(function, args, node.Pos(), s)
 
 	case *ast.ArrayLiteral:
-		elements := evalExpressions(node.Elements, env, buffer)
-		if len(elements) == 1 && isError(elements[0]) {
+		elements := evalExpressions(node.Elements, env, s)
+		if len(elements) == 1 && isAbrupt(elements[0]) {
 			return elements[0]
 		}
 
+		if err := s.Alloc(object.ArraySize(int64(len(elements)))); err != nil {
+			return err
+		}
+
 		return &object.Array{Elements: elements}
 
 	case *ast.IndexExpression:
-		left := Eval(node.Left, env, buffer)
-		if isError(left) {
+		left := evalNode(node.Left, env, s)
+		if isAbrupt(left) {
 			return left
 		}
-		index := Eval(node.Index, env, buffer)
-		if isError(index) {
+		index := evalNode(node.Index, env, s)
+		if isAbrupt(index) {
 			return index
 		}
 
@@ -112,113 +142,240 @@ func Eval(node ast.Node, env *object.Environment, buffer *bytes.Buffer) object.O
 
 	case *ast.AssignStatement:
 
-		val := Eval(node.Value, env, buffer)
-		if isError(val) {
+		val := evalNode(node.Value, env, s)
+		if isAbrupt(val) {
 			return val
 		}
 
-		ok := env.UpdateValue(node.Variable.Value, val)
-		if !ok {
-			return newError("invalid assignment to non declared identifier %s", node.Variable.Value)
+		if !assignIdentifier(node.Variable, val, env) {
+			return newError(object.NAME_ERROR, "invalid assignment to non declared identifier %s", node.Variable.Value)
 		}
 
 	case *ast.IndexAssignmentExpression:
-		index := Eval(node.Index.Index, env, buffer)
-		if isError(index) {
+		index := evalNode(node.Index.Index, env, s)
+		if isAbrupt(index) {
 			return index
 		}
 
-		val := Eval(node.Value, env, buffer)
-		if isError(val) {
+		val := evalNode(node.Value, env, s)
+		if isAbrupt(val) {
 			return val
 		}
 
-		left := Eval(node.Index.Left, env, buffer)
+		left := evalNode(node.Index.Left, env, s)
+		if isAbrupt(left) {
+			return left
+		}
 
-		return evalIndexAssignmentExpression(left, index, val)
+		return evalIndexAssignmentExpression(left, index, val, s)
 
 	case *ast.ForStatement:
-		iterator := Eval(node.Iterator, env, buffer)
-		if isError(iterator) {
-			return iterator
+		return evalForStatement(node, env, s)
+
+	case *ast.WhileStatement:
+		return evalWhileStatement(node, env, s)
+
+	case *ast.BreakStatement:
+		return BREAK
+
+	case *ast.ContinueStatement:
+		return CONTINUE
+
+	case *ast.TryStatement:
+		return evalTryStatement(node, env, s)
+
+	case *ast.ThrowStatement:
+		val := evalNode(node.Value, env, s)
+		if isAbrupt(val) {
+			return val
 		}
+		return throw(val)
 
-		forEnv := object.NewEnclosedEnvironement(env)
+	case *ast.HashLiteral:
+		return evalHashLiteral(node, env, s)
 
-		switch {
-		case iterator.Type() == object.ARRAY_OBJ:
-			arr := iterator.(*object.Array)
-			for i, v := range arr.Elements {
+	case *ast.MemberExpression:
+		obj := evalNode(node.Object, env, s)
+		if isAbrupt(obj) {
+			return obj
+		}
+		return evalMemberExpression(obj, node.Property.Value)
+
+	case *ast.ImportStatement:
+		module := s.interp.Import(node.Path.Value, node.Pos().Filename, func(program *ast.Program, env *object.Environment) object.Object {
+			return evalNode(program, env, s)
+		})
+		if isAbrupt(module) {
+			return module
+		}
+		setIdentifier(node.Name, module, env)
 
-				forEnv.Set(node.Index.Value, &object.Integer{Value: int64(i)})
-				forEnv.Set(node.Value.Value, v)
+	case *ast.ExportStatement:
+		return evalNode(node.Statement, env, s)
+	}
 
-				evalBlockStatement(node.Block, forEnv, buffer)
+	return nil
+}
 
-			}
-		case iterator.Type() == object.STRING_OBJ:
-			str := iterator.(*object.String)
-			for i, v := range str.Value {
-				forEnv.Set(node.Index.Value, &object.Integer{Value: int64(i)})
-				forEnv.Set(node.Value.Value, &object.String{Value: string(v)})
+func evalForStatement(node *ast.ForStatement, env *object.Environment, s *state) object.Object {
+	iterator := evalNode(node.Iterator, env, s)
+	if isAbrupt(iterator) {
+		return iterator
+	}
 
-				evalBlockStatement(node.Block, forEnv, buffer)
+	// every iteration gets a scope of its own, so closures created in the
+	// body keep the index and value of their iteration
+	iterate := func(index, value object.Object) (object.Object, bool) {
+		iterEnv := object.NewSlotEnvironment(env, node.Slots)
+		setIdentifier(node.Index, index, iterEnv)
+		setIdentifier(node.Value, value, iterEnv)
+
+		return evalLoopBody(node.Block, iterEnv, s)
+	}
+
+	switch {
+	case iterator.Type() == object.ARRAY_OBJ:
+		arr := iterator.(*object.Array)
+		for i, v := range arr.Elements {
+			if result, next := iterate(&object.Integer{Value: int64(i)}, v); !next {
+				return result
 			}
+		}
 
-		case iterator.Type() == object.HASH_OBJ:
-			pairs := iterator.(*object.Hash)
-			for _, v := range pairs.Pairs {
-				forEnv.Set(node.Index.Value, v.Key)
-				forEnv.Set(node.Value.Value, v.Value)
+	case iterator.Type() == object.STRING_OBJ:
+		str := iterator.(*object.String)
+		for i, v := range str.Value {
+			if result, next := iterate(&object.Integer{Value: int64(i)}, &object.String{Value: string(v)}); !next {
+				return result
+			}
+		}
 
-				evalBlockStatement(node.Block, forEnv, buffer)
+	case iterator.Type() == object.HASH_OBJ:
+		pairs := iterator.(*object.Hash)
+		for _, v := range pairs.Pairs() {
+			if result, next := iterate(v.Key, v.Value); !next {
+				return result
 			}
+		}
 
-		default:
-			return newError("for iterator must resolve to array, string or hash got %T", iterator)
+	default:
+		return newError(object.TYPE_ERROR, "for iterator must resolve to array, string or hash got %s", iterator.Type())
+	}
+
+	return NULL
+}
+
+func evalTryStatement(node *ast.TryStatement, env *object.Environment, s *state) object.Object {
+	result := evalNode(node.Block, env, s)
+
+	if err, ok := result.(*object.Error); ok && IsLimitError(err) {
+		return err
+	} else if ok && node.Catch != nil {
+		catchEnv := object.NewSlotEnvironment(env, node.CatchSlots)
+		setIdentifier(node.Param, &object.Exception{Err: err}, catchEnv)
+
+		result = evalNode(node.Catch, catchEnv, s)
+	}
+
+	if node.Finally != nil {
+		// a finally block that returns, breaks or fails replaces whatever
+		// the try or catch block produced
+		finally := evalNode(node.Finally, env, s)
+		switch finally.(type) {
+		case *object.ReturnValue, *object.Break, *object.Continue, *object.Error:
+			return finally
 		}
+	}
 
-		return NULL
+	return result
+}
 
-	case *ast.HashLiteral:
-		return evalHashLiteral(node, env, buffer)
+// throw turns the value of a throw statement into an error. A caught
+// exception is thrown again as it is.
+func throw(val object.Object) *object.Error {
+	switch val := val.(type) {
+	case *object.Exception:
+		return val.Err
+	case *object.String:
+		return &object.Error{Kind: object.THROWN_ERROR, Message: val.Value, Value: val}
+	default:
+		return &object.Error{Kind: object.THROWN_ERROR, Message: val.Inspect(), Value: val}
 	}
+}
 
-	return nil
+func evalWhileStatement(node *ast.WhileStatement, env *object.Environment, s *state) object.Object {
+	for {
+		condition := evalNode(node.Condition, env, s)
+		if isAbrupt(condition) {
+			return condition
+		}
+
+		if !isTruthy(condition) {
+			return NULL
+		}
+
+		if result, next := evalLoopBody(node.Block, env, s); !next {
+			return result
+		}
+	}
+}
+
+// evalLoopBody runs one iteration of a loop. It reports whether the loop
+// goes on and, when it does not, what the loop statement evaluates to.
+func evalLoopBody(block *ast.BlockStatement, env *object.Environment, s *state) (object.Object, bool) {
+	result := evalNode(block, env, s)
+
+	switch result.(type) {
+	case *object.Break:
+		return NULL, false
+	case *object.ReturnValue, *object.Error:
+		return result, false
+	}
+
+	return nil, true
 }
 
-func applyFunction(fn object.Object, args []object.Object, buffer *bytes.Buffer) object.Object {
+func applyFunction(fn object.Object, args []object.Object, pos token.Position, s *state) object.Object {
 	switch fn := fn.(type) {
 	case *object.Function:
-		extendedEnv := extendFunctionEnv(fn, args)
-		evaluated := Eval(fn.Body, extendedEnv, buffer)
-		return unwrapReturnValue(evaluated)
-	case *object.Builtin:
-		if fn.Name == "puts" {
-			var put object.BuiltinFunction = func(args ...object.Object) object.Object {
-				values := []string{}
-				for _, arg := range args {
-					values = append(values, arg.Inspect())
-				}
+		if len(args) != len(fn.Parameters) {
+			return newError(object.TYPE_ERROR, "wrong number of arguments to `%s`. got=%d, want=%d",
+				functionName(fn), len(args), len(fn.Parameters))
+		}
 
-				buffer.WriteString(strings.Join(values, ", ") + "\n")
+		if err := s.Enter(); err != nil {
+			return err
+		}
+		defer s.Leave()
 
-				return NULL
-			}
-			return put(args...)
+		extendedEnv := extendFunctionEnv(fn, args)
+		evaluated := evalNode(fn.Body, extendedEnv, s)
+
+		if err, ok := evaluated.(*object.Error); ok {
+			err.Stack = append(err.Stack, object.Frame{Function: fn.Name, Pos: pos})
 		}
-		return fn.Fn(args...)
+
+		return unwrapReturnValue(evaluated)
+	case *object.Builtin:
+		return fn.Fn(s, args...)
 	default:
-		return newError("not a function: %s", fn.Type())
+		return newError(object.TYPE_ERROR, "not a function: %s", fn.Type())
 	}
 
 }
 
+func functionName(fn *object.Function) string {
+	if fn.Name == "" {
+		return "fn"
+	}
+	return fn.Name
+}
+
 func extendFunctionEnv(fn *object.Function, args []object.Object) *object.Environment {
-	env := object.NewEnclosedEnvironement(fn.Env)
+	env := object.NewSlotEnvironment(fn.Env, fn.Slots)
 
 	for paramIdx, param := range fn.Parameters {
-		env.Set(param.Value, args[paramIdx])
+		setIdentifier(param, args[paramIdx], env)
 	}
 
 	return env
@@ -232,12 +389,12 @@ func unwrapReturnValue(obj object.Object) object.Object {
 	return obj
 }
 
-func evalExpressions(exps []ast.Expression, env *object.Environment, buffer *bytes.Buffer) []object.Object {
+func evalExpressions(exps []ast.Expression, env *object.Environment, s *state) []object.Object {
 	var result []object.Object
 
 	for _, e := range exps {
-		evaluated := Eval(e, env, buffer)
-		if isError(evaluated) {
+		evaluated := evalNode(e, env, s)
+		if isAbrupt(evaluated) {
 			return []object.Object{evaluated}
 		}
 
@@ -247,15 +404,17 @@ func evalExpressions(exps []ast.Expression, env *object.Environment, buffer *byt
 	return result
 }
 
-func evalIfExpression(ie *ast.IfExpression, env *object.Environment, buffer *bytes.Buffer) object.Object {
-	condition := Eval(ie.Condition, env, buffer)
-	if isError(condition) {
+func evalIfExpression(ie *ast.IfExpression, env *object.Environment, s *state) object.Object {
+	condition := evalNode(ie.Condition, env, s)
+	if isAbrupt(condition) {
 		return condition
 	}
 	if isTruthy(condition) {
-		return Eval(ie.Consequence, env, buffer)
+		return evalNode(ie.Consequence, env, s)
+	} else if ie.ElseIf != nil {
+		return evalNode(ie.ElseIf, env, s)
 	} else if ie.Alternative != nil {
-		return Eval(ie.Alternative, env, buffer)
+		return evalNode(ie.Alternative, env, s)
 	} else {
 		return NULL
 	}
@@ -274,11 +433,11 @@ func isTruthy(obj object.Object) bool {
 	}
 }
 
-func evalProgram(stmts []ast.Statement, env *object.Environment, buffer *bytes.Buffer) object.Object {
+func evalProgram(stmts []ast.Statement, env *object.Environment, s *state) object.Object {
 	var result object.Object
 
 	for _, stmt := range stmts {
-		result = Eval(stmt, env, buffer)
+		result = evalNode(stmt, env, s)
 
 		switch result := result.(type) {
 		case *object.ReturnValue:
@@ -292,17 +451,26 @@ func evalProgram(stmts []ast.Statement, env *object.Environment, buffer *bytes.B
 	return result
 }
 
-func evalBlockStatement(block *ast.BlockStatement, env *object.Environment, buffer *bytes.Buffer) object.Object {
+func evalBlockStatement(block *ast.BlockStatement, env *object.Environment, s *state) object.Object {
 	var result object.Object
 
 	for _, stmt := range block.Statements {
-		result = Eval(stmt, env, buffer)
+		result = evalNode(stmt, env, s)
 
-		if result != nil && (result.Type() == object.RETURN_VALUE_OBJ || result.Type() == object.ERROR_OBJ) {
-			return result
+		if result != nil {
+			switch result.Type() {
+			case object.RETURN_VALUE_OBJ, object.ERROR_OBJ, object.BREAK_OBJ, object.CONTINUE_OBJ:
+				return result
+			}
 		}
 	}
 
+	// an empty block, or one ending in a statement such as let, is NULL
+	// where its value is used: in an if, a function call or a try
+	if result == nil {
+		return NULL
+	}
+
 	return result
 
 }
@@ -321,7 +489,7 @@ func evalPrefixExpression(operator string, right object.Object) object.Object {
 	case "-":
 		return evalMinusPrefixOperatorExpression(right)
 	default:
-		return newError("unknown operator: %s%s", operator, right.Type())
+		return newError(object.TYPE_ERROR, "unknown operator: %s%s", operator, right.Type())
 	}
 }
 
@@ -339,31 +507,58 @@ func evalBangOperatorExpression(right object.Object) object.Object {
 }
 
 func evalMinusPrefixOperatorExpression(right object.Object) object.Object {
-	if right.Type() != object.INTEGER_OBJ {
-		return newError("unknown operator: -%s", right.Type())
+	switch right := right.(type) {
+	case *object.Integer:
+		return &object.Integer{Value: -right.Value}
+	case *object.Float:
+		return &object.Float{Value: -right.Value}
+	default:
+		return newError(object.TYPE_ERROR, "unknown operator: -%s", right.Type())
 	}
-
-	value := right.(*object.Integer).Value
-	return &object.Integer{Value: -value}
 }
 
-func evalInfixExpression(operator string, left object.Object, right object.Object) object.Object {
+func evalInfixExpression(operator string, left object.Object, right object.Object, rt object.Runtime) object.Object {
 
 	switch {
 	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
 		return evalIntegerInfixExpression(operator, left, right)
+	case isNumber(left) && isNumber(right):
+		return evalFloatInfixExpression(operator, left, right)
 	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
-		return evalStringInfixExpression(operator, left, right)
+		return evalStringInfixExpression(operator, left, right, rt)
 	case operator == "==":
 		return nativeBoolToBooleanObject(left == right)
 	case operator == "!=":
 		return nativeBoolToBooleanObject(left != right)
 	case left.Type() != right.Type():
-		return newError("type mismatch: %s %s %s", left.Type(), operator, right.Type())
+		return newError(object.TYPE_ERROR, "type mismatch: %s %s %s", left.Type(), operator, right.Type())
 	default:
-		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+		return newError(object.TYPE_ERROR, "unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
+
+}
+
+// evalLogicalExpression evaluates && and ||. The right side is only
+// evaluated when the left side does not already decide the result.
+func evalLogicalExpression(node *ast.InfixExpression, env *object.Environment, s *state) object.Object {
+	left := evalNode(node.Left, env, s)
+	if isAbrupt(left) {
+		return left
+	}
+
+	if node.Operator == "&&" && !isTruthy(left) {
+		return FALSE
+	}
+	if node.Operator == "||" && isTruthy(left) {
+		return TRUE
+	}
+
+	right := evalNode(node.Right, env, s)
+	if isAbrupt(right) {
+		return right
 	}
 
+	return nativeBoolToBooleanObject(isTruthy(right))
 }
 
 func evalIntegerInfixExpression(operator string, left object.Object, right object.Object) object.Object {
@@ -377,48 +572,148 @@ func evalIntegerInfixExpression(operator string, left object.Object, right objec
 	case "*":
 		return &object.Integer{Value: leftVal * rightVal}
 	case "/":
+		if rightVal == 0 {
+			return newError(object.ZERO_DIVISION_ERROR, "division by zero")
+		}
 		return &object.Integer{Value: leftVal / rightVal}
+	case "%":
+		if rightVal == 0 {
+			return newError(object.ZERO_DIVISION_ERROR, "division by zero")
+		}
+		return &object.Integer{Value: leftVal % rightVal}
+	case "<":
+		return nativeBoolToBooleanObject(leftVal < rightVal)
+	case ">":
+		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal <= rightVal)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal >= rightVal)
+	case "==":
+		return nativeBoolToBooleanObject(leftVal == rightVal)
+	case "!=":
+		return nativeBoolToBooleanObject(leftVal != rightVal)
+	default:
+		return newError(object.TYPE_ERROR, "unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
+}
+
+// evalFloatInfixExpression handles arithmetic where at least one side is a
+// float, the other side is promoted.
+func evalFloatInfixExpression(operator string, left object.Object, right object.Object) object.Object {
+	leftVal := toFloat(left)
+	rightVal := toFloat(right)
+	switch operator {
+	case "+":
+		return &object.Float{Value: leftVal + rightVal}
+	case "-":
+		return &object.Float{Value: leftVal - rightVal}
+	case "*":
+		return &object.Float{Value: leftVal * rightVal}
+	case "/":
+		return &object.Float{Value: leftVal / rightVal}
+	case "%":
+		return &object.Float{Value: math.Mod(leftVal, rightVal)}
 	case "<":
 		return nativeBoolToBooleanObject(leftVal < rightVal)
 	case ">":
 		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal <= rightVal)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal >= rightVal)
 	case "==":
 		return nativeBoolToBooleanObject(leftVal == rightVal)
 	case "!=":
 		return nativeBoolToBooleanObject(leftVal != rightVal)
 	default:
-		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+		return newError(object.TYPE_ERROR, "unknown operator: %s %s %s", left.Type(), operator, right.Type())
 	}
 }
 
-func evalStringInfixExpression(operator string, left object.Object, right object.Object) object.Object {
+func isNumber(obj object.Object) bool {
+	return obj.Type() == object.INTEGER_OBJ || obj.Type() == object.FLOAT_OBJ
+}
+
+func toFloat(obj object.Object) float64 {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return float64(obj.Value)
+	case *object.Float:
+		return obj.Value
+	default:
+		return 0
+	}
+}
+
+func evalStringInfixExpression(operator string, left object.Object, right object.Object, rt object.Runtime) object.Object {
 	leftVal := left.(*object.String).Value
 	rightVal := right.(*object.String).Value
 	switch operator {
 	case "+":
+		if err := rt.Alloc(object.StringSize(int64(len(leftVal) + len(rightVal)))); err != nil {
+			return err
+		}
 		return &object.String{Value: leftVal + rightVal}
 	case "<":
 		return nativeBoolToBooleanObject(leftVal < rightVal)
 	case ">":
 		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal <= rightVal)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal >= rightVal)
 	case "==":
 		return nativeBoolToBooleanObject(leftVal == rightVal)
 	case "!=":
 		return nativeBoolToBooleanObject(leftVal != rightVal)
 	default:
-		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+		return newError(object.TYPE_ERROR, "unknown operator: %s %s %s", left.Type(), operator, right.Type())
 	}
 }
 
 func evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object {
+	if node.Local {
+		// a function called before a binding it uses was made
+		if val := env.GetAt(node.Depth, node.Slot); val != nil {
+			return val
+		}
+		return newError(object.NAME_ERROR, "identifier not found: %s", node.Value)
+	}
+
 	if val, ok := env.Get(node.Value); ok {
 		return val
 	}
-	if builtin, ok := builtins[node.Value]; ok {
+	if builtin, ok := object.Builtins[node.Value]; ok {
 		return builtin
 	}
 
-	return newError("identifier not found: " + node.Value)
+	return newError(object.NAME_ERROR, "identifier not found: %s", node.Value)
+}
+
+// setIdentifier binds the name ident declares, in the slot the resolver
+// gave it or by name in the global environment.
+func setIdentifier(ident *ast.Identifier, val object.Object, env *object.Environment) {
+	if ident.Local {
+		env.SetAt(ident.Depth, ident.Slot, val)
+	} else {
+		env.Set(ident.Value, val)
+	}
+}
+
+// assignIdentifier updates the binding ident refers to. It reports false
+// when there is none yet.
+func assignIdentifier(ident *ast.Identifier, val object.Object, env *object.Environment) bool {
+	if !ident.Local {
+		return env.UpdateValue(ident.Value, val)
+	}
+
+	if env.GetAt(ident.Depth, ident.Slot) == nil {
+		return false
+	}
+	env.SetAt(ident.Depth, ident.Slot, val)
+
+	return true
 }
 
 func evalIndexExpression(left, index object.Object) object.Object {
@@ -427,21 +722,37 @@ func evalIndexExpression(left, index object.Object) object.Object {
 		return evalArrayIndexExpression(left, index)
 	case left.Type() == object.HASH_OBJ:
 		return evalHashIndexExpression(left, index)
+	case left.Type() == object.MODULE_OBJ && index.Type() == object.STRING_OBJ:
+		module := left.(*object.Module)
+		if export, ok := module.Exports[index.(*object.String).Value]; ok {
+			return export
+		}
+		return newError(object.NAME_ERROR, "module %s has no export %s", module.Name, index.(*object.String).Value)
+	case left.Type() == object.EXCEPTION_OBJ && index.Type() == object.STRING_OBJ:
+		if field, ok := left.(*object.Exception).Field(index.(*object.String).Value); ok {
+			return field
+		}
+		return NULL
 	default:
-		return newError("index operator not supported: %s", left.Type())
+		return newError(object.TYPE_ERROR, "index operator not supported: %s", left.Type())
 	}
 }
 
-func evalIndexAssignmentExpression(left, index, value object.Object) object.Object {
+// evalMemberExpression looks up obj.name, which is obj["name"].
+func evalMemberExpression(obj object.Object, name string) object.Object {
+	return evalIndexExpression(obj, &object.String{Value: name})
+}
+
+func evalIndexAssignmentExpression(left, index, value object.Object, rt object.Runtime) object.Object {
 	switch {
 	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
 		return evalArrayIndexAssignmentExpression(left, index, value)
 
 	case left.Type() == object.HASH_OBJ:
-		return evalHashIndexAssignmnetExpression(left, index, value)
+		return evalHashIndexAssignmnetExpression(left, index, value, rt)
 
 	default:
-		return newError("index assignemnt not supported: %s", left.Type())
+		return newError(object.TYPE_ERROR, "index assignemnt not supported: %s", left.Type())
 	}
 
 }
@@ -457,35 +768,37 @@ func evalArrayIndexAssignmentExpression(left, index, value object.Object) object
 		return value
 	}
 
-	return newError("index out of range: got = %d for array of size = %d", idx, len(arr.Elements))
+	return newError(object.INDEX_ERROR, "index out of range: got = %d for array of size = %d", idx, len(arr.Elements))
 
 }
 
-func evalHashLiteral(node *ast.HashLiteral, env *object.Environment, buffer *bytes.Buffer) object.Object {
-	pairs := make(map[object.HashKey]object.HashPair)
+func evalHashLiteral(node *ast.HashLiteral, env *object.Environment, s *state) object.Object {
+	if err := s.Alloc(object.HashSize(int64(len(node.Pairs)))); err != nil {
+		return err
+	}
 
-	for keyNode, valueNode := range node.Pairs {
-		key := Eval(keyNode, env, buffer)
-		if isError(key) {
+	hash := object.NewHash(len(node.Pairs))
+
+	for _, keyNode := range node.Keys {
+		key := evalNode(keyNode, env, s)
+		if isAbrupt(key) {
 			return key
 		}
 
 		hashKey, ok := key.(object.Hashable)
 		if !ok {
-			return newError("unusable as hask key: %s", key.Type())
+			return newError(object.TYPE_ERROR, "unusable as hask key: %s", key.Type())
 		}
 
-		value := Eval(valueNode, env, buffer)
-		if isError(value) {
+		value := evalNode(node.Pairs[keyNode], env, s)
+		if isAbrupt(value) {
 			return value
 		}
 
-		hashed := hashKey.HashKey()
-		pairs[hashed] = object.HashPair{Key: key, Value: value}
-
+		hash.Set(hashKey.HashKey(), object.HashPair{Key: key, Value: value})
 	}
 
-	return &object.Hash{Pairs: pairs}
+	return hash
 }
 
 func evalArrayIndexExpression(array, index object.Object) object.Object {
@@ -505,9 +818,9 @@ func evalHashIndexExpression(hash, index object.Object) object.Object {
 	hashObject := hash.(*object.Hash)
 	key, ok := index.(object.Hashable)
 	if !ok {
-		return newError("unusable as hash key: %s", index.Type())
+		return newError(object.TYPE_ERROR, "unusable as hash key: %s", index.Type())
 	}
-	pair, ok := hashObject.Pairs[key.HashKey()]
+	pair, ok := hashObject.Get(key.HashKey())
 	if !ok {
 		return NULL
 	}
@@ -515,22 +828,28 @@ func evalHashIndexExpression(hash, index object.Object) object.Object {
 	return pair.Value
 }
 
-func evalHashIndexAssignmnetExpression(hash, index, val object.Object) object.Object {
+func evalHashIndexAssignmnetExpression(hash, index, val object.Object, rt object.Runtime) object.Object {
 	hashObject := hash.(*object.Hash)
 	key, ok := index.(object.Hashable)
 	if !ok {
-		return newError("unusable as hash key: %s", index.Type())
+		return newError(object.TYPE_ERROR, "unusable as hash key: %s", index.Type())
 	}
-	hashObject.Pairs[key.HashKey()] = object.HashPair{
+
+	if _, exists := hashObject.Get(key.HashKey()); !exists {
+		if err := rt.Alloc(object.HashPairSize); err != nil {
+			return err
+		}
+	}
+	hashObject.Set(key.HashKey(), object.HashPair{
 		Key:   index,
 		Value: val,
-	}
+	})
 
 	return NULL
 }
 
-func newError(format string, a ...interface{}) *object.Error {
-	return &object.Error{Message: fmt.Sprintf(format, a...)}
+func newError(kind object.ErrorKind, format string, a ...interface{}) *object.Error {
+	return &object.Error{Kind: kind, Message: fmt.Sprintf(format, a...)}
 }
 
 func isError(obj object.Object) bool {
@@ -540,3 +859,14 @@ func isError(obj object.Object) bool {
 
 	return false
 }
+
+// isAbrupt reports whether obj ends the evaluation of the expression it
+// comes out of: an error, or a return, break or continue leaving an if
+// expression. It goes up to the function or loop it belongs to.
+func isAbrupt(obj object.Object) bool {
+	switch obj.(type) {
+	case *object.Error, *object.ReturnValue, *object.Break, *object.Continue:
+		return true
+	}
+	return false
+}
@@ -2,11 +2,15 @@ package evaluator
 
 import (
 	"bytes"
+	"context"
 	"fmt"
+	"monkey/src/ast"
 	"monkey/src/lexer"
 	"monkey/src/object"
 	"monkey/src/parser"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestEvalIntegerExpression(t *testing.T) {
@@ -30,6 +34,9 @@ func TestEvalIntegerExpression(t *testing.T) {
 		{"3 * 3 * 3 + 10", 37},
 		{"3 * (3 * 3) + 10", 37},
 		{"(5 + 10 * 2 + 15 / 3) * 2 + -10", 50},
+		{"7 % 3", 1},
+		{"-7 % 3", -1},
+		{"2 + 10 % 4 * 3", 8},
 	}
 
 	for _, tt := range tests {
@@ -37,6 +44,29 @@ func TestEvalIntegerExpression(t *testing.T) {
 		testIntegerObject(t, evaluated, tt.expected)
 	}
 }
+func TestEvalFloatExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{"3.5", 3.5},
+		{"-2.5", -2.5},
+		{"1.5 + 1.5", 3},
+		{"1 + 0.5", 1.5},
+		{"0.5 + 1", 1.5},
+		{"10 / 4.0", 2.5},
+		{"3 * 1.5 - 1", 3.5},
+		{"(1 + 2 + 3) / 3.0", 2},
+		{"1e3 * 2", 2000},
+		{"7.5 % 2", 1.5},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		testFloatObject(t, evaluated, tt.expected)
+	}
+}
+
 func TestEvalStringExpression(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -76,6 +106,25 @@ func TestEvalBooleanExpression(t *testing.T) {
 		{`"name" != "hi"`, true},
 		{`"abc" < "add"`, true},
 		{`"abc" < "ab"`, false},
+		{"1.5 < 2", true},
+		{"2 > 1.5", true},
+		{"1 == 1.0", true},
+		{"0.1 + 0.2 == 0.3", false},
+		{"1.0 != 2", true},
+		{"1 <= 1", true},
+		{"2 <= 1", false},
+		{"1 >= 1", true},
+		{"1 >= 2", false},
+		{"1.5 >= 1", true},
+		{`"a" <= "b"`, true},
+		{`"b" >= "c"`, false},
+		{"true && true", true},
+		{"true && false", false},
+		{"false || true", true},
+		{"false || false", false},
+		{"1 < 2 && 2 < 3", true},
+		{"1 > 2 || 3 > 2", true},
+		{"1 && 0", true},
 	}
 
 	for _, tt := range tests {
@@ -116,6 +165,16 @@ func TestIfElseExpression(t *testing.T) {
 		{"if (1 > 2) { 10 }", nil},
 		{"if (1 > 2) { 10 } else { 20 }", 20},
 		{"if (1 < 2) { 10 } else { 20 }", 10},
+		{"if (1 > 2) { 10 } else if (2 > 1) { 20 } else { 30 }", 20},
+		{"if (1 > 2) { 10 } else if (2 > 3) { 20 } else { 30 }", 30},
+		{"if (1 > 2) { 10 } else if (2 > 3) { 20 }", nil},
+		{"if (1 < 2) { 10 } else if (1 / 0) { 20 }", 10},
+		{"let x = 3; if (x == 1) { 10 } else if (x == 2) { 20 } else if (x == 3) { 30 } else { 40 }", 30},
+		{"if (true) { }", nil},
+		{"if (true) { let a = 1; }", nil},
+		{"if (false) { 10 } else { let a = 1; a = 2 }", nil},
+		{"let f = fn() { let a = 1; }; f()", nil},
+		{"try { let a = 1; } finally { }", nil},
 	}
 
 	for _, tt := range tests {
@@ -182,6 +241,10 @@ return 1; }`, "unknown operator: BOOLEAN + BOOLEAN"},
 			`{"name": "hi"}[fn(x) {x}];`,
 			"unusable as hash key: FUNCTION",
 		},
+		{"1 / 0", "division by zero"},
+		{"1 % 0", "division by zero"},
+		{"true && 1 + true", "type mismatch: INTEGER + BOOLEAN"},
+		{"len(if (true) { let a = 1; })", "argument to `len` not supported, got=NULL"},
 	}
 
 	for _, tt := range tests {
@@ -199,6 +262,126 @@ return 1; }`, "unknown operator: BOOLEAN + BOOLEAN"},
 	}
 }
 
+func TestLogicalShortCircuit(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"false && 1 / 0", false},
+		{"true || 1 / 0", true},
+		{"let arr = []; len(arr) > 0 && arr[0] > 1", false},
+		{"let calls = 0; let f = fn() { calls = calls + 1; true }; false && f(); calls == 0", true},
+		{"let calls = 0; let f = fn() { calls = calls + 1; true }; true && f(); calls == 1", true},
+	}
+
+	for _, tt := range tests {
+		testBooleanObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestErrorKinds(t *testing.T) {
+	tests := []struct {
+		input string
+		kind  object.ErrorKind
+	}{
+		{"5 + true", object.TYPE_ERROR},
+		{"-true", object.TYPE_ERROR},
+		{"foobar", object.NAME_ERROR},
+		{"foobar = 1", object.NAME_ERROR},
+		{"let a = [1]; a[5] = 1", object.INDEX_ERROR},
+		{"10 / 0", object.ZERO_DIVISION_ERROR},
+		{"len(1)", object.TYPE_ERROR},
+		{"5()", object.TYPE_ERROR},
+		{"let f = fn(a, b) { a }; f(1)", object.TYPE_ERROR},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Fatalf("no error object returned for %q. got=%T(%+v)", tt.input, evaluated, evaluated)
+		}
+
+		if errObj.Kind != tt.kind {
+			t.Errorf("wrong error kind for %q. expected=%q, got=%q", tt.input, tt.kind, errObj.Kind)
+		}
+	}
+}
+
+func TestErrorStack(t *testing.T) {
+	input := `let inner = fn(x) {
+	x + true
+};
+let outer = fn(x) {
+	inner(x)
+};
+let run = fn() { fn() { outer(1) }() };
+run()`
+
+	evaluated := testEval(input)
+
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+	}
+
+	expected := []struct {
+		function string
+		pos      string
+	}{
+		{"inner", "5:2"},
+		{"outer", "7:25"},
+		{"", "7:18"},
+		{"run", "8:1"},
+	}
+
+	if len(errObj.Stack) != len(expected) {
+		t.Fatalf("wrong stack length. want=%d, got=%d (%+v)", len(expected), len(errObj.Stack), errObj.Stack)
+	}
+
+	for i, frame := range expected {
+		got := errObj.Stack[i]
+		if got.Function != frame.function || got.Pos.String() != frame.pos {
+			t.Errorf("stack[%d] wrong. want=%s at %s, got=%s at %s", i, frame.function, frame.pos, got.Function, got.Pos)
+		}
+	}
+
+	traceback := `TypeError: type mismatch: INTEGER + BOOLEAN
+    at inner (2:2)
+    at outer (5:2)
+    at <anonymous> (7:25)
+    at run (7:18)
+    at <main> (8:1)`
+
+	if errObj.Traceback() != traceback {
+		t.Errorf("wrong traceback. want=\n%s\ngot=\n%s", traceback, errObj.Traceback())
+	}
+}
+
+func TestWrongNumberOfArguments(t *testing.T) {
+	tests := []struct {
+		input  string
+		errMsg string
+	}{
+		{"let add = fn(a, b) { a + b }; add(1)", "wrong number of arguments to `add`. got=1, want=2"},
+		{"fn() { 1 }(1, 2)", "wrong number of arguments to `fn`. got=2, want=0"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Fatalf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+		}
+
+		if errObj.Message != tt.errMsg {
+			t.Errorf("wrong error message. expected=%q, got=%q", tt.errMsg, errObj.Message)
+		}
+	}
+}
+
 func TestLetStatements(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -230,6 +413,148 @@ sum;
 
 }
 
+func TestForIterationScope(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let fns = []; for i, v in [10, 20, 30] { fns = push(fns, fn() { v }) } fns[0]() + fns[2]()", 40},
+		{"let fns = []; for i, c in \"ab\" { fns = push(fns, fn() { i }) } fns[0]()", 0},
+		{"let fns = []; for i, v in [1, 2] { let w = v * 2; fns = push(fns, fn() { w }) } fns[0]()", 2},
+		{"for i, v in [1, 2] { if (i == 0) { let seen = v } else { seen } }", "identifier not found: seen"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok || errObj.Message != expected {
+				t.Errorf("%q: expected error %q. got=%T(%+v)", tt.input, expected, evaluated, evaluated)
+			}
+		}
+	}
+}
+
+func TestLoops(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let i = 0; while i < 5 { i = i + 1 }; i", 5},
+		{"let i = 0; while false { i = i + 1 }; i", 0},
+		{"let i = 0; while true { i = i + 1; if (i == 3) { break } }; i", 3},
+		{`
+let i = 0;
+let odd = 0;
+while i < 10 {
+	i = i + 1;
+	if (i % 2 == 0) { continue }
+	odd = odd + 1;
+}
+odd`, 5},
+		{"let sum = 0; for i, v in [1, 2, 3, 4] { if (v == 3) { break } sum = sum + v }; sum", 3},
+		{"let sum = 0; for i, v in [1, 2, 3, 4] { if (v == 3) { continue } sum = sum + v }; sum", 7},
+		{`
+let count = 0;
+for i, a in [1, 2, 3] {
+	for j, b in [1, 2, 3] {
+		if (b == 2) { break }
+		count = count + 1;
+	}
+}
+count`, 3},
+		{`
+let find = fn(arr, x) {
+	for i, v in arr {
+		if (v == x) { return i }
+	}
+	-1
+};
+find([5, 6, 7], 7)`, 2},
+		{`
+let countdown = fn(n) {
+	let steps = 0;
+	while n > 0 {
+		for i, v in [1] {
+			steps = steps + 1;
+		}
+		n = n - 1;
+	}
+	steps
+};
+countdown(4)`, 4},
+		{"while true { break }", nil},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		integer, ok := tt.expected.(int)
+		if ok {
+			testIntegerObject(t, evaluated, int64(integer))
+		} else {
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestLoopErrors(t *testing.T) {
+	tests := []struct {
+		input  string
+		errMsg string
+	}{
+		{"for i, v in [1, 2] { v + true }", "type mismatch: INTEGER + BOOLEAN"},
+		{"while 1 + true { }", "type mismatch: INTEGER + BOOLEAN"},
+		{"let i = 0; while i < 3 { i = i + 1; undefinedName }", "identifier not found: undefinedName"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Fatalf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+		}
+
+		if errObj.Message != tt.errMsg {
+			t.Errorf("wrong error message. expected=%q, got=%q", tt.errMsg, errObj.Message)
+		}
+	}
+}
+
+func TestControlFlowInExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		output   string
+		expected string
+	}{
+		{"let i = 0; while (i < 3) { i = i + 1; let x = if (i == 2) { break }; puts(x) }; i", "null\n", "2"},
+		{"let r = []; for i, v in [1, 2, 3] { r = push(r, if (v == 2) { continue } else { v }) }; r", "", "[1, 3]"},
+		{"let i = 0; while true { i = i + 1; puts(1 + if (i < 3) { continue } else { break }) }; i", "", "3"},
+		{"let f = fn() { let x = if (true) { return 5 }; x + 1 }; f()", "", "5"},
+		{"let f = fn() { puts(-if (true) { return [1] }); 2 }; f()", "", "[1]"},
+		{"let x = if (true) { return 5 }; x + 1", "", "5"},
+	}
+
+	for _, tt := range tests {
+		var out bytes.Buffer
+		interp := &Interpreter{Stdout: &out}
+
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		evaluated := interp.Eval(program, object.NewEnvironment())
+
+		if evaluated == nil || evaluated.Inspect() != tt.expected {
+			t.Errorf("%q: wrong result. expected=%s, got=%v", tt.input, tt.expected, evaluated)
+		}
+		if out.String() != tt.output {
+			t.Errorf("%q: wrong output. expected=%q, got=%q", tt.input, tt.output, out.String())
+		}
+	}
+}
+
 func TestFunctionObject(t *testing.T) {
 	input := "fn(x) { x + 2; };"
 
@@ -313,6 +638,7 @@ func TestBuiltinFunctions(t *testing.T) {
 		{`last([1, 2])`, 2},
 		{`len(1)`, "argument to `len` not supported, got=INTEGER"},
 		{`len("one", "trow")`, "wrong number of arguments to `len`. got=2, want=1"},
+		{`range(0, 2.5)`, "arg must be INTEGERS"},
 	}
 
 	for _, tt := range tests {
@@ -437,12 +763,12 @@ func TestHashLiterals(t *testing.T) {
 		FALSE.HashKey():                            6,
 	}
 
-	if len(result.Pairs) != len(expected) {
-		t.Fatalf("Hash has wrong num of pairs. got=%d", len(result.Pairs))
+	if result.Len() != len(expected) {
+		t.Fatalf("Hash has wrong num of pairs. got=%d", result.Len())
 	}
 
 	for expectedKey, expectedValue := range expected {
-		pair, ok := result.Pairs[expectedKey]
+		pair, ok := result.Get(expectedKey)
 		if !ok {
 			t.Errorf("no pair for give key %v in Pairs", expectedKey)
 		}
@@ -458,7 +784,7 @@ func testEval(input string) object.Object {
 	program := p.ParseProgram()
 	env := object.NewEnvironment()
 
-	r := Eval(program, env, &bytes.Buffer{})
+	r := Eval(program, env)
 
 	fmt.Printf("inter %+v", env)
 
@@ -511,6 +837,35 @@ func TestHashIndexExpressions(t *testing.T) {
 	}
 }
 
+func TestHashOrder(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`{"b": 1, "a": 2, 3: 3, true: 4}`, `{b: 1, a: 2, 3: 3, true: 4}`},
+		{`let h = {"b": 1, "a": 2}; h["c"] = 3; h["b"] = 4; h`, `{b: 4, a: 2, c: 3}`},
+		{`let keys = []; for k, v in {"z": 1, "y": 2, "x": 3} { keys = push(keys, k) } keys`, `[z, y, x]`},
+		{`let h = {"a": 1, "b": 2, "c": 3}; delete(h, "b"); h["b"] = 4; h`, `{a: 1, c: 3, b: 4}`},
+		{`let h = {"a": 1}; [delete(h, "a"), delete(h, "a"), len(h)]`, `[1, null, 0]`},
+		{`delete([], 1)`, "first argument to `delete` must be HASH, got ARRAY"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		if errObj, ok := evaluated.(*object.Error); ok {
+			if errObj.Message != tt.expected {
+				t.Errorf("%q: wrong error. want=%q, got=%q", tt.input, tt.expected, errObj.Message)
+			}
+			continue
+		}
+
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%q: want=%s, got=%s", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
 func testIntegerObject(t *testing.T, obj object.Object, expected int64) bool {
 	result, ok := obj.(*object.Integer)
 	if !ok {
@@ -525,6 +880,21 @@ func testIntegerObject(t *testing.T, obj object.Object, expected int64) bool {
 	return true
 }
 
+func testFloatObject(t *testing.T, obj object.Object, expected float64) bool {
+	result, ok := obj.(*object.Float)
+	if !ok {
+		t.Errorf("obj is not Float. got=%T", obj)
+		return false
+	}
+
+	if result.Value != expected {
+		t.Errorf("object has wrong value. want=%g got=%g", expected, result.Value)
+		return false
+	}
+
+	return true
+}
+
 func testStringObject(t *testing.T, obj object.Object, expected string) bool {
 	result, ok := obj.(*object.String)
 	if !ok {
@@ -561,3 +931,292 @@ func testBooleanObject(t *testing.T, obj object.Object, expected bool) bool {
 	}
 	return true
 }
+
+func TestErrorPositions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"5 + true", "1:1"},
+		{"let a = 1;\nlet b = a + foo;", "2:13"},
+		{"let f = fn(x) {\n  x - \"a\"\n};\nf(1)", "2:3"},
+		{`len(1)`, "1:1"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Fatalf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+		}
+
+		if errObj.Pos.String() != tt.expected {
+			t.Errorf("wrong error position. expected=%q, got=%q", tt.expected, errObj.Pos.String())
+		}
+	}
+}
+
+func TestTryCatch(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`let x = 0; try { x = 1 } catch (e) { x = 2 }; x`, 1},
+		{`let x = 0; try { x = 1 + true } catch (e) { x = 2 }; x`, 2},
+		{`let m = ""; try { [1, 2][5] = 1 } catch (e) { m = e["kind"] }; m`, "IndexError"},
+		{`let m = ""; try { len(1) } catch (e) { m = e["message"] }; m`, "argument to `len` not supported, got=INTEGER"},
+		{`let m = ""; try { throw "boom" } catch (e) { m = e["kind"] + ": " + e["message"] }; m`, "Error: boom"},
+		{`let v = 0; try { throw 42 } catch (e) { v = e["value"] }; v`, 42},
+		{`let v = 0; try { 1 / 0 } catch (e) { v = e["value"] }; v`, nil},
+		{`let f = fn() { throw "inner" }; let m = ""; try { f() } catch (e) { m = e["message"] }; m`, "inner"},
+		{`let x = 0; try { x = 1 } finally { x = x + 10 }; x`, 11},
+		{`let x = 0; try { throw "a" } catch (e) { x = 1 } finally { x = x + 10 }; x`, 11},
+		{`let f = fn() { try { return 1 } finally { 2 } }; f()`, 1},
+		{`let f = fn() { try { return 1 } finally { return 2 } }; f()`, 2},
+		{`let f = fn() { try { throw "a" } catch (e) { return e["message"] } }; f()`, "a"},
+		{`let i = 0; while true { try { i = i + 1; if (i == 3) { break } } finally { } }; i`, 3},
+		{`let m = ""; try { try { throw "a" } catch (e) { throw e } } catch (e) { m = e["message"] }; m`, "a"},
+		{`let m = ""; try { try { throw "a" } finally { m = "f" } } catch (e) { m = m + e["message"] }; m`, "fa"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			testStringObject(t, evaluated, expected)
+		default:
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestUncaughtThrow(t *testing.T) {
+	tests := []struct {
+		input    string
+		errMsg   string
+		position string
+	}{
+		{`throw "boom"`, "boom", "1:1"},
+		{"let f = fn() {\n  throw [1, 2]\n};\nf()", "[1, 2]", "2:3"},
+		{`try { throw "a" } catch (e) { throw "b" }`, "b", "1:31"},
+		{`try { 1 } finally { throw "c" }`, "c", "1:21"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Fatalf("no error object returned for %q. got=%T(%+v)", tt.input, evaluated, evaluated)
+		}
+
+		if errObj.Kind != object.THROWN_ERROR || errObj.Message != tt.errMsg {
+			t.Errorf("wrong error for %q. got=%s", tt.input, errObj.Inspect())
+		}
+
+		if errObj.Pos.String() != tt.position {
+			t.Errorf("wrong position for %q. expected=%q, got=%q", tt.input, tt.position, errObj.Pos)
+		}
+	}
+}
+
+func TestCatchScope(t *testing.T) {
+	evaluated := testEval(`try { throw "a" } catch (e) { 1 }; e`)
+
+	errObj, ok := evaluated.(*object.Error)
+	if !ok || errObj.Kind != object.NAME_ERROR {
+		t.Fatalf("expected NameError for e outside catch. got=%T(%+v)", evaluated, evaluated)
+	}
+}
+
+func testEvalContext(ctx context.Context, input string, limits Limits) object.Object {
+	l := lexer.New(input)
+	p := parser.New(l)
+	program := p.ParseProgram()
+
+	interp := &Interpreter{Limits: limits}
+
+	return interp.EvalContext(ctx, program, object.NewEnvironment())
+}
+
+func TestExecutionLimits(t *testing.T) {
+	tests := []struct {
+		input  string
+		limits Limits
+		errMsg string
+	}{
+		{"while true { }", Limits{MaxSteps: 1000}, "step limit exceeded (1000 steps)"},
+		{"let f = fn() { f() }; f()", Limits{}, "maximum call depth exceeded (10000)"},
+		{"let f = fn(n) { f(n + 1) }; f(0)", Limits{MaxCallDepth: 50}, "maximum call depth exceeded (50)"},
+		{"while true { }", Limits{Timeout: 10 * time.Millisecond}, "execution timed out after 10ms"},
+		{"while true { try { 1 } catch (e) { } }", Limits{MaxSteps: 1000}, "step limit exceeded (1000 steps)"},
+		{"let f = fn() { try { f() } catch (e) { 1 } }; f()", Limits{MaxCallDepth: 10}, "maximum call depth exceeded (10)"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEvalContext(context.Background(), tt.input, tt.limits)
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Fatalf("no error object returned for %q. got=%T(%+v)", tt.input, evaluated, evaluated)
+		}
+
+		if errObj.Kind != object.LIMIT_ERROR || errObj.Message != tt.errMsg {
+			t.Errorf("wrong error for %q. expected=%q, got=%s", tt.input, tt.errMsg, errObj.Inspect())
+		}
+	}
+}
+
+func TestMemoryLimit(t *testing.T) {
+	limits := Limits{MaxMemory: 1 << 20}
+
+	tests := []string{
+		"range(0, 1000000000)",
+		"range(0, 2305843009213693953)",
+		"range(-9223372036854775807, 9223372036854775807)",
+		"let a = []; while true { a = push(a, 1) }",
+		`let s = "x"; while true { s = s + s }`,
+		"let h = {}; let i = 0; while true { h[i] = i; i = i + 1 }",
+		"while true { let b = [1, 2, 3] }",
+		`let s = "x"; while true { try { s = s + s } catch (e) { } }`,
+	}
+
+	for _, input := range tests {
+		evaluated := testEvalContext(context.Background(), input, limits)
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Fatalf("no error object returned for %q. got=%T(%+v)", input, evaluated, evaluated)
+		}
+
+		if errObj.Kind != object.MEMORY_ERROR {
+			t.Errorf("wrong error for %q. got=%s", input, errObj.Inspect())
+		}
+	}
+}
+
+func TestRangeEndBeforeStart(t *testing.T) {
+	evaluated := testEval("len(range(5, 2))")
+
+	testIntegerObject(t, evaluated, 0)
+}
+
+func TestEvalNode(t *testing.T) {
+	program := parser.New(lexer.New("fn(x) { let y = x; y }(1); let z = fn(a) { let b = a; b }(2)")).ParseProgram()
+	env := object.NewEnvironment()
+
+	testIntegerObject(t, Eval(program.Statements[0], env), 1)
+	testIntegerObject(t, Eval(program.Statements[0].(*ast.ExpressionStatement).Expression, env), 1)
+
+	Eval(program.Statements[1], env)
+	z, _ := env.Get("z")
+	testIntegerObject(t, z, 2)
+}
+
+func TestPushCopiesArray(t *testing.T) {
+	input := "let a = push([1], 2); let b = push(a, 3); let c = push(a, 4); b[2]"
+
+	testIntegerObject(t, testEval(input), 3)
+}
+
+func TestExecutionWithinLimits(t *testing.T) {
+	input := "let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } }; f(100)"
+
+	evaluated := testEvalContext(context.Background(), input, Limits{MaxSteps: 100000, MaxCallDepth: 101})
+
+	testIntegerObject(t, evaluated, 100)
+}
+
+func TestExecutionCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	evaluated := testEvalContext(ctx, "while true { }", Limits{})
+
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+	}
+
+	if errObj.Kind != object.LIMIT_ERROR || errObj.Message != "execution cancelled" {
+		t.Errorf("wrong error. got=%s", errObj.Inspect())
+	}
+}
+
+func TestInterpreterStreams(t *testing.T) {
+	input := `
+let name = gets();
+puts("hello " + name, 1);
+puts(gets());
+gets()
+`
+	var stdout bytes.Buffer
+	interp := &Interpreter{Stdout: &stdout, Stdin: strings.NewReader("monkey\r\nbanana")}
+
+	program := parser.New(lexer.New(input)).ParseProgram()
+	evaluated := interp.Eval(program, object.NewEnvironment())
+
+	testNullObject(t, evaluated)
+
+	expected := "hello monkey, 1\nbanana\n"
+	if stdout.String() != expected {
+		t.Errorf("stdout wrong. expected=%q, got=%q", expected, stdout.String())
+	}
+}
+
+func TestInterpreterWithoutStreams(t *testing.T) {
+	program := parser.New(lexer.New(`puts("lost"); gets()`)).ParseProgram()
+	evaluated := (&Interpreter{}).Eval(program, object.NewEnvironment())
+
+	testNullObject(t, evaluated)
+}
+
+func TestNamesResolvedBeforeRunning(t *testing.T) {
+	var out bytes.Buffer
+	interp := &Interpreter{Stdout: &out}
+
+	program := parser.New(lexer.New(`puts("never"); let f = fn() { missing }`)).ParseProgram()
+	evaluated := interp.Eval(program, object.NewEnvironment())
+
+	errObj, ok := evaluated.(*object.Error)
+	if !ok || errObj.Kind != object.NAME_ERROR || errObj.Message != "identifier not found: missing" {
+		t.Fatalf("expected a NameError. got=%T(%+v)", evaluated, evaluated)
+	}
+
+	if errObj.Pos.String() != "1:31" {
+		t.Errorf("wrong error position. got=%s", errObj.Pos)
+	}
+
+	if out.Len() != 0 {
+		t.Errorf("the program should not have run. got output %q", out.String())
+	}
+}
+
+func TestLaterBindings(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let f = fn() { g() }; let g = fn() { 1 }; f()", 1},
+		{"let f = fn() { let g = fn() { h() }; let h = fn() { 2 }; g() }; f()", 2},
+		{"let f = fn() { g() }; f(); let g = fn() { 1 }", "identifier not found: g"},
+		{"let f = fn() { let g = fn() { h() }; g(); let h = fn() { 2 } }; f()", "identifier not found: h"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok || errObj.Message != expected {
+				t.Errorf("%q: expected error %q. got=%T(%+v)", tt.input, expected, evaluated, evaluated)
+			}
+		}
+	}
+}
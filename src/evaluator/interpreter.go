@@ -0,0 +1,170 @@
+package evaluator
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"monkey/src/ast"
+	"monkey/src/object"
+	"monkey/src/resolver"
+	"monkey/src/token"
+	"os"
+	"strings"
+)
+
+// Interpreter evaluates programs with its own standard streams and limits.
+// Builtins such as puts and gets go through it, so output can be sent to a
+// terminal, an HTTP response or a buffer in tests.
+type Interpreter struct {
+	Stdout io.Writer
+	Stderr io.Writer
+	Stdin  io.Reader
+	Limits Limits
+
+	// ImportPath lists the directories searched for modules not found
+	// next to the file importing them.
+	ImportPath []string
+
+	// DisableImports makes every import fail, for programs that must not
+	// read files, such as those sent to the server.
+	DisableImports bool
+
+	// Args are the arguments of the script, available to it as args.
+	Args []string
+
+	stdin       *bufio.Reader
+	stdinSource io.Reader
+
+	modules   map[string]*object.Module // loaded modules by absolute path
+	importing []importing               // modules being loaded, innermost last
+}
+
+// New returns an Interpreter attached to the process' standard streams.
+func New() *Interpreter {
+	return &Interpreter{Stdout: os.Stdout, Stderr: os.Stderr, Stdin: os.Stdin}
+}
+
+// Globals returns a new global environment holding the names every program
+// run by in starts with: args, the arguments of the script.
+func (in *Interpreter) Globals() *object.Environment {
+	args := make([]object.Object, len(in.Args))
+	for i, arg := range in.Args {
+		args[i] = &object.String{Value: arg}
+	}
+
+	env := object.NewEnvironment()
+	env.Set("args", &object.Array{Elements: args})
+
+	return env
+}
+
+// Eval evaluates node without a deadline.
+func Eval(node ast.Node, env *object.Environment) object.Object {
+	return New().Eval(node, env)
+}
+
+// Eval evaluates node in env.
+func (in *Interpreter) Eval(node ast.Node, env *object.Environment) object.Object {
+	return in.EvalContext(context.Background(), node, env)
+}
+
+// EvalContext evaluates node until it finishes, ctx is done or one of the
+// limits is hit. Hitting a limit yields an error that scripts cannot catch.
+// node is resolved first, as a program of its own when it is not one; when
+// it uses names it never binds, the first of them is returned as an error
+// and nothing runs.
+func (in *Interpreter) EvalContext(ctx context.Context, node ast.Node, env *object.Environment) object.Object {
+	if errs := resolver.Resolve(asProgram(node), env); len(errs) > 0 {
+		return errs[0]
+	}
+
+	meter, done := in.Meter(ctx, env)
+	defer done()
+
+	s := &state{Meter: meter, interp: in}
+
+	return evalNode(node, env, s)
+}
+
+// Meter starts metering a run of in in env, as NewMeter does with
+// in.Limits. What env already holds, left by the runs before, counts
+// against MaxMemory, so that runs sharing an environment cannot grow it
+// past the limit one step at a time. The returned function ends the run.
+func (in *Interpreter) Meter(ctx context.Context, env *object.Environment) (*Meter, context.CancelFunc) {
+	meter, cancel := NewMeter(ctx, in.Limits)
+	if in.Limits.MaxMemory > 0 {
+		meter.allocated = object.RetainedSize(env)
+	}
+
+	return meter, cancel
+}
+
+// asProgram returns the program made of node, which resolves it as if it
+// ran at the top level.
+func asProgram(node ast.Node) *ast.Program {
+	switch node := node.(type) {
+	case *ast.Program:
+		return node
+	case *ast.BlockStatement:
+		return &ast.Program{Statements: node.Statements}
+	case ast.Statement:
+		return &ast.Program{Statements: []ast.Statement{node}}
+	case ast.Expression:
+		tok := token.Token{Literal: node.TokenLiteral(), Pos: node.Pos()}
+		return &ast.Program{Statements: []ast.Statement{&ast.ExpressionStatement{Token: tok, Expression: node}}}
+	}
+	return &ast.Program{}
+}
+
+// StdoutWriter returns Stdout, or a writer discarding everything when it
+// is not set.
+func (in *Interpreter) StdoutWriter() io.Writer {
+	if in.Stdout == nil {
+		return io.Discard
+	}
+	return in.Stdout
+}
+
+// StderrWriter is StdoutWriter for Stderr.
+func (in *Interpreter) StderrWriter() io.Writer {
+	if in.Stderr == nil {
+		return io.Discard
+	}
+	return in.Stderr
+}
+
+// ReadLine reads the next line from Stdin without its line ending. The
+// reader is kept between runs so buffered input is not lost. A Stdin that
+// is a *bufio.Reader is read from directly, so whoever else reads the same
+// input can share its buffer.
+func (in *Interpreter) ReadLine() (string, bool) {
+	if in.Stdin == nil {
+		return "", false
+	}
+
+	if in.stdin == nil || in.stdinSource != in.Stdin {
+		in.stdin = bufio.NewReader(in.Stdin)
+		in.stdinSource = in.Stdin
+	}
+
+	line, err := in.stdin.ReadString('\n')
+	if err != nil && line == "" {
+		return "", false
+	}
+
+	return strings.TrimRight(line, "\r\n"), true
+}
+
+// state is carried through one evaluation run alongside the environment.
+// It is the object.Runtime handed to builtins.
+type state struct {
+	*Meter
+	interp *Interpreter
+}
+
+func (s *state) Stdout() io.Writer { return s.interp.StdoutWriter() }
+func (s *state) Stderr() io.Writer { return s.interp.StderrWriter() }
+
+func (s *state) ReadLine() (string, bool) {
+	return s.interp.ReadLine()
+}
@@ -0,0 +1,119 @@
+package evaluator
+
+import (
+	"context"
+	"monkey/src/object"
+	"time"
+)
+
+// DefaultMaxCallDepth bounds recursion when Limits.MaxCallDepth is zero, so
+// a runaway recursive function fails with an error instead of overflowing
+// the Go stack.
+const DefaultMaxCallDepth = 10000
+
+// checkInterval is how many steps pass between checks of the context.
+const checkInterval = 1024
+
+// Limits bounds a single evaluation. Zero values mean no limit, except for
+// MaxCallDepth which falls back to DefaultMaxCallDepth. MaxMemory also
+// counts what the environment of a run holds when it starts.
+type Limits struct {
+	MaxSteps     int64         // AST nodes evaluated, or instructions run by the VM
+	MaxCallDepth int           // nested function calls
+	MaxMemory    int64         // approximate bytes allocated for strings, arrays and hashes
+	Timeout      time.Duration // wall-clock time for the whole run
+}
+
+// Meter enforces Limits over one run. The evaluator and the bytecode VM
+// both report their steps, calls and allocations to it.
+type Meter struct {
+	ctx       context.Context
+	limits    Limits
+	steps     int64
+	depth     int
+	allocated int64
+}
+
+// NewMeter starts metering a run. The returned context carries the
+// timeout, if any, and must be cancelled once the run is over.
+func NewMeter(ctx context.Context, limits Limits) (*Meter, context.CancelFunc) {
+	cancel := context.CancelFunc(func() {})
+	if limits.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, limits.Timeout)
+	}
+
+	return &Meter{ctx: ctx, limits: limits}, cancel
+}
+
+// Step counts one unit of work and reports an error once the step budget
+// is spent or the context is done.
+func (m *Meter) Step() *object.Error {
+	m.steps++
+
+	if m.limits.MaxSteps > 0 && m.steps > m.limits.MaxSteps {
+		return newError(object.LIMIT_ERROR, "step limit exceeded (%d steps)", m.limits.MaxSteps)
+	}
+
+	if m.steps%checkInterval == 0 {
+		return m.checkContext()
+	}
+
+	return nil
+}
+
+func (m *Meter) checkContext() *object.Error {
+	switch m.ctx.Err() {
+	case nil:
+		return nil
+	case context.DeadlineExceeded:
+		if m.limits.Timeout > 0 {
+			return newError(object.LIMIT_ERROR, "execution timed out after %s", m.limits.Timeout)
+		}
+		return newError(object.LIMIT_ERROR, "execution timed out")
+	default:
+		return newError(object.LIMIT_ERROR, "execution cancelled")
+	}
+}
+
+// Enter records a function call, failing when calls nest too deep.
+func (m *Meter) Enter() *object.Error {
+	max := m.limits.MaxCallDepth
+	if max <= 0 {
+		max = DefaultMaxCallDepth
+	}
+
+	if m.depth >= max {
+		return newError(object.LIMIT_ERROR, "maximum call depth exceeded (%d)", max)
+	}
+
+	m.depth++
+
+	return nil
+}
+
+// Leave records that a function call returned.
+func (m *Meter) Leave() {
+	m.depth--
+}
+
+// Alloc accounts for size bytes about to be allocated. Strings from
+// literals are part of the program and are not counted.
+func (m *Meter) Alloc(size int64) *object.Error {
+	if m.limits.MaxMemory <= 0 {
+		return nil
+	}
+
+	if size > m.limits.MaxMemory-m.allocated {
+		return newError(object.MEMORY_ERROR, "out of memory: allocation quota of %d bytes exceeded", m.limits.MaxMemory)
+	}
+
+	m.allocated += size
+
+	return nil
+}
+
+// IsLimitError reports whether err stopped the run because of a limit.
+// Such errors are not caught by try statements.
+func IsLimitError(err *object.Error) bool {
+	return err.Kind == object.LIMIT_ERROR || err.Kind == object.MEMORY_ERROR
+}
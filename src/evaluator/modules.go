@@ -0,0 +1,133 @@
+package evaluator
+
+import (
+	"monkey/src/ast"
+	"monkey/src/lexer"
+	"monkey/src/object"
+	"monkey/src/parser"
+	"monkey/src/resolver"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// importing is a module being loaded: the absolute path it is cached by
+// and the path it was found at.
+type importing struct {
+	key  string
+	file string
+}
+
+// ModuleRunner evaluates the program of a module in the module's own global
+// environment. The evaluator and the VM each import with their own.
+type ModuleRunner func(program *ast.Program, env *object.Environment) object.Object
+
+// Import returns the module an import statement in file from refers to,
+// loading it with run the first time. Paths are looked up relative to the
+// importing file, or the working directory when there is none, and then in
+// each of the ImportPath directories.
+func (in *Interpreter) Import(path, from string, run ModuleRunner) object.Object {
+	if in.DisableImports {
+		return newError(object.IMPORT_ERROR, "imports are disabled")
+	}
+
+	file, ok := in.findModule(path, from)
+	if !ok {
+		return newError(object.IMPORT_ERROR, "cannot find module %q", path)
+	}
+
+	key, err := filepath.Abs(file)
+	if err != nil {
+		key = file
+	}
+
+	if module, ok := in.modules[key]; ok {
+		return module
+	}
+
+	for i, m := range in.importing {
+		if m.key == key {
+			cycle := []string{}
+			for _, m := range in.importing[i:] {
+				cycle = append(cycle, m.file)
+			}
+			cycle = append(cycle, file)
+			return newError(object.IMPORT_ERROR, "circular import: %s", strings.Join(cycle, " -> "))
+		}
+	}
+
+	src, err := os.ReadFile(file)
+	if err != nil {
+		return newError(object.IMPORT_ERROR, "cannot read module %q: %s", path, err)
+	}
+
+	p := parser.New(lexer.NewFile(file, string(src)))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return newError(object.IMPORT_ERROR, "cannot parse module %q: %s", path, strings.Join(p.Errors(), "; "))
+	}
+
+	env := in.Globals()
+	if errs := resolver.Resolve(program, env); len(errs) > 0 {
+		return errs[0]
+	}
+
+	in.importing = append(in.importing, importing{key: key, file: file})
+	result := run(program, env)
+	in.importing = in.importing[:len(in.importing)-1]
+
+	if isError(result) {
+		return result
+	}
+
+	module := &object.Module{
+		Name:    moduleName(file),
+		Path:    file,
+		Exports: map[string]object.Object{},
+	}
+	for _, stmt := range program.Statements {
+		if export, ok := stmt.(*ast.ExportStatement); ok {
+			name := export.Statement.Name.Value
+			if _, seen := module.Exports[name]; !seen {
+				module.Names = append(module.Names, name)
+			}
+			module.Exports[name], _ = env.Get(name)
+		}
+	}
+
+	if in.modules == nil {
+		in.modules = map[string]*object.Module{}
+	}
+	in.modules[key] = module
+
+	return module
+}
+
+func (in *Interpreter) findModule(path, from string) (string, bool) {
+	if filepath.IsAbs(path) {
+		return path, isFile(path)
+	}
+
+	dirs := append([]string{filepath.Dir(from)}, in.ImportPath...)
+	for _, dir := range dirs {
+		file := filepath.Join(dir, path)
+		if isFile(file) {
+			return file, true
+		}
+	}
+
+	return "", false
+}
+
+func isFile(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().IsRegular()
+}
+
+func moduleName(file string) string {
+	name := filepath.Base(file)
+	if i := strings.Index(name, "."); i > 0 {
+		name = name[:i]
+	}
+	return name
+}
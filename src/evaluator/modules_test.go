@@ -0,0 +1,144 @@
+package evaluator
+
+import (
+	"bytes"
+	"monkey/src/lexer"
+	"monkey/src/object"
+	"monkey/src/parser"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var testModules = map[string]string{
+	"math.mky": `
+puts("loading math");
+let square = fn(x) { x * x };
+let hidden = 1;
+export let add = fn(a, b) { a + b };
+export let sumSquares = fn(a, b) { add(square(a), square(b)) };
+export let config = {"scale": 10};
+`,
+	"lib/strings.mky": `
+import "../math.mky";
+export let twice = fn(s) { s + s };
+export let scaled = fn(x) { math.config["scale"] * x };
+`,
+	"cycle/a.mky":   `import "b.mky"; export let x = 1;`,
+	"cycle/b.mky":   `import "a.mky"; export let y = 2;`,
+	"broken.mky":    `let x = ;`,
+	"failing.mky":   `export let x = 1 / 0;`,
+	"undefined.mky": `export let f = fn() { missing };`,
+}
+
+// writeModules writes files to a temporary directory and returns it.
+func writeModules(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	for name, src := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+func evalInDir(dir, input string, out *bytes.Buffer) object.Object {
+	program := parser.New(lexer.NewFile(filepath.Join(dir, "main.mky"), input)).ParseProgram()
+	interp := &Interpreter{Stdout: out}
+
+	return interp.Eval(program, object.NewEnvironment())
+}
+
+func TestModules(t *testing.T) {
+	dir := writeModules(t, testModules)
+
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`import "math.mky"; math.add(1, 2)`, 3},
+		{`import "math.mky"; math.sumSquares(3, 4)`, 25},
+		{`import "math.mky" as m; m.config["scale"]`, 10},
+		{`import "math.mky"; math["add"](2, 2)`, 4},
+		{`import "lib/strings.mky"; strings.scaled(4)`, 40},
+		{`import "lib/strings.mky"; strings.twice("ab")`, "abab"},
+		{`let f = fn() { import "math.mky"; math.add(1, 1) }; f()`, 2},
+		{`import "math.mky"; math.square(2)`, "module math has no export square"},
+		{`import "math.mky"; math.hidden`, "module math has no export hidden"},
+		{`import "missing.mky"`, `cannot find module "missing.mky"`},
+		{`import "cycle/a.mky"`, "circular import: " + filepath.Join(dir, "cycle/a.mky") + " -> " +
+			filepath.Join(dir, "cycle/b.mky") + " -> " + filepath.Join(dir, "cycle/a.mky")},
+		{`import "broken.mky"`, `cannot parse module "broken.mky": ` + filepath.Join(dir, "broken.mky") + `:1:9: no prefix parse func for ; found`},
+		{`import "failing.mky"`, "division by zero"},
+		{`import "undefined.mky"`, "identifier not found: missing"},
+		{`let x = 1; x.y`, "index operator not supported: INTEGER"},
+	}
+
+	for _, tt := range tests {
+		evaluated := evalInDir(dir, tt.input, &bytes.Buffer{})
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			switch obj := evaluated.(type) {
+			case *object.String:
+				if obj.Value != expected {
+					t.Errorf("%q: expected %q. got=%q", tt.input, expected, obj.Value)
+				}
+			case *object.Error:
+				if obj.Message != expected {
+					t.Errorf("%q: expected error %q. got=%q", tt.input, expected, obj.Message)
+				}
+			default:
+				t.Errorf("%q: expected %q. got=%T(%+v)", tt.input, expected, evaluated, evaluated)
+			}
+		}
+	}
+}
+
+func TestModuleLoadedOnce(t *testing.T) {
+	dir := writeModules(t, testModules)
+
+	var out bytes.Buffer
+	input := `import "math.mky"; import "math.mky" as again; import "lib/strings.mky"; math == again`
+
+	evaluated := evalInDir(dir, input, &out)
+	testBooleanObject(t, evaluated, true)
+
+	if out.String() != "loading math\n" {
+		t.Errorf("expected math to run once. got output %q", out.String())
+	}
+}
+
+func TestModuleObject(t *testing.T) {
+	dir := writeModules(t, testModules)
+
+	evaluated := evalInDir(dir, `import "math.mky"; math`, &bytes.Buffer{})
+
+	module, ok := evaluated.(*object.Module)
+	if !ok {
+		t.Fatalf("expected a module. got=%T(%+v)", evaluated, evaluated)
+	}
+
+	if module.Inspect() != "<module math>" {
+		t.Errorf("wrong inspect. got=%q", module.Inspect())
+	}
+
+	names := []string{"add", "sumSquares", "config"}
+	if len(module.Names) != len(names) {
+		t.Fatalf("wrong exports. got=%v", module.Names)
+	}
+	for i, name := range names {
+		if module.Names[i] != name {
+			t.Errorf("wrong export %d. want=%s, got=%s", i, name, module.Names[i])
+		}
+	}
+}
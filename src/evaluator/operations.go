@@ -0,0 +1,36 @@
+package evaluator
+
+import "monkey/src/object"
+
+// The operations below are how the evaluator applies operators to values.
+// They are exported so the bytecode VM computes exactly the same results
+// and errors.
+
+func InfixOperation(operator string, left, right object.Object, rt object.Runtime) object.Object {
+	return evalInfixExpression(operator, left, right, rt)
+}
+
+func PrefixOperation(operator string, right object.Object) object.Object {
+	return evalPrefixExpression(operator, right)
+}
+
+func IndexOperation(left, index object.Object) object.Object {
+	return evalIndexExpression(left, index)
+}
+
+func MemberOperation(obj object.Object, name string) object.Object {
+	return evalMemberExpression(obj, name)
+}
+
+func IndexAssignment(left, index, value object.Object, rt object.Runtime) object.Object {
+	return evalIndexAssignmentExpression(left, index, value, rt)
+}
+
+// Throw turns the value of a throw statement into the error it raises.
+func Throw(val object.Object) *object.Error {
+	return throw(val)
+}
+
+func IsTruthy(obj object.Object) bool {
+	return isTruthy(obj)
+}
@@ -0,0 +1,663 @@
+// Package format prints programs in their canonical layout: a statement a
+// line, indented by four spaces a block, single spaces around operators and
+// only the parentheses the parser needs. Statements end with a semicolon,
+// but for those ending with a block and the expression whose value a block
+// gives. Arrays, hashes and calls that do not fit in the line are broken
+// into an element a line.
+// Comments stay next to the statements, elements and tokens they were next
+// to.
+package format
+
+import (
+	"monkey/src/ast"
+	"monkey/src/lexer"
+	"monkey/src/parser"
+	"monkey/src/token"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	indentWidth = 4
+	maxWidth    = 80 // columns a line may take before lists are broken
+)
+
+// SyntaxError is returned for source that does not parse.
+type SyntaxError struct {
+	Errors []string
+}
+
+func (e *SyntaxError) Error() string { return strings.Join(e.Errors, "\n") }
+
+// Source formats the program src, read from filename, which may be empty.
+func Source(filename, src string) (string, error) {
+	l := lexer.NewFile(filename, src)
+	l.KeepComments(true)
+
+	p := parser.New(l)
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		return "", &SyntaxError{Errors: p.Errors()}
+	}
+
+	return Program(program), nil
+}
+
+// Program formats program. Its comments are only printed when the lexer
+// it was parsed with kept them.
+func Program(program *ast.Program) string {
+	p := &printer{comments: program.Comments}
+	return p.statements(program.Statements, 0, -1)
+}
+
+type printer struct {
+	comments []*ast.Comment
+	next     int // index of the first comment not printed yet
+	line     int // source line of the last statement or comment printed
+}
+
+// statements prints stmts a line each at indent, with the comments before
+// end, the offset of the brace closing them, or all of them when end is
+// -1. A blank line between two statements is kept.
+func (p *printer) statements(stmts []ast.Statement, indent, end int) string {
+	var out strings.Builder
+	first := true
+
+	for i, s := range stmts {
+		out.WriteString(p.leading(s.Pos().Offset, indent, &first))
+		p.separate(&out, s.Pos().Line, &first)
+
+		out.WriteString(pad(indent) + p.statement(s, indent))
+
+		var next ast.Statement
+		limit := end
+		if i+1 < len(stmts) {
+			next = stmts[i+1]
+			limit = next.Pos().Offset
+		}
+		if needsSemicolon(s, next, end >= 0) {
+			out.WriteString(";")
+		}
+
+		p.line = s.End().Line
+		out.WriteString(p.trailing(s.End().Line, limit))
+		out.WriteString("\n")
+	}
+
+	out.WriteString(p.leading(end, indent, &first))
+
+	return out.String()
+}
+
+// separate writes a blank line when the source has one before line.
+func (p *printer) separate(out *strings.Builder, line int, first *bool) {
+	if !*first && line > p.line+1 {
+		out.WriteString("\n")
+	}
+	*first = false
+}
+
+// leading prints the comments before offset a line each, or all that are
+// left when offset is -1. first is nil when blank lines are not kept.
+func (p *printer) leading(offset, indent int, first *bool) string {
+	var out strings.Builder
+
+	for ; p.next < len(p.comments); p.next++ {
+		c := p.comments[p.next]
+		if offset >= 0 && c.Pos().Offset >= offset {
+			break
+		}
+
+		if first != nil {
+			p.separate(&out, c.Pos().Line, first)
+		}
+		out.WriteString(pad(indent) + c.Token.Literal + "\n")
+		p.line = c.End().Line
+	}
+
+	return out.String()
+}
+
+// trailing prints the comments that start on line, the line something was
+// printed to, before limit.
+func (p *printer) trailing(line, limit int) string {
+	var out strings.Builder
+
+	for ; p.next < len(p.comments); p.next++ {
+		c := p.comments[p.next]
+		if c.Pos().Line != line || limit >= 0 && c.Pos().Offset >= limit {
+			break
+		}
+
+		out.WriteString(" " + c.Token.Literal)
+		p.line = c.End().Line
+		line = p.line
+	}
+
+	return out.String()
+}
+
+// hasComments reports whether a comment starts between the offsets from
+// and to.
+func (p *printer) hasComments(from, to int) bool {
+	i := sort.Search(len(p.comments), func(i int) bool { return p.comments[i].Pos().Offset >= from })
+	return i < len(p.comments) && p.comments[i].Pos().Offset < to
+}
+
+// hasLineComments reports whether a comment that runs to the end of its
+// line, or over several lines, starts between the offsets from and to.
+func (p *printer) hasLineComments(from, to int) bool {
+	i := sort.Search(len(p.comments), func(i int) bool { return p.comments[i].Pos().Offset >= from })
+	for ; i < len(p.comments) && p.comments[i].Pos().Offset < to; i++ {
+		if isLineComment(p.comments[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+func isLineComment(c *ast.Comment) bool {
+	return strings.HasPrefix(c.Token.Literal, "//") || strings.Contains(c.Token.Literal, "\n")
+}
+
+// inline prints the comments before offset not printed yet where they go
+// before the token there: each is followed by a space or, when it runs to
+// the end of its line, by a new line indented one more than indent.
+func (p *printer) inline(offset, indent int) string {
+	var out strings.Builder
+
+	for ; p.next < len(p.comments) && p.comments[p.next].Pos().Offset < offset; p.next++ {
+		c := p.comments[p.next]
+		if strings.HasPrefix(c.Token.Literal, "//") {
+			out.WriteString(c.Token.Literal + "\n" + pad(indent+1))
+		} else {
+			out.WriteString(c.Token.Literal + " ")
+		}
+	}
+
+	return out.String()
+}
+
+// closing prints the comments before offset not printed yet where they go
+// after a token, before the closing one at offset: each is preceded by a
+// space and, when it runs to the end of its line, followed by a new line
+// indented by indent.
+func (p *printer) closing(offset, indent int) string {
+	var out strings.Builder
+
+	for ; p.next < len(p.comments) && p.comments[p.next].Pos().Offset < offset; p.next++ {
+		c := p.comments[p.next]
+		out.WriteString(" " + c.Token.Literal)
+		if strings.HasPrefix(c.Token.Literal, "//") {
+			out.WriteString("\n" + pad(indent))
+		}
+	}
+
+	return out.String()
+}
+
+// needsSemicolon reports whether s ends with a semicolon when next follows
+// it, nil at the end of a block or, when inBlock is false, of the program.
+// Statements ending with a block cannot have one, and an if expression only
+// has one when the next statement would otherwise continue it, as a call,
+// an index or a subtraction. Neither has the expression a block ends with,
+// its value.
+func needsSemicolon(s, next ast.Statement, inBlock bool) bool {
+	switch s := s.(type) {
+	case *ast.ForStatement, *ast.WhileStatement, *ast.TryStatement:
+		return false
+	case *ast.ExpressionStatement:
+		if _, ok := s.Expression.(*ast.IfExpression); ok {
+			es, ok := next.(*ast.ExpressionStatement)
+			return ok && strings.ContainsAny(start(es.Expression), "([-")
+		}
+		return next != nil || !inBlock
+	}
+	return true
+}
+
+// start returns how e starts once printed: the operator of a prefix
+// expression, the parenthesis around an operand or the bracket of an
+// array. It is "" for anything else.
+func start(e ast.Expression) string {
+	switch e := e.(type) {
+	case *ast.PrefixExpression:
+		return e.Operator
+	case *ast.ArrayLiteral:
+		return "["
+	case *ast.InfixExpression:
+		return startOperand(e.Left, parser.Precedence(e.Token.Type))
+	case *ast.CallExpression:
+		return startOperand(e.Function, parser.CALL)
+	case *ast.IndexExpression:
+		return startOperand(e.Left, parser.CALL)
+	case *ast.MemberExpression:
+		return startOperand(e.Object, parser.CALL)
+	case *ast.IndexAssignmentExpression:
+		return start(e.Index)
+	}
+	return ""
+}
+
+func startOperand(e ast.Expression, min int) string {
+	if precedence(e) < min {
+		return "("
+	}
+	return start(e)
+}
+
+func (p *printer) statement(s ast.Statement, indent int) string {
+	col := indent * indentWidth
+
+	switch s := s.(type) {
+	case *ast.LetStatement:
+		prefix := "let " + s.Name.Value + " = " + p.inline(s.Value.Pos().Offset, indent)
+		return prefix + p.expr(s.Value, indent, advance(col, prefix))
+
+	case *ast.ExportStatement:
+		return "export " + p.statement(s.Statement, indent)
+
+	case *ast.ImportStatement:
+		text := "import " + quote(s.Path.Value)
+		if s.Alias {
+			text += " as " + s.Name.Value
+		}
+		return text
+
+	case *ast.ReturnStatement:
+		prefix := "return " + p.inline(s.ReturnValue.Pos().Offset, indent)
+		return prefix + p.expr(s.ReturnValue, indent, advance(col, prefix))
+
+	case *ast.ThrowStatement:
+		prefix := "throw " + p.inline(s.Value.Pos().Offset, indent)
+		return prefix + p.expr(s.Value, indent, advance(col, prefix))
+
+	case *ast.BreakStatement, *ast.ContinueStatement:
+		return s.TokenLiteral()
+
+	case *ast.AssignStatement:
+		prefix := s.Variable.Value + " = " + p.inline(s.Value.Pos().Offset, indent)
+		return prefix + p.expr(s.Value, indent, advance(col, prefix))
+
+	case *ast.ExpressionStatement:
+		return p.expr(s.Expression, indent, col)
+
+	case *ast.ForStatement:
+		prefix := "for " + s.Index.Value + ", " + s.Value.Value + " in " + p.inline(s.Iterator.Pos().Offset, indent)
+		iterator := p.expr(s.Iterator, indent, advance(col, prefix)) + p.closing(s.Block.Pos().Offset, indent)
+		return prefix + iterator + " " + p.block(s.Block, indent)
+
+	case *ast.WhileStatement:
+		prefix := "while " + p.inline(s.Condition.Pos().Offset, indent)
+		condition := p.expr(s.Condition, indent, advance(col, prefix)) + p.closing(s.Block.Pos().Offset, indent)
+		return prefix + condition + " " + p.block(s.Block, indent)
+
+	case *ast.TryStatement:
+		text := "try " + p.inline(s.Block.Pos().Offset, indent) + p.block(s.Block, indent)
+		if s.Catch != nil {
+			text += p.closing(s.Param.Pos().Offset, indent)
+			text += " catch (" + s.Param.Value + ") " + p.inline(s.Catch.Pos().Offset, indent) + p.block(s.Catch, indent)
+		}
+		if s.Finally != nil {
+			text += p.closing(s.Finally.Pos().Offset, indent)
+			text += " finally " + p.block(s.Finally, indent)
+		}
+		return text
+	}
+
+	return s.String()
+}
+
+// block prints a block whose first line is indented by indent.
+func (p *printer) block(b *ast.BlockStatement, indent int) string {
+	if len(b.Statements) == 0 && !p.hasComments(b.Pos().Offset, b.Rbrace.Pos.Offset) {
+		return "{}"
+	}
+
+	p.line = b.Pos().Line
+	return "{\n" + p.statements(b.Statements, indent+1, b.Rbrace.Pos.Offset) + pad(indent) + "}"
+}
+
+// precedence returns how tightly e holds together once printed, as the
+// parser binds operators.
+func precedence(e ast.Expression) int {
+	switch e := e.(type) {
+	case *ast.InfixExpression:
+		return parser.Precedence(e.Token.Type)
+	case *ast.PrefixExpression:
+		return parser.PREFIX
+	case *ast.CallExpression:
+		return parser.CALL
+	case *ast.IndexExpression, *ast.MemberExpression:
+		return parser.INDEX
+	case *ast.IndexAssignmentExpression:
+		return parser.LOWEST
+	}
+	return parser.INDEX + 1
+}
+
+// prefixOperand returns the precedence the operand of e needs to go
+// without parentheses. An operand starting with the same operator always
+// keeps them, as -(-x) would read --x.
+func prefixOperand(e *ast.PrefixExpression) int {
+	if start(e.Right) == e.Operator {
+		return precedence(e.Right) + 1
+	}
+	return parser.PREFIX
+}
+
+// expr prints e, starting at column col of a line indented by indent.
+func (p *printer) expr(e ast.Expression, indent, col int) string {
+	switch e := e.(type) {
+	case *ast.StringLiteral:
+		return quote(e.Value)
+
+	case *ast.PrefixExpression:
+		op := e.Operator + p.inline(e.Right.Pos().Offset, indent)
+		return op + p.operand(e.Right, prefixOperand(e), indent, advance(col, op))
+
+	case *ast.InfixExpression:
+		prec := parser.Precedence(e.Token.Type)
+		left := p.operand(e.Left, prec, indent, col)
+		mid := " " + p.inline(e.Token.Pos.Offset, indent) + e.Operator + " " + p.inline(e.Right.Pos().Offset, indent)
+		// operators are left-associative, so a right operand of the same
+		// precedence keeps its parentheses
+		return left + mid + p.operand(e.Right, prec+1, indent, advance(col, left+mid))
+
+	case *ast.CallExpression:
+		fn := p.operand(e.Function, parser.CALL, indent, col)
+		items := []item{}
+		for _, arg := range e.Arguments {
+			items = append(items, item{value: arg})
+		}
+		return fn + p.list("(", ")", items, e.Token, e.Rparen, indent, advance(col, fn))
+
+	case *ast.ArrayLiteral:
+		items := []item{}
+		for _, el := range e.Elements {
+			items = append(items, item{value: el})
+		}
+		return p.list("[", "]", items, e.Token, e.Rbracket, indent, col)
+
+	case *ast.HashLiteral:
+		items := []item{}
+		for _, key := range e.Keys {
+			items = append(items, item{key: key, value: e.Pairs[key]})
+		}
+		return p.list("{", "}", items, e.Token, e.Rbrace, indent, col)
+
+	case *ast.IndexExpression:
+		left := p.operand(e.Left, parser.CALL, indent, col) + "[" + p.inline(e.Index.Pos().Offset, indent)
+		return left + p.expr(e.Index, indent, advance(col, left)) + p.closing(e.Rbracket.Pos.Offset, indent) + "]"
+
+	case *ast.MemberExpression:
+		return p.operand(e.Object, parser.CALL, indent, col) + "." + p.inline(e.Property.Pos().Offset, indent) + e.Property.Value
+
+	case *ast.IndexAssignmentExpression:
+		target := p.expr(e.Index, indent, col) + " = " + p.inline(e.Value.Pos().Offset, indent)
+		return target + p.expr(e.Value, indent, advance(col, target))
+
+	case *ast.FunctionLiteral:
+		next := p.next
+		if text, ok := p.oneLine(e); ok && col+width(text) <= maxWidth {
+			return text
+		}
+		p.next = next
+		return "fn(" + parameters(e) + ") " + p.inline(e.Body.Pos().Offset, indent) + p.block(e.Body, indent)
+
+	case *ast.IfExpression:
+		prefix := "if (" + p.inline(e.Condition.Pos().Offset, indent)
+		condition := p.expr(e.Condition, indent, advance(col, prefix)) + p.closing(e.Consequence.Pos().Offset, indent)
+		text := prefix + condition + ") " + p.block(e.Consequence, indent)
+		if e.ElseIf != nil {
+			text += " else " + p.inline(e.ElseIf.Pos().Offset, indent) + p.expr(e.ElseIf, indent, 0)
+		}
+		if e.Alternative != nil {
+			text += " else " + p.inline(e.Alternative.Pos().Offset, indent) + p.block(e.Alternative, indent)
+		}
+		return text
+	}
+
+	text, _ := p.oneLine(e)
+	return text
+}
+
+// operand prints e, in parentheses when it binds less tightly than min.
+func (p *printer) operand(e ast.Expression, min, indent, col int) string {
+	if precedence(e) < min {
+		return "(" + p.expr(e, indent, col+1) + ")"
+	}
+	return p.expr(e, indent, col)
+}
+
+// item is an element of an array, an argument or, with a key, a pair of a
+// hash.
+type item struct {
+	key   ast.Expression
+	value ast.Expression
+}
+
+func (it item) pos() token.Position {
+	if it.key != nil {
+		return it.key.Pos()
+	}
+	return it.value.Pos()
+}
+
+// list prints items between the tokens opening and closing. They are on
+// the line when they fit. A function passed last is let run over several
+// lines after the others. Otherwise, and always when a comment among them
+// runs to the end of its line, they are put a line each.
+func (p *printer) list(open, close string, items []item, opening, closing token.Token, indent, col int) string {
+	if !p.hasLineComments(opening.Pos.Offset, closing.Pos.Offset) {
+		next := p.next
+		if text, ok := p.oneLineItems(items); ok {
+			text = open + text + p.closing(closing.Pos.Offset, indent) + close
+			if col+width(text) <= maxWidth {
+				return text
+			}
+		}
+		p.next = next
+
+		if n := len(items); n > 0 && items[n-1].key == nil {
+			fn, isFn := items[n-1].value.(*ast.FunctionLiteral)
+			others, ok := p.oneLineItems(items[:n-1])
+			if others != "" {
+				others += ", "
+			}
+			header := open + others + "fn(" + parameters(fn) + ") {"
+			if isFn && ok && col+width(header) <= maxWidth {
+				others += p.inline(fn.Pos().Offset, indent)
+				return open + others + p.expr(fn, indent, advance(col, open+others)) + p.closing(closing.Pos.Offset, indent) + close
+			}
+			p.next = next
+		}
+	}
+
+	if len(items) == 0 && !p.hasComments(opening.Pos.Offset, closing.Pos.Offset) {
+		return open + close
+	}
+
+	var out strings.Builder
+	out.WriteString(open + "\n")
+	p.line = opening.Pos.Line
+
+	for i, it := range items {
+		out.WriteString(p.leading(it.pos().Offset, indent+1, nil))
+		out.WriteString(pad(indent+1) + p.item(it, indent+1, (indent+1)*indentWidth))
+
+		limit := closing.Pos.Offset
+		if i+1 < len(items) {
+			out.WriteString(",")
+			limit = items[i+1].pos().Offset
+		}
+		out.WriteString(p.trailing(it.value.End().Line, limit) + "\n")
+	}
+
+	out.WriteString(p.leading(closing.Pos.Offset, indent+1, nil))
+	out.WriteString(pad(indent) + close)
+
+	return out.String()
+}
+
+func (p *printer) item(it item, indent, col int) string {
+	if it.key == nil {
+		return p.expr(it.value, indent, col)
+	}
+
+	key := p.expr(it.key, indent, col) + ": " + p.inline(it.value.Pos().Offset, indent)
+	return key + p.expr(it.value, indent, advance(col, key))
+}
+
+// oneLineItems prints items on a single line, with the comments before
+// each.
+func (p *printer) oneLineItems(items []item) (string, bool) {
+	texts := []string{}
+	for _, it := range items {
+		text := p.inline(it.pos().Offset, 0)
+		if it.key != nil {
+			key, ok := p.oneLine(it.key)
+			if !ok {
+				return "", false
+			}
+			text += key + ": " + p.inline(it.value.Pos().Offset, 0)
+		}
+		value, ok := p.oneLine(it.value)
+		if !ok {
+			return "", false
+		}
+		texts = append(texts, text+value)
+	}
+
+	return strings.Join(texts, ", "), true
+}
+
+// oneLine prints e on a single line, with the comments in it. It reports
+// false when e cannot be: when it holds an if expression, a function whose
+// body is more than an expression, or a comment running to the end of its
+// line. Whoever drops what it printed puts back p.next.
+func (p *printer) oneLine(e ast.Expression) (string, bool) {
+	if p.hasLineComments(e.Pos().Offset, e.End().Offset) {
+		return "", false
+	}
+
+	switch e := e.(type) {
+	case *ast.Identifier:
+		return e.Value, true
+	case *ast.IntegerLiteral, *ast.FloatLiteral, *ast.Boolean:
+		return e.TokenLiteral(), true
+	case *ast.StringLiteral:
+		return quote(e.Value), true
+
+	case *ast.PrefixExpression:
+		op := e.Operator + p.inline(e.Right.Pos().Offset, 0)
+		right, ok := p.oneLineOperand(e.Right, prefixOperand(e))
+		return op + right, ok
+
+	case *ast.InfixExpression:
+		prec := parser.Precedence(e.Token.Type)
+		left, ok := p.oneLineOperand(e.Left, prec)
+		mid := " " + p.inline(e.Token.Pos.Offset, 0) + e.Operator + " " + p.inline(e.Right.Pos().Offset, 0)
+		right, ok2 := p.oneLineOperand(e.Right, prec+1)
+		return left + mid + right, ok && ok2
+
+	case *ast.CallExpression:
+		fn, ok := p.oneLineOperand(e.Function, parser.CALL)
+		items := []item{}
+		for _, arg := range e.Arguments {
+			items = append(items, item{value: arg})
+		}
+		args, ok2 := p.oneLineItems(items)
+		return fn + "(" + args + p.closing(e.Rparen.Pos.Offset, 0) + ")", ok && ok2
+
+	case *ast.ArrayLiteral:
+		items := []item{}
+		for _, el := range e.Elements {
+			items = append(items, item{value: el})
+		}
+		elements, ok := p.oneLineItems(items)
+		return "[" + elements + p.closing(e.Rbracket.Pos.Offset, 0) + "]", ok
+
+	case *ast.HashLiteral:
+		items := []item{}
+		for _, key := range e.Keys {
+			items = append(items, item{key: key, value: e.Pairs[key]})
+		}
+		pairs, ok := p.oneLineItems(items)
+		return "{" + pairs + p.closing(e.Rbrace.Pos.Offset, 0) + "}", ok
+
+	case *ast.IndexExpression:
+		left, ok := p.oneLineOperand(e.Left, parser.CALL)
+		left += "[" + p.inline(e.Index.Pos().Offset, 0)
+		index, ok2 := p.oneLine(e.Index)
+		return left + index + p.closing(e.Rbracket.Pos.Offset, 0) + "]", ok && ok2
+
+	case *ast.MemberExpression:
+		object, ok := p.oneLineOperand(e.Object, parser.CALL)
+		return object + "." + p.inline(e.Property.Pos().Offset, 0) + e.Property.Value, ok
+
+	case *ast.IndexAssignmentExpression:
+		target, ok := p.oneLine(e.Index)
+		target += " = " + p.inline(e.Value.Pos().Offset, 0)
+		value, ok2 := p.oneLine(e.Value)
+		return target + value, ok && ok2
+
+	case *ast.FunctionLiteral:
+		head := "fn(" + parameters(e) + ") " + p.inline(e.Body.Pos().Offset, 0)
+		switch body := e.Body.Statements; len(body) {
+		case 0:
+			return head + "{}", !p.hasComments(e.Body.Pos().Offset, e.Body.Rbrace.Pos.Offset)
+		case 1:
+			if es, ok := body[0].(*ast.ExpressionStatement); ok {
+				head += "{ " + p.inline(es.Pos().Offset, 0)
+				text, ok := p.oneLine(es.Expression)
+				return head + text + p.closing(e.Body.Rbrace.Pos.Offset, 0) + " }", ok
+			}
+		}
+	}
+
+	return "", false
+}
+
+func (p *printer) oneLineOperand(e ast.Expression, min int) (string, bool) {
+	text, ok := p.oneLine(e)
+	if precedence(e) < min {
+		text = "(" + text + ")"
+	}
+	return text, ok
+}
+
+func parameters(fn *ast.FunctionLiteral) string {
+	if fn == nil {
+		return ""
+	}
+
+	params := []string{}
+	for _, param := range fn.Parameters {
+		params = append(params, param.Value)
+	}
+	return strings.Join(params, ", ")
+}
+
+// quote returns the string literal for s, escaping what the lexer
+// unescapes.
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\t", `\t`).Replace(s) + `"`
+}
+
+func pad(indent int) string {
+	return strings.Repeat(" ", indent*indentWidth)
+}
+
+func width(s string) int {
+	return utf8.RuneCountInString(s)
+}
+
+// advance returns the column after s is printed from column col.
+func advance(col int, s string) int {
+	if i := strings.LastIndexByte(s, '\n'); i >= 0 {
+		return width(s[i+1:])
+	}
+	return col + width(s)
+}
@@ -0,0 +1,231 @@
+package format
+
+import (
+	"monkey/src/lexer"
+	"monkey/src/parser"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestSource(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"", ""},
+		{"let x=1+2*3", "let x = 1 + 2 * 3;\n"},
+		{"let x = (1 + 2) * 3 - (4 - 5) - 6;", "let x = (1 + 2) * 3 - (4 - 5) - 6;\n"},
+		{"((a)); !(a == b) || c && d", "a;\n!(a == b) || c && d;\n"},
+		{"-(-x); (a + b).c; f(1)(2)[0]", "-(-x);\n(a + b).c;\nf(1)(2)[0];\n"},
+		{"!(!x); -(!x); !(-x); -(-(-1)); -((-x)[0])", "!(!x);\n-!x;\n!-x;\n-(-(-1));\n-(-x)[0];\n"},
+		{`puts("q\"\n\t\\")`, `puts("q\"\n\t\\");` + "\n"},
+		{"let add=fn(a,b){a+b}; add(1,2)", "let add = fn(a, b) { a + b };\nadd(1, 2);\n"},
+		{"let f = fn() { let x = 1; x }", "let f = fn() {\n    let x = 1;\n    x\n};\n"},
+		{"let e = [ ]; let h = { }; let g = fn() { }", "let e = [];\nlet h = {};\nlet g = fn() {};\n"},
+		{`let h = {"a":1, true : [1,2]}`, `let h = {"a": 1, true: [1, 2]};` + "\n"},
+		{"if (x) { 1 } else if (y) { 2 } else { 3 }", "if (x) {\n    1\n} else if (y) {\n    2\n} else {\n    3\n}\n"},
+		{"while x < 3 { x = x + 1; if (x == 2) { break } }", "while x < 3 {\n    x = x + 1;\n    if (x == 2) {\n        break;\n    }\n}\n"},
+		{"for i, v in [1] { continue }", "for i, v in [1] {\n    continue;\n}\n"},
+		{"try { throw 1 } catch (e) { e } finally { 2 }", "try {\n    throw 1;\n} catch (e) {\n    e\n} finally {\n    2\n}\n"},
+		{`import "lib/a.mky" as a; import "b.mky"; export let x = a.x; h[1] = 2`,
+			"import \"lib/a.mky\" as a;\nimport \"b.mky\";\nexport let x = a.x;\nh[1] = 2;\n"},
+		{"let x = 1;\n\n\n\nlet y = 2;", "let x = 1;\n\nlet y = 2;\n"},
+		// the semicolon after an if keeps the next statement from being a
+		// call, an index or a subtraction
+		{"if (a) { 1 }; (a + b).c; if (a) { 1 }; 2; if (b) { 2 }; [3]; if (c) { 3 }; -4", "if (a) {\n    1\n};\n(a + b).c;\nif (a) {\n    1\n}\n2;\nif (b) {\n    2\n};\n[3];\nif (c) {\n    3\n};\n-4;\n"},
+		{"a; (a + b).c", "a;\n(a + b).c;\n"},
+		{"let f = fn() { g(); h[1] = 2; 3 }", "let f = fn() {\n    g();\n    h[1] = 2;\n    3\n};\n"},
+		{
+			"let list = [1111111111, 2222222222, 3333333333, 4444444444, 5555555555, 6666666666];",
+			"let list = [\n    1111111111,\n    2222222222,\n    3333333333,\n    4444444444,\n    5555555555,\n    6666666666\n];\n",
+		},
+		{
+			`puts("a long argument, longer than that", "another long argument", {"key": "value"})`,
+			"puts(\n    \"a long argument, longer than that\",\n    \"another long argument\",\n    {\"key\": \"value\"}\n);\n",
+		},
+		{"map(list, fn(x) { let y = x; y })", "map(list, fn(x) {\n    let y = x;\n    y\n});\n"},
+		// widths count characters, not bytes
+		{`let s = ["ééééééééééééééééééééééééééééé", "éééééééééééééééééééééééééééééééé"];`,
+			`let s = ["ééééééééééééééééééééééééééééé", "éééééééééééééééééééééééééééééééé"];` + "\n"},
+	}
+
+	for _, tt := range tests {
+		got, err := Source("", tt.input)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %s", tt.input, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%q: wrong format. want=\n%s\ngot=\n%s", tt.input, tt.want, got)
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := `// the header
+
+let add=fn(a,b){a+b};   // trailing
+let g = fn() {
+
+
+  // only a comment
+};
+let h = fn() {
+  let a = 1;
+
+
+  // before b
+  let b = 2; /* x */ // y
+  // at the end
+};
+let list = [
+  1, // one
+  // two next
+  2
+];
+let k = fn(x) { /* inline */ x };
+/* a block
+   comment */`
+
+	want := `// the header
+
+let add = fn(a, b) { a + b }; // trailing
+let g = fn() {
+    // only a comment
+};
+let h = fn() {
+    let a = 1;
+
+    // before b
+    let b = 2; /* x */ // y
+    // at the end
+};
+let list = [
+    1, // one
+    // two next
+    2
+];
+let k = fn(x) { /* inline */ x };
+/* a block
+   comment */
+`
+
+	got, err := Source("", input)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got != want {
+		t.Errorf("wrong format. want=\n%s\ngot=\n%s", want, got)
+	}
+}
+
+func TestCommentsInExpressions(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"puts(1, /* arg */ 2)", "puts(1, /* arg */ 2);\n"},
+		{"if (x /* cond */) { 1 }", "if (x /* cond */) {\n    1\n}\n"},
+		{"1 + // plus\n 2", "1 + // plus\n    2;\n"},
+		{"let x = /* a */ -/* b */ y[/* c */ 1 /* d */]", "let x = /* a */ -/* b */ y[/* c */ 1 /* d */];\n"},
+		{`let h = {/* k */ "a": /* v */ 1}`, `let h = {/* k */ "a": /* v */ 1};` + "\n"},
+		{"while x /* w */ { 1 }", "while x /* w */ {\n    1\n}\n"},
+		{"try { 1 } /* t */ catch (e) { 2 }", "try {\n    1\n} /* t */ catch (e) {\n    2\n}\n"},
+		{"map(list, /* f */ fn(x) { let y = x; y })", "map(list, /* f */ fn(x) {\n    let y = x;\n    y\n});\n"},
+		{"let f = fn(x) /* b */ { x }", "let f = fn(x) /* b */ { x };\n"},
+	}
+
+	for _, tt := range tests {
+		got, err := Source("", tt.input)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %s", tt.input, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%q: wrong format. want=\n%s\ngot=\n%s", tt.input, tt.want, got)
+		}
+	}
+}
+
+// TestIdempotent checks formatting formatted programs changes nothing, and
+// that formatting keeps what programs mean and their comments.
+func TestIdempotent(t *testing.T) {
+	sample, err := os.ReadFile("../../p.mky")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inputs := []string{
+		string(sample),
+		`let fib = fn(n) { if (n < 2) { return n; } fib(n - 1) + fib(n - 2) }; puts(fib(10))`,
+		`let a = if (x) {1} else {2}; let z = [fn(x) { let y = x; y }]; a.b.c(1)[2]; -(-x)`,
+		`puts("a very long string argument number one", "another long string argument", fn(x) { x })`,
+		`let h = {"a": [1111111111, 2222222222, 3333333333, 4444444444, 5555555555], "b": {"c": fn() { 1 }}}`,
+		"let xs = [\n1, // one\n[2, /* two */ 3]\n];\nwhile true { // forever\nbreak // now\n}",
+		"puts(1, /* arg */ 2); if (x /* cond */) { 1 }; 1 + // plus\n 2",
+		"let x = f(/* a */ [1, /* b */ 2], {/* c */ 1: 2 /* d */}, a./* e */ b, fn() { /* f */ })[/* g */ 0]",
+		"let g = fn(x) /* h */ { x /* i */ }; h[1] = // j\n g(2)",
+	}
+
+	for _, input := range inputs {
+		once, err := Source("", input)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %s", input, err)
+			continue
+		}
+
+		twice, err := Source("", once)
+		if err != nil {
+			t.Errorf("%q: formatted program does not parse: %s\n%s", input, err, once)
+			continue
+		}
+		if twice != once {
+			t.Errorf("%q: formatting again changed it. first=\n%s\nthen=\n%s", input, once, twice)
+		}
+
+		if before, after := parse(t, input), parse(t, once); before != after {
+			t.Errorf("%q: formatting changed the program. before=%s, after=%s", input, before, after)
+		}
+		if before, after := comments(input), comments(once); before != after {
+			t.Errorf("%q: formatting changed the comments. before=%s, after=%s", input, before, after)
+		}
+	}
+}
+
+func parse(t *testing.T, input string) string {
+	t.Helper()
+
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("%q: parse errors: %v", input, p.Errors())
+	}
+
+	return program.String()
+}
+
+// comments returns the comments of input in order.
+func comments(input string) string {
+	l := lexer.New(input)
+	l.KeepComments(true)
+
+	p := parser.New(l)
+	texts := []string{}
+	for _, c := range p.ParseProgram().Comments {
+		texts = append(texts, c.Token.Literal)
+	}
+	return strings.Join(texts, " ")
+}
+
+func TestSyntaxError(t *testing.T) {
+	_, err := Source("a.mky", "let = 1")
+
+	syntax, ok := err.(*SyntaxError)
+	if !ok {
+		t.Fatalf("expected a *SyntaxError. got=%T (%v)", err, err)
+	}
+	if !strings.HasPrefix(syntax.Error(), "a.mky:1:5: ") {
+		t.Errorf("wrong error: %q", syntax.Error())
+	}
+}
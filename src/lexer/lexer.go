@@ -3,29 +3,48 @@ package lexer
 import (
 	"bytes"
 	"monkey/src/token"
-	"regexp"
 )
 
 type Lexer struct {
+	filename     string
 	input        string
 	position     int
 	readPosition int
 	ch           byte
+
+	// line and column of ch
+	line   int
+	column int
+
+	keepComments bool
 }
 
 func New(input string) *Lexer {
-	l := &Lexer{input: input}
-	l.trimComments()
+	return NewFile("", input)
+}
+
+// NewFile returns a lexer whose token positions carry the given file name.
+func NewFile(filename, input string) *Lexer {
+	l := &Lexer{filename: filename, input: input, line: 1}
 	l.readChar()
 	return l
 }
 
-func (l *Lexer) trimComments() {
-	regex := regexp.MustCompile(`//.*|/\*[\s\S]*?\*/|("(\\.|[^"])*")`)
-	l.input = regex.ReplaceAllString(l.input, "$1")
+// KeepComments makes NextToken return COMMENT tokens instead of skipping
+// over them.
+func (l *Lexer) KeepComments(keep bool) {
+	l.keepComments = keep
 }
 
 func (l *Lexer) readChar() {
+	if l.ch == '\n' {
+		l.line += 1
+		l.column = 0
+	}
+	if l.readPosition <= len(l.input) {
+		l.column += 1
+	}
+
 	if l.readPosition >= len(l.input) {
 		l.ch = 0
 	} else {
@@ -35,11 +54,31 @@ func (l *Lexer) readChar() {
 	l.readPosition += 1
 }
 
+func (l *Lexer) currentPosition() token.Position {
+	return token.Position{
+		Filename: l.filename,
+		Offset:   l.position,
+		Line:     l.line,
+		Column:   l.column,
+	}
+}
+
 func (l *Lexer) NextToken() token.Token {
+	for {
+		tok := l.nextToken()
+		if tok.Type != token.COMMENT || l.keepComments {
+			return tok
+		}
+	}
+}
+
+func (l *Lexer) nextToken() token.Token {
 	var tok token.Token
 
 	l.skipWhitespace()
 
+	pos := l.currentPosition()
+
 	switch l.ch {
 	case '=':
 		if l.peekChar() == '=' {
@@ -54,7 +93,18 @@ func (l *Lexer) NextToken() token.Token {
 		tok = newToken(token.MINUS, l.ch)
 	case '*':
 		tok = newToken(token.ASTERISK, l.ch)
+	case '%':
+		tok = newToken(token.PERCENT, l.ch)
 	case '/':
+		if l.peekChar() == '/' || l.peekChar() == '*' {
+			literal, closed := l.readComment()
+			tok.Type, tok.Literal = token.COMMENT, literal
+			if !closed {
+				tok.Type = token.ILLEGAL
+			}
+			tok.Pos, tok.End = pos, l.currentPosition()
+			return tok
+		}
 		tok = newToken(token.SLASH, l.ch)
 	case '!':
 		if l.peekChar() == '=' {
@@ -65,9 +115,37 @@ func (l *Lexer) NextToken() token.Token {
 			tok = newToken(token.BANG, l.ch)
 		}
 	case '<':
-		tok = newToken(token.LT, l.ch)
+		if l.peekChar() == '=' {
+			ch := l.ch
+			l.readChar()
+			tok = token.Token{Type: token.LT_EQ, Literal: string(ch) + string(l.ch)}
+		} else {
+			tok = newToken(token.LT, l.ch)
+		}
 	case '>':
-		tok = newToken(token.GT, l.ch)
+		if l.peekChar() == '=' {
+			ch := l.ch
+			l.readChar()
+			tok = token.Token{Type: token.GT_EQ, Literal: string(ch) + string(l.ch)}
+		} else {
+			tok = newToken(token.GT, l.ch)
+		}
+	case '&':
+		if l.peekChar() == '&' {
+			ch := l.ch
+			l.readChar()
+			tok = token.Token{Type: token.AND, Literal: string(ch) + string(l.ch)}
+		} else {
+			tok = newToken(token.ILLEGAL, l.ch)
+		}
+	case '|':
+		if l.peekChar() == '|' {
+			ch := l.ch
+			l.readChar()
+			tok = token.Token{Type: token.OR, Literal: string(ch) + string(l.ch)}
+		} else {
+			tok = newToken(token.ILLEGAL, l.ch)
+		}
 	case ';':
 		tok = newToken(token.SEMICOLON, l.ch)
 	case '(':
@@ -76,6 +154,8 @@ func (l *Lexer) NextToken() token.Token {
 		tok = newToken(token.RPAREN, l.ch)
 	case ',':
 		tok = newToken(token.COMMA, l.ch)
+	case '.':
+		tok = newToken(token.DOT, l.ch)
 	case '+':
 		tok = newToken(token.PLUS, l.ch)
 	case '{':
@@ -94,14 +174,17 @@ func (l *Lexer) NextToken() token.Token {
 	case 0:
 		tok.Literal = ""
 		tok.Type = token.EOF
+		tok.Pos, tok.End = pos, pos
+		return tok
 	default:
-		if isLetter(l.ch) {
+		if IsLetter(l.ch) {
 			tok.Literal = l.readIdentifier()
 			tok.Type = token.LookupIdent(tok.Literal)
+			tok.Pos, tok.End = pos, l.currentPosition()
 			return tok
 		} else if isDigit(l.ch) {
-			tok.Literal = l.readNumber()
-			tok.Type = token.INT
+			tok.Type, tok.Literal = l.readNumber()
+			tok.Pos, tok.End = pos, l.currentPosition()
 			return tok
 		} else {
 			tok = newToken(token.ILLEGAL, l.ch)
@@ -109,6 +192,7 @@ func (l *Lexer) NextToken() token.Token {
 	}
 
 	l.readChar()
+	tok.Pos, tok.End = pos, l.currentPosition()
 	return tok
 }
 
@@ -121,18 +205,76 @@ func newToken(tokenType token.TokenType, ch byte) token.Token {
 
 func (l *Lexer) readIdentifier() string {
 	position := l.position
-	for isLetter(l.ch) {
+	for IsLetter(l.ch) {
 		l.readChar()
 	}
 	return l.input[position:l.position]
 }
 
-func (l *Lexer) readNumber() string {
+// readNumber reads an integer or a float. A float has a fraction ("3.14"),
+// an exponent ("1e-9") or both.
+func (l *Lexer) readNumber() (token.TokenType, string) {
 	position := l.position
+	tokenType := token.TokenType(token.INT)
+
+	l.readDigits()
+
+	if l.ch == '.' && isDigit(l.peekChar()) {
+		tokenType = token.FLOAT
+		l.readChar()
+		l.readDigits()
+	}
+
+	if l.ch == 'e' || l.ch == 'E' {
+		next := l.peekChar()
+		if (next == '+' || next == '-') && l.readPosition+1 < len(l.input) {
+			next = l.input[l.readPosition+1]
+		}
+		if isDigit(next) {
+			tokenType = token.FLOAT
+			l.readChar()
+			if l.ch == '+' || l.ch == '-' {
+				l.readChar()
+			}
+			l.readDigits()
+		}
+	}
+
+	return tokenType, l.input[position:l.position]
+}
+
+func (l *Lexer) readDigits() {
 	for isDigit(l.ch) {
 		l.readChar()
 	}
-	return l.input[position:l.position]
+}
+
+// readComment reads a // comment up to the end of the line or a /* */
+// comment up to its terminator. The literal keeps the delimiters. It
+// reports false for a /* comment the input ends in, which is read up to
+// the end.
+func (l *Lexer) readComment() (string, bool) {
+	position := l.position
+
+	if l.peekChar() == '/' {
+		for l.ch != '\n' && l.ch != 0 {
+			l.readChar()
+		}
+		return l.input[position:l.position], true
+	}
+
+	l.readChar()
+	l.readChar()
+	for !(l.ch == '*' && l.peekChar() == '/') {
+		if l.ch == 0 {
+			return l.input[position:l.position], false
+		}
+		l.readChar()
+	}
+	l.readChar()
+	l.readChar()
+
+	return l.input[position:l.position], true
 }
 
 func (l *Lexer) skipWhitespace() {
@@ -150,44 +292,37 @@ func (l *Lexer) peekChar() byte {
 }
 
 func (l *Lexer) readString() string {
-	position := l.position + 1
+	var out bytes.Buffer
+
 	for {
-		p := l.ch
 		l.readChar()
-		if (l.ch == '"' && p != '\\') || l.ch == 0 {
+		if l.ch == '"' || l.ch == 0 {
 			break
 		}
-	}
-
-	str := l.input[position:l.position]
-
-	// fmt.Print("str", str, position, l.position)
-
-	var out bytes.Buffer
 
-	var skipNext = false
-
-	for i, ch := range str {
-		if skipNext {
-			skipNext = false
-			continue
-		}
-		if ch == '\\' && i < len(str)-1 && str[i+1] == 'n' {
-			out.WriteByte(10)
-			skipNext = true
-		} else if ch == '\\' && i < len(str)-1 && str[i+1] == '"' {
-			out.WriteByte(byte('"'))
-			skipNext = true
-		} else {
-			out.WriteByte(byte(ch))
+		if l.ch == '\\' {
+			switch l.peekChar() {
+			case 'n':
+				l.readChar()
+				out.WriteByte('\n')
+				continue
+			case 't':
+				l.readChar()
+				out.WriteByte('\t')
+				continue
+			case '"', '\\':
+				l.readChar()
+			}
 		}
+
+		out.WriteByte(l.ch)
 	}
 
 	return out.String()
-
 }
 
-func isLetter(ch byte) bool {
+// IsLetter reports whether ch can be part of an identifier.
+func IsLetter(ch byte) bool {
 	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_'
 }
 
@@ -15,7 +15,7 @@ let add = fn(x, y) {
 };
 
 let result = add(five, ten);
-!-/*5;
+!-/ *5;
 5 < 10 > 5;
 
 if (5 < 10) {
@@ -33,6 +33,10 @@ if (5 < 10) {
 [1, 2];
 {"foo": "bar"}
 for i, v in arr
+while break continue
+try catch finally throw
+import "lib.mky" as lib
+export lib.x
 `
 
 	tests := []struct {
@@ -132,6 +136,21 @@ for i, v in arr
 		{token.IDENT, "v"},
 		{token.IN, "in"},
 		{token.IDENT, "arr"},
+		{token.WHILE, "while"},
+		{token.BREAK, "break"},
+		{token.CONTINUE, "continue"},
+		{token.TRY, "try"},
+		{token.CATCH, "catch"},
+		{token.FINALLY, "finally"},
+		{token.THROW, "throw"},
+		{token.IMPORT, "import"},
+		{token.STRING, "lib.mky"},
+		{token.AS, "as"},
+		{token.IDENT, "lib"},
+		{token.EXPORT, "export"},
+		{token.IDENT, "lib"},
+		{token.DOT, "."},
+		{token.IDENT, "x"},
 		{token.EOF, ""},
 	}
 
@@ -151,3 +170,196 @@ for i, v in arr
 	}
 
 }
+
+func TestTokenPositions(t *testing.T) {
+	input := "let x = 10;\n  x + \"hi\""
+
+	tests := []struct {
+		expectedLiteral string
+		expectedPos     token.Position
+		expectedEnd     token.Position
+	}{
+		{"let", token.Position{Offset: 0, Line: 1, Column: 1}, token.Position{Offset: 3, Line: 1, Column: 4}},
+		{"x", token.Position{Offset: 4, Line: 1, Column: 5}, token.Position{Offset: 5, Line: 1, Column: 6}},
+		{"=", token.Position{Offset: 6, Line: 1, Column: 7}, token.Position{Offset: 7, Line: 1, Column: 8}},
+		{"10", token.Position{Offset: 8, Line: 1, Column: 9}, token.Position{Offset: 10, Line: 1, Column: 11}},
+		{";", token.Position{Offset: 10, Line: 1, Column: 11}, token.Position{Offset: 11, Line: 1, Column: 12}},
+		{"x", token.Position{Offset: 14, Line: 2, Column: 3}, token.Position{Offset: 15, Line: 2, Column: 4}},
+		{"+", token.Position{Offset: 16, Line: 2, Column: 5}, token.Position{Offset: 17, Line: 2, Column: 6}},
+		{"hi", token.Position{Offset: 18, Line: 2, Column: 7}, token.Position{Offset: 22, Line: 2, Column: 11}},
+		{"", token.Position{Offset: 22, Line: 2, Column: 11}, token.Position{Offset: 22, Line: 2, Column: 11}},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+
+		if tok.Pos != tt.expectedPos {
+			t.Fatalf("tests[%d] - pos wrong. expected=%+v, got=%+v", i, tt.expectedPos, tok.Pos)
+		}
+
+		if tok.End != tt.expectedEnd {
+			t.Fatalf("tests[%d] - end wrong. expected=%+v, got=%+v", i, tt.expectedEnd, tok.End)
+		}
+	}
+}
+
+func TestTokenPositionFilename(t *testing.T) {
+	l := NewFile("main.mky", "\n\n  foo")
+
+	tok := l.NextToken()
+
+	if tok.Pos.String() != "main.mky:3:3" {
+		t.Fatalf("tok.Pos.String() wrong. expected=%q, got=%q", "main.mky:3:3", tok.Pos.String())
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := `// leading
+let url = "http://example.com"; /* block
+comment */ let path = "C:\\"; // trailing
+"say \"hi\" // not a comment"
+/* unterminated`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.COMMENT, "// leading"},
+		{token.LET, "let"},
+		{token.IDENT, "url"},
+		{token.ASSIGN, "="},
+		{token.STRING, "http://example.com"},
+		{token.SEMICOLON, ";"},
+		{token.COMMENT, "/* block\ncomment */"},
+		{token.LET, "let"},
+		{token.IDENT, "path"},
+		{token.ASSIGN, "="},
+		{token.STRING, `C:\`},
+		{token.SEMICOLON, ";"},
+		{token.COMMENT, "// trailing"},
+		{token.STRING, `say "hi" // not a comment`},
+		{token.ILLEGAL, "/* unterminated"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+	l.KeepComments(true)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestCommentsSkippedByDefault(t *testing.T) {
+	input := "/* a */ x // b\n y"
+
+	l := New(input)
+
+	x := l.NextToken()
+	if x.Literal != "x" || x.Pos.Column != 9 {
+		t.Fatalf("wrong token. got=%q at %s", x.Literal, x.Pos)
+	}
+
+	y := l.NextToken()
+	if y.Literal != "y" || y.Pos.String() != "2:2" {
+		t.Fatalf("wrong token. got=%q at %s", y.Literal, y.Pos)
+	}
+
+	if tok := l.NextToken(); tok.Type != token.EOF {
+		t.Fatalf("expected EOF. got=%q", tok.Type)
+	}
+}
+
+func TestNumbers(t *testing.T) {
+	input := `5 3.14 0.5 1e9 1e-9 2.5E+3 7e x.5 4.`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.INT, "5"},
+		{token.FLOAT, "3.14"},
+		{token.FLOAT, "0.5"},
+		{token.FLOAT, "1e9"},
+		{token.FLOAT, "1e-9"},
+		{token.FLOAT, "2.5E+3"},
+		{token.INT, "7"},
+		{token.IDENT, "e"},
+		{token.IDENT, "x"},
+		{token.DOT, "."},
+		{token.INT, "5"},
+		{token.INT, "4"},
+		{token.DOT, "."},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestComparisonAndLogicalOperators(t *testing.T) {
+	input := `a <= b >= c < d > e && f || g % h & |`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.IDENT, "a"},
+		{token.LT_EQ, "<="},
+		{token.IDENT, "b"},
+		{token.GT_EQ, ">="},
+		{token.IDENT, "c"},
+		{token.LT, "<"},
+		{token.IDENT, "d"},
+		{token.GT, ">"},
+		{token.IDENT, "e"},
+		{token.AND, "&&"},
+		{token.IDENT, "f"},
+		{token.OR, "||"},
+		{token.IDENT, "g"},
+		{token.PERCENT, "%"},
+		{token.IDENT, "h"},
+		{token.ILLEGAL, "&"},
+		{token.ILLEGAL, "|"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
@@ -0,0 +1,399 @@
+package lsp
+
+import (
+	"monkey/src/ast"
+	"monkey/src/lexer"
+	"monkey/src/parser"
+	"monkey/src/resolver"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// document is an open file and what the server knows of it.
+type document struct {
+	uri   string
+	text  string
+	lines []int // offset of the first byte of every line
+
+	program *ast.Program
+	errors  []parser.Error
+
+	refs    []*ref // every identifier in the program, in source order
+	scopes  []*resolver.Scope
+	defs    map[*ast.Identifier]*definition
+	symbols []DocumentSymbol
+}
+
+type definitionKind int
+
+const (
+	definedVariable definitionKind = iota
+	definedFunction                // a let binding of a function literal
+	definedParameter
+	definedModule
+)
+
+// definition is where a name is bound: a let statement, a function or
+// catch parameter, a for loop variable or an import.
+type definition struct {
+	name *ast.Identifier
+	kind definitionKind
+	fn   *ast.FunctionLiteral // the function bound, for definedFunction
+}
+
+// ref is an identifier of the program. def is nil for builtins, for names
+// bound outside the file and for properties after a dot.
+type ref struct {
+	ident    *ast.Identifier
+	def      *definition
+	property bool
+}
+
+// parse reads the text of a document and analyses it. The resolver tells
+// where names are bound, the walk of the program what binds them.
+func parse(uri, text string) *document {
+	d := &document{uri: uri, text: text, lines: []int{0}, defs: map[*ast.Identifier]*definition{}}
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\n' {
+			d.lines = append(d.lines, i+1)
+		}
+	}
+
+	l := lexer.New(text)
+	l.KeepComments(true)
+	p := parser.New(l)
+	d.program = p.ParseProgram()
+	d.errors = p.ErrorDetails()
+
+	a := &analyzer{doc: d}
+	d.symbols = a.statements(d.program.Statements)
+
+	info := resolver.Inspect(d.program)
+	d.scopes = info.Scopes
+	for _, r := range info.Refs {
+		d.refs = append(d.refs, &ref{ident: r.Ident, def: d.definitionOf(r.Def)})
+	}
+
+	sort.SliceStable(d.refs, func(i, j int) bool {
+		return d.refs[i].ident.Pos().Offset < d.refs[j].ident.Pos().Offset
+	})
+
+	return d
+}
+
+// definitionOf returns the definition made by ident, nil for nil.
+func (d *document) definitionOf(ident *ast.Identifier) *definition {
+	if ident == nil {
+		return nil
+	}
+
+	def, ok := d.defs[ident]
+	if !ok {
+		def = &definition{name: ident, kind: definedVariable}
+		d.defs[ident] = def
+	}
+
+	return def
+}
+
+// analyzer walks a program, collecting the symbols of the document, what
+// kind of definition each binding is and the properties after dots.
+type analyzer struct {
+	doc *document
+}
+
+func letDefinition(let *ast.LetStatement) *definition {
+	def := &definition{name: let.Name, kind: definedVariable}
+	if fn, ok := let.Value.(*ast.FunctionLiteral); ok && fn != nil {
+		def.kind = definedFunction
+		def.fn = fn
+	}
+	return def
+}
+
+// define records what def binds.
+func (a *analyzer) define(def *definition) {
+	if def.name != nil {
+		a.doc.defs[def.name] = def
+	}
+}
+
+func (a *analyzer) statements(stmts []ast.Statement) []DocumentSymbol {
+	symbols := []DocumentSymbol{}
+	for _, s := range stmts {
+		symbols = append(symbols, a.walk(s)...)
+	}
+	return symbols
+}
+
+func (a *analyzer) block(block *ast.BlockStatement) []DocumentSymbol {
+	if block == nil {
+		return nil
+	}
+	return a.statements(block.Statements)
+}
+
+// walk records the definitions of node and returns the symbols it defines.
+func (a *analyzer) walk(node ast.Node) []DocumentSymbol {
+	switch node := node.(type) {
+	case *ast.LetStatement:
+		children := a.walk(node.Value)
+		def := letDefinition(node)
+		a.define(def)
+		return []DocumentSymbol{a.doc.symbol(def, node, children)}
+
+	case *ast.ExportStatement:
+		return a.walk(node.Statement)
+
+	case *ast.ImportStatement:
+		def := &definition{name: node.Name, kind: definedModule}
+		a.define(def)
+		return []DocumentSymbol{a.doc.symbol(def, node, nil)}
+
+	case *ast.AssignStatement:
+		return a.walk(node.Value)
+
+	case *ast.MemberExpression:
+		symbols := a.walk(node.Object)
+		if node.Property != nil {
+			a.doc.refs = append(a.doc.refs, &ref{ident: node.Property, property: true})
+		}
+		return symbols
+
+	case *ast.ReturnStatement:
+		return a.walk(node.ReturnValue)
+	case *ast.ExpressionStatement:
+		return a.walk(node.Expression)
+	case *ast.ThrowStatement:
+		return a.walk(node.Value)
+	case *ast.BlockStatement:
+		return a.block(node)
+
+	case *ast.IfExpression:
+		symbols := a.walk(node.Condition)
+		symbols = append(symbols, a.block(node.Consequence)...)
+		if node.ElseIf != nil {
+			symbols = append(symbols, a.walk(node.ElseIf)...)
+		}
+		return append(symbols, a.block(node.Alternative)...)
+
+	case *ast.WhileStatement:
+		symbols := a.walk(node.Condition)
+		return append(symbols, a.block(node.Block)...)
+
+	case *ast.ForStatement:
+		symbols := a.walk(node.Iterator)
+		return append(symbols, a.block(node.Block)...)
+
+	case *ast.TryStatement:
+		symbols := a.block(node.Block)
+
+		if node.Catch != nil {
+			a.define(&definition{name: node.Param, kind: definedParameter})
+			symbols = append(symbols, a.block(node.Catch)...)
+		}
+
+		return append(symbols, a.block(node.Finally)...)
+
+	case *ast.FunctionLiteral:
+		for _, p := range node.Parameters {
+			a.define(&definition{name: p, kind: definedParameter})
+		}
+		return a.block(node.Body)
+
+	case *ast.PrefixExpression:
+		return a.walk(node.Right)
+
+	case *ast.InfixExpression:
+		return append(a.walk(node.Left), a.walk(node.Right)...)
+
+	case *ast.CallExpression:
+		symbols := a.walk(node.Function)
+		for _, arg := range node.Arguments {
+			symbols = append(symbols, a.walk(arg)...)
+		}
+		return symbols
+
+	case *ast.ArrayLiteral:
+		symbols := []DocumentSymbol{}
+		for _, e := range node.Elements {
+			symbols = append(symbols, a.walk(e)...)
+		}
+		return symbols
+
+	case *ast.HashLiteral:
+		symbols := []DocumentSymbol{}
+		for _, k := range node.Keys {
+			symbols = append(symbols, a.walk(k)...)
+			symbols = append(symbols, a.walk(node.Pairs[k])...)
+		}
+		return symbols
+
+	case *ast.IndexExpression:
+		return append(a.walk(node.Left), a.walk(node.Index)...)
+
+	case *ast.IndexAssignmentExpression:
+		if node.Index == nil {
+			return a.walk(node.Value)
+		}
+		return append(a.walk(node.Index), a.walk(node.Value)...)
+	}
+
+	return nil
+}
+
+// symbol returns the document symbol of def, bound by node.
+func (d *document) symbol(def *definition, node ast.Node, children []DocumentSymbol) DocumentSymbol {
+	symbol := DocumentSymbol{
+		Name:           def.name.Value,
+		Kind:           symbolVariable,
+		Range:          d.rangeOf(node),
+		SelectionRange: d.rangeOf(def.name),
+		Children:       children,
+	}
+
+	switch def.kind {
+	case definedFunction:
+		symbol.Kind = symbolFunction
+		symbol.Detail = signature(def.name.Value, def.fn)
+	case definedModule:
+		symbol.Kind = symbolModule
+		if imp, ok := node.(*ast.ImportStatement); ok {
+			symbol.Detail = strconv.Quote(imp.Path.Value)
+		}
+	}
+
+	return symbol
+}
+
+// signature shows how the function fn, bound to name, is called.
+func signature(name string, fn *ast.FunctionLiteral) string {
+	params := []string{}
+	for _, p := range fn.Parameters {
+		params = append(params, p.Value)
+	}
+	return name + "(" + strings.Join(params, ", ") + ")"
+}
+
+// refAt returns the identifier at offset, or nil.
+func (d *document) refAt(offset int) *ref {
+	i := sort.Search(len(d.refs), func(i int) bool {
+		return d.refs[i].ident.End().Offset >= offset
+	})
+	if i < len(d.refs) && d.refs[i].ident.Pos().Offset <= offset {
+		return d.refs[i]
+	}
+	return nil
+}
+
+// visible returns the definitions of the names bound in the scopes around
+// offset, innermost first.
+func (d *document) visible(offset int) []*definition {
+	defs := []*definition{}
+	seen := map[string]bool{}
+
+	for i := len(d.scopes) - 1; i >= 0; i-- {
+		s := d.scopes[i]
+		if s.Node != nil && (offset < s.Node.Pos().Offset || offset > s.Node.End().Offset) {
+			continue
+		}
+
+		names := make([]string, 0, len(s.Names))
+		for name := range s.Names {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			if !seen[name] {
+				seen[name] = true
+				defs = append(defs, d.definitionOf(s.Names[name]))
+			}
+		}
+	}
+
+	return defs
+}
+
+// The lexer counts lines and columns in bytes, the protocol in UTF-16
+// code units.
+
+// position returns the protocol position of offset.
+func (d *document) position(offset int) Position {
+	line := sort.Search(len(d.lines), func(i int) bool { return d.lines[i] > offset }) - 1
+	if line < 0 {
+		line = 0
+	}
+	if offset > len(d.text) {
+		offset = len(d.text)
+	}
+
+	return Position{Line: line, Character: utf16Len(d.text[d.lines[line]:offset])}
+}
+
+// offset returns the offset of a protocol position.
+func (d *document) offset(pos Position) int {
+	if pos.Line < 0 {
+		return 0
+	}
+	if pos.Line >= len(d.lines) {
+		return len(d.text)
+	}
+
+	offset := d.lines[pos.Line]
+	for units := 0; units < pos.Character && offset < len(d.text) && d.text[offset] != '\n'; {
+		r, size := utf8.DecodeRuneInString(d.text[offset:])
+		units += utf16RuneLen(r)
+		offset += size
+	}
+
+	return offset
+}
+
+func (d *document) rangeOf(node ast.Node) Range {
+	return Range{Start: d.position(node.Pos().Offset), End: d.position(node.End().Offset)}
+}
+
+func utf16Len(s string) int {
+	n := 0
+	for _, r := range s {
+		n += utf16RuneLen(r)
+	}
+	return n
+}
+
+func utf16RuneLen(r rune) int {
+	if r >= 0x10000 {
+		return 2
+	}
+	return 1
+}
+
+// diagnostics turns the parser errors into diagnostics covering the rest
+// of the token they point at.
+func (d *document) diagnostics() []Diagnostic {
+	diagnostics := []Diagnostic{}
+
+	for _, err := range d.errors {
+		diagnostic := Diagnostic{Severity: severityError, Source: "monkey", Message: err.Message}
+
+		if err.Pos.IsValid() {
+			start := err.Pos.Offset
+			if start > len(d.text) {
+				start = len(d.text)
+			}
+			end := start
+			for end < len(d.text) && !strings.ContainsRune(" \t\r\n", rune(d.text[end])) {
+				end++
+			}
+
+			diagnostic.Range = Range{Start: d.position(start), End: d.position(end)}
+		}
+
+		diagnostics = append(diagnostics, diagnostic)
+	}
+
+	return diagnostics
+}
@@ -0,0 +1,325 @@
+// Package lsp is a Language Server Protocol server for Monkey, talking
+// JSON-RPC over stdio. It reports parse errors as diagnostics and knows
+// where names are bound, which gives go to definition, hover, document
+// symbols, completion and semantic highlighting.
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"monkey/src/lexer"
+	"monkey/src/object"
+	"monkey/src/token"
+	"net/textproto"
+	"sort"
+	"strconv"
+)
+
+// ErrNoShutdown is returned by Serve when the client asked it to exit
+// without shutting it down first.
+var ErrNoShutdown = errors.New("exit without shutdown")
+
+// maxMessageSize bounds the body of a message, which is read whole.
+const maxMessageSize = 64 << 20
+
+type server struct {
+	in  *bufio.Reader
+	out io.Writer
+
+	docs     map[string]*document
+	shutdown bool
+}
+
+// Serve answers the client on in and out until it asks the server to exit
+// or closes in.
+func Serve(in io.Reader, out io.Writer) error {
+	s := &server{in: bufio.NewReader(in), out: out, docs: map[string]*document{}}
+
+	for {
+		req, err := s.read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			var syntax *json.SyntaxError
+			if errors.As(err, &syntax) {
+				s.reply(nil, nil, &responseError{Code: codeParseError, Message: err.Error()})
+				continue
+			}
+			return err
+		}
+
+		if req.Method == "exit" {
+			if !s.shutdown {
+				return ErrNoShutdown
+			}
+			return nil
+		}
+
+		result, rerr := s.handle(req)
+		if req.ID != nil {
+			s.reply(req.ID, result, rerr)
+		}
+	}
+}
+
+// read reads a message: headers, of which only Content-Length matters,
+// and a JSON body.
+func (s *server) read() (*request, error) {
+	header, err := textproto.NewReader(s.in).ReadMIMEHeader()
+	if err != nil {
+		if err == io.EOF || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil || length < 0 || length > maxMessageSize {
+		return nil, fmt.Errorf("invalid Content-Length %q", header.Get("Content-Length"))
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(s.in, body); err != nil {
+		return nil, err
+	}
+
+	req := &request{}
+	if err := json.Unmarshal(body, req); err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+func (s *server) write(msg interface{}) {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n%s", len(body), body)
+}
+
+func (s *server) reply(id *json.RawMessage, result interface{}, err *responseError) {
+	if err != nil {
+		s.write(errorResponse{JSONRPC: "2.0", ID: id, Error: err})
+		return
+	}
+	s.write(response{JSONRPC: "2.0", ID: id, Result: result})
+}
+
+func (s *server) notify(method string, params interface{}) {
+	s.write(notification{JSONRPC: "2.0", Method: method, Params: params})
+}
+
+// handle runs req and returns its result. Notifications have none.
+func (s *server) handle(req *request) (interface{}, *responseError) {
+	switch req.Method {
+	case "initialize":
+		return s.initialize(), nil
+	case "initialized":
+		return nil, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+
+	case "textDocument/didOpen":
+		var params didOpenParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		s.open(params.TextDocument.URI, params.TextDocument.Text)
+		return nil, nil
+
+	case "textDocument/didChange":
+		var params didChangeParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		// the server asks for the whole text on every change
+		if n := len(params.ContentChanges); n > 0 {
+			s.open(params.TextDocument.URI, params.ContentChanges[n-1].Text)
+		}
+		return nil, nil
+
+	case "textDocument/didClose":
+		var params documentParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		delete(s.docs, params.TextDocument.URI)
+		s.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{URI: params.TextDocument.URI, Diagnostics: []Diagnostic{}})
+		return nil, nil
+
+	case "textDocument/definition":
+		return s.atPosition(req, (*document).definition)
+	case "textDocument/hover":
+		return s.atPosition(req, (*document).hover)
+	case "textDocument/completion":
+		return s.atPosition(req, (*document).completion)
+
+	case "textDocument/documentSymbol":
+		return s.inDocument(req, func(d *document) interface{} { return d.symbols })
+	case "textDocument/semanticTokens/full":
+		return s.inDocument(req, func(d *document) interface{} { return SemanticTokens{Data: d.semanticTokens()} })
+	}
+
+	if req.ID == nil {
+		return nil, nil
+	}
+	return nil, &responseError{Code: codeMethodNotFound, Message: "method not found: " + req.Method}
+}
+
+func invalidParams(err error) *responseError {
+	return &responseError{Code: codeInvalidParams, Message: err.Error()}
+}
+
+func (s *server) initialize() interface{} {
+	return map[string]interface{}{
+		"capabilities": map[string]interface{}{
+			"textDocumentSync":       map[string]interface{}{"openClose": true, "change": 1},
+			"definitionProvider":     true,
+			"hoverProvider":          true,
+			"documentSymbolProvider": true,
+			"completionProvider":     map[string]interface{}{},
+			"semanticTokensProvider": map[string]interface{}{
+				"legend": semanticTokensLegend{TokenTypes: tokenTypes, TokenModifiers: tokenModifiers},
+				"full":   true,
+			},
+		},
+		"serverInfo": map[string]string{"name": "monkey"},
+	}
+}
+
+// open parses the text of the document uri and publishes its diagnostics.
+func (s *server) open(uri, text string) {
+	d := parse(uri, text)
+	s.docs[uri] = d
+	s.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{URI: uri, Diagnostics: d.diagnostics()})
+}
+
+// inDocument answers a request about a whole document with f, or with null
+// when the document is not open.
+func (s *server) inDocument(req *request, f func(d *document) interface{}) (interface{}, *responseError) {
+	var params documentParams
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		return nil, invalidParams(err)
+	}
+
+	d, ok := s.docs[params.TextDocument.URI]
+	if !ok {
+		return nil, nil
+	}
+	return f(d), nil
+}
+
+// atPosition answers a request about a position in a document with f.
+func (s *server) atPosition(req *request, f func(d *document, offset int) interface{}) (interface{}, *responseError) {
+	var params textDocumentPositionParams
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		return nil, invalidParams(err)
+	}
+
+	d, ok := s.docs[params.TextDocument.URI]
+	if !ok {
+		return nil, nil
+	}
+	return f(d, d.offset(params.Position)), nil
+}
+
+// definition returns where the name at offset is bound, or nil.
+func (d *document) definition(offset int) interface{} {
+	r := d.refAt(offset)
+	if r == nil || r.def == nil {
+		return nil
+	}
+	return Location{URI: d.uri, Range: d.rangeOf(r.def.name)}
+}
+
+// hover describes the name at offset, with the signature and the
+// documentation of builtins.
+func (d *document) hover(offset int) interface{} {
+	r := d.refAt(offset)
+	if r == nil || r.property {
+		return nil
+	}
+
+	name := r.ident.Value
+	var text string
+
+	switch {
+	case r.def == nil:
+		builtin, ok := object.Builtins[name]
+		if !ok {
+			return nil
+		}
+		text = code(builtin.Signature) + "\n" + builtin.Doc
+	case r.def.kind == definedFunction:
+		text = code("fn " + signature(name, r.def.fn))
+	case r.def.kind == definedParameter:
+		text = code("(parameter) " + name)
+	case r.def.kind == definedModule:
+		text = code("import " + name)
+	default:
+		text = code("let " + name)
+	}
+
+	rng := d.rangeOf(r.ident)
+	return Hover{Contents: MarkupContent{Kind: "markdown", Value: text}, Range: &rng}
+}
+
+func code(s string) string {
+	return "```monkey\n" + s + "\n```"
+}
+
+// completion returns the names bound around offset, the builtins they do
+// not shadow and the keywords. There are none after a dot, as what a
+// module or a hash holds is only known when the program runs.
+func (d *document) completion(offset int) interface{} {
+	start := offset
+	for start > 0 && lexer.IsLetter(d.text[start-1]) {
+		start--
+	}
+	if start > 0 && d.text[start-1] == '.' {
+		return []CompletionItem{}
+	}
+
+	items := []CompletionItem{}
+	bound := map[string]bool{}
+
+	for _, def := range d.visible(offset) {
+		bound[def.name.Value] = true
+
+		item := CompletionItem{Label: def.name.Value, Kind: completionVariable}
+		switch def.kind {
+		case definedFunction:
+			item.Kind = completionFunction
+			item.Detail = signature(def.name.Value, def.fn)
+		case definedModule:
+			item.Kind = completionModule
+		}
+		items = append(items, item)
+	}
+
+	names := []string{}
+	for name := range object.Builtins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if !bound[name] {
+			b := object.Builtins[name]
+			items = append(items, CompletionItem{Label: name, Kind: completionFunction, Detail: b.Signature, Documentation: b.Doc})
+		}
+	}
+
+	for _, keyword := range token.Keywords() {
+		items = append(items, CompletionItem{Label: keyword, Kind: completionKeyword})
+	}
+
+	return items
+}
@@ -0,0 +1,309 @@
+package lsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const input = `let add = fn(a, b) {
+  let sum = a + b;
+  sum
+};
+import "lib/math.mky";
+// the total
+let total = add(1, len([2]));
+math.pi
+`
+
+func TestDefinition(t *testing.T) {
+	d := parse("file:///a.mky", input)
+
+	tests := []struct {
+		at   Position
+		want *Range
+	}{
+		{Position{2, 3}, &Range{Position{1, 6}, Position{1, 9}}},    // sum
+		{Position{1, 12}, &Range{Position{0, 13}, Position{0, 14}}}, // a
+		{Position{6, 12}, &Range{Position{0, 4}, Position{0, 7}}},   // add
+		{Position{6, 15}, &Range{Position{0, 4}, Position{0, 7}}},   // just after add
+		{Position{0, 5}, &Range{Position{0, 4}, Position{0, 7}}},    // the binding itself
+		{Position{7, 0}, &Range{Position{4, 7}, Position{4, 21}}},   // math, named after the file
+		{Position{6, 19}, nil}, // len
+		{Position{7, 6}, nil},  // pi
+		{Position{5, 4}, nil},  // a comment
+	}
+
+	for _, tt := range tests {
+		got, _ := d.definition(d.offset(tt.at)).(Location)
+		if tt.want == nil && got.URI != "" || tt.want != nil && (got.URI != d.uri || got.Range != *tt.want) {
+			t.Errorf("definition at %v: want %v, got=%+v", tt.at, tt.want, got)
+		}
+	}
+}
+
+func TestScopes(t *testing.T) {
+	d := parse("", `let f = fn() { g() };
+let g = fn() { x };
+let x = 1;
+for i, v in [1] { let w = i; puts(w, v) }
+try { 1 } catch (e) { e }
+x = 2;`)
+
+	tests := []struct {
+		at   Position
+		want *Position
+	}{
+		{Position{0, 15}, &Position{1, 4}}, // g, bound after f but before f runs
+		{Position{1, 15}, &Position{2, 4}}, // x
+		{Position{3, 26}, &Position{3, 4}}, // i
+		{Position{3, 35}, &Position{3, 22}},
+		{Position{3, 38}, &Position{3, 7}},
+		{Position{4, 22}, &Position{4, 17}},
+		{Position{5, 0}, &Position{2, 4}},
+	}
+
+	for _, tt := range tests {
+		got, ok := d.definition(d.offset(tt.at)).(Location)
+		if tt.want == nil && ok || tt.want != nil && (!ok || got.Range.Start != *tt.want) {
+			t.Errorf("definition at %v: want %v, got=%+v", tt.at, tt.want, got)
+		}
+	}
+}
+
+func TestHover(t *testing.T) {
+	d := parse("", input)
+
+	tests := []struct {
+		at   Position
+		want string
+	}{
+		{Position{6, 20}, "```monkey\nlen(value)\n```\nReturns the number of bytes of a string, or the number of elements of an array or a hash."},
+		{Position{6, 13}, "```monkey\nfn add(a, b)\n```"},
+		{Position{1, 12}, "```monkey\n(parameter) a\n```"},
+		{Position{1, 7}, "```monkey\nlet sum\n```"},
+		{Position{7, 1}, "```monkey\nimport math\n```"},
+		{Position{7, 6}, ""},
+		{Position{6, 26}, ""},
+	}
+
+	for _, tt := range tests {
+		got := ""
+		if hover, ok := d.hover(d.offset(tt.at)).(Hover); ok {
+			got = hover.Contents.Value
+		}
+		if got != tt.want {
+			t.Errorf("hover at %v: want %q, got=%q", tt.at, tt.want, got)
+		}
+	}
+}
+
+func TestDocumentSymbols(t *testing.T) {
+	d := parse("", input)
+
+	got := []string{}
+	var list func(symbols []DocumentSymbol, indent string)
+	list = func(symbols []DocumentSymbol, indent string) {
+		for _, s := range symbols {
+			got = append(got, fmt.Sprintf("%s%s %d %s %v", indent, s.Name, s.Kind, s.Detail, s.Range))
+			list(s.Children, indent+"  ")
+		}
+	}
+	list(d.symbols, "")
+
+	want := []string{
+		"add 12 add(a, b) {{0 0} {3 1}}",
+		"  sum 13  {{1 2} {1 17}}",
+		`math 2 "lib/math.mky" {{4 0} {4 21}}`,
+		"total 13  {{6 0} {6 28}}",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("wrong symbols. want=\n%s\ngot=\n%s", strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+}
+
+func TestCompletion(t *testing.T) {
+	d := parse("", input)
+
+	labels := func(at Position) string {
+		got := []string{}
+		for _, item := range d.completion(d.offset(at)).([]CompletionItem) {
+			got = append(got, item.Label)
+		}
+		return strings.Join(got, " ")
+	}
+
+	if got := labels(Position{2, 2}); !strings.HasPrefix(got, "a b sum add math total delete first gets last len") || !strings.HasSuffix(got, "try while") {
+		t.Errorf("wrong completions in the function. got=%q", got)
+	}
+	if got := labels(Position{7, 0}); strings.Contains(got, "sum") || !strings.HasPrefix(got, "add math total delete") {
+		t.Errorf("wrong completions at the top level. got=%q", got)
+	}
+	if got := labels(Position{7, 6}); got != "" {
+		t.Errorf("expected no completions after a dot. got=%q", got)
+	}
+
+	d = parse("", "let len = 1;\n")
+	if got := labels(Position{1, 0}); strings.Count(got, "len") != 1 {
+		t.Errorf("expected the builtin to be shadowed. got=%q", got)
+	}
+}
+
+func TestSemanticTokens(t *testing.T) {
+	d := parse("", "let f = fn(x) { x };\n/* a\nb */ f(\"é\", len, 1.5)")
+
+	want := []int{
+		0, 0, 3, tokenKeyword, 0,
+		0, 4, 1, tokenFunction, modifierDeclaration,
+		0, 2, 1, tokenOperator, 0,
+		0, 2, 2, tokenKeyword, 0,
+		0, 3, 1, tokenParameter, modifierDeclaration,
+		0, 5, 1, tokenParameter, 0,
+		1, 0, 4, tokenComment, 0,
+		1, 0, 4, tokenComment, 0,
+		0, 5, 1, tokenFunction, 0,
+		0, 2, 3, tokenString, 0,
+		0, 5, 3, tokenFunction, modifierDefaultLibrary,
+		0, 5, 3, tokenNumber, 0,
+	}
+
+	if got := d.semanticTokens(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("wrong tokens.\nwant=%v\ngot= %v", want, got)
+	}
+}
+
+func TestPositions(t *testing.T) {
+	d := parse("", "let s = \"😀é\"; s\nlet t = 1")
+
+	tests := []struct {
+		offset int
+		pos    Position
+	}{
+		{0, Position{0, 0}},
+		{8, Position{0, 8}},
+		{13, Position{0, 11}}, // after the emoji, two UTF-16 units
+		{16, Position{0, 13}},
+		{20, Position{1, 0}},
+		{29, Position{1, 9}},
+	}
+
+	for _, tt := range tests {
+		if got := d.position(tt.offset); got != tt.pos {
+			t.Errorf("position of %d: want %v, got=%v", tt.offset, tt.pos, got)
+		}
+		if got := d.offset(tt.pos); got != tt.offset {
+			t.Errorf("offset of %v: want %d, got=%d", tt.pos, tt.offset, got)
+		}
+	}
+}
+
+func message(method string, id int, params string) string {
+	body := `{"jsonrpc": "2.0", "method": "` + method + `"`
+	if id > 0 {
+		body += `, "id": ` + strconv.Itoa(id)
+	}
+	if params != "" {
+		body += `, "params": ` + params
+	}
+	body += "}"
+
+	return "Content-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + body
+}
+
+// readMessages reads the messages sent by the server.
+func readMessages(t *testing.T, out *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+
+	messages := []map[string]interface{}{}
+	r := bufio.NewReader(out)
+	for {
+		header, err := textproto.NewReader(r).ReadMIMEHeader()
+		if err != nil {
+			return messages
+		}
+
+		n, _ := strconv.Atoi(header.Get("Content-Length"))
+		body := make([]byte, n)
+		io.ReadFull(r, body)
+
+		var msg map[string]interface{}
+		if err := json.Unmarshal(body, &msg); err != nil {
+			t.Fatalf("invalid message %q: %s", body, err)
+		}
+		messages = append(messages, msg)
+	}
+}
+
+func TestServe(t *testing.T) {
+	doc := `{"textDocument": {"uri": "file:///a.mky"}`
+	in := strings.Join([]string{
+		message("initialize", 1, `{"capabilities": {}}`),
+		message("initialized", 0, `{}`),
+		message("textDocument/didOpen", 0, `{"textDocument": {"uri": "file:///a.mky", "languageId": "monkey", "version": 1, "text": "let = 1"}}`),
+		message("textDocument/didChange", 0, doc+`, "contentChanges": [{"text": "let x = 1;\nx"}]}`),
+		message("textDocument/definition", 2, doc+`, "position": {"line": 1, "character": 0}}`),
+		message("textDocument/hover", 3, `{"textDocument": {"uri": "file:///b.mky"}, "position": {"line": 0, "character": 0}}`),
+		message("textDocument/semanticTokens/full", 4, doc+`}`),
+		message("textDocument/formatting", 5, doc+`}`),
+		message("$/cancelRequest", 0, `{"id": 4}`),
+		message("textDocument/didClose", 0, doc+`}`),
+		message("shutdown", 6, ""),
+		message("exit", 0, ""),
+		message("initialize", 7, `{}`),
+	}, "")
+
+	var out bytes.Buffer
+	if err := Serve(strings.NewReader(in), &out); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	got := []string{}
+	for _, msg := range readMessages(t, &out) {
+		b, _ := json.Marshal(msg)
+		got = append(got, string(b))
+	}
+
+	want := []string{
+		`"id":1,"jsonrpc":"2.0","result":{"capabilities":`,
+		`"method":"textDocument/publishDiagnostics","params":{"diagnostics":[{"message":"expected next token to be IDENT, got = instead","range":{"end":{"character":5,"line":0},"start":{"character":4,"line":0}},"severity":1,"source":"monkey"},{"message":"no prefix parse func for = found","range":{"end":{"character":5,"line":0},"start":{"character":4,"line":0}},"severity":1,"source":"monkey"}],"uri":"file:///a.mky"}`,
+		`"method":"textDocument/publishDiagnostics","params":{"diagnostics":[],"uri":"file:///a.mky"}`,
+		`"id":2,"jsonrpc":"2.0","result":{"range":{"end":{"character":5,"line":0},"start":{"character":4,"line":0}},"uri":"file:///a.mky"}`,
+		`"id":3,"jsonrpc":"2.0","result":null`,
+		`"id":4,"jsonrpc":"2.0","result":{"data":[0,0,3,5,0,0,4,1,3,1,0,2,1,9,0,0,2,1,7,0,1,0,1,3,0]}`,
+		`"error":{"code":-32601,"message":"method not found: textDocument/formatting"},"id":5`,
+		`"method":"textDocument/publishDiagnostics","params":{"diagnostics":[],"uri":"file:///a.mky"}`,
+		`"id":6,"jsonrpc":"2.0","result":null`,
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("expected %d messages. got=\n%s", len(want), strings.Join(got, "\n"))
+	}
+	for i := range want {
+		if !strings.Contains(got[i], want[i]) {
+			t.Errorf("wrong message %d. want %s, got=%s", i, want[i], got[i])
+		}
+	}
+}
+
+func TestServeExitWithoutShutdown(t *testing.T) {
+	var out bytes.Buffer
+	if err := Serve(strings.NewReader(message("exit", 0, "")), &out); err != ErrNoShutdown {
+		t.Errorf("expected %v. got=%v", ErrNoShutdown, err)
+	}
+}
+
+func TestServeInvalidLength(t *testing.T) {
+	for _, length := range []string{"-1", "x", "1099511627776"} {
+		var out bytes.Buffer
+		in := "Content-Length: " + length + "\r\n\r\n{}"
+		if err := Serve(strings.NewReader(in), &out); err == nil || err.Error() != `invalid Content-Length "`+length+`"` {
+			t.Errorf("%s: expected the length to be rejected. got=%v", length, err)
+		}
+	}
+}
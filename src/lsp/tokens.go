@@ -0,0 +1,142 @@
+package lsp
+
+import (
+	"monkey/src/lexer"
+	"monkey/src/object"
+	"monkey/src/token"
+)
+
+// The semantic token types and modifiers the server reports, in the order
+// of the legend it sends.
+var (
+	tokenTypes = []string{
+		"namespace", "function", "parameter", "variable", "property",
+		"keyword", "string", "number", "comment", "operator",
+	}
+	tokenModifiers = []string{"declaration", "defaultLibrary"}
+)
+
+const (
+	tokenNamespace = iota
+	tokenFunction
+	tokenParameter
+	tokenVariable
+	tokenProperty
+	tokenKeyword
+	tokenString
+	tokenNumber
+	tokenComment
+	tokenOperator
+)
+
+const (
+	modifierDeclaration = 1 << iota
+	modifierDefaultLibrary
+)
+
+var operators = map[token.TokenType]bool{
+	token.ASSIGN: true, token.PLUS: true, token.MINUS: true, token.SLASH: true,
+	token.ASTERISK: true, token.PERCENT: true, token.BANG: true, token.EQ: true,
+	token.NOT_EQ: true, token.LT: true, token.GT: true, token.LT_EQ: true,
+	token.GT_EQ: true, token.AND: true, token.OR: true,
+}
+
+// semanticTokens classifies the tokens of the document, encoded the way
+// the protocol wants: five numbers per token, its line and start relative
+// to the token before, its length, type and modifiers. Tokens spanning
+// lines are split into one token per line.
+func (d *document) semanticTokens() []int {
+	data := []int{}
+	prev := Position{}
+
+	l := lexer.New(d.text)
+	l.KeepComments(true)
+
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		tokenType, modifiers, ok := d.classify(tok)
+		if !ok {
+			continue
+		}
+
+		// an unterminated string ends past the text
+		stop := min(tok.End.Offset, len(d.text))
+
+		for start := tok.Pos.Offset; start < stop; {
+			end := start
+			for end < stop && d.text[end] != '\n' {
+				end++
+			}
+
+			if end > start {
+				pos := d.position(start)
+				char := pos.Character
+				if pos.Line == prev.Line {
+					char -= prev.Character
+				}
+
+				data = append(data, pos.Line-prev.Line, char, utf16Len(d.text[start:end]), tokenType, modifiers)
+				prev = pos
+			}
+
+			start = end + 1
+		}
+	}
+
+	return data
+}
+
+func (d *document) classify(tok token.Token) (int, int, bool) {
+	switch tok.Type {
+	case token.COMMENT:
+		return tokenComment, 0, true
+	case token.STRING:
+		return tokenString, 0, true
+	case token.INT, token.FLOAT:
+		return tokenNumber, 0, true
+	case token.IDENT:
+		return d.classifyIdent(tok)
+	}
+
+	if operators[tok.Type] {
+		return tokenOperator, 0, true
+	}
+	if token.LookupIdent(tok.Literal) != token.IDENT {
+		return tokenKeyword, 0, true
+	}
+
+	return 0, 0, false
+}
+
+func (d *document) classifyIdent(tok token.Token) (int, int, bool) {
+	r := d.refAt(tok.Pos.Offset)
+	if r == nil || r.ident.Pos().Offset != tok.Pos.Offset {
+		return tokenVariable, 0, true
+	}
+
+	if r.property {
+		return tokenProperty, 0, true
+	}
+
+	if r.def == nil {
+		if _, ok := object.Builtins[tok.Literal]; ok {
+			return tokenFunction, modifierDefaultLibrary, true
+		}
+		return tokenVariable, 0, true
+	}
+
+	modifiers := 0
+	if r.def.name == r.ident {
+		modifiers = modifierDeclaration
+	}
+
+	switch r.def.kind {
+	case definedFunction:
+		return tokenFunction, modifiers, true
+	case definedParameter:
+		return tokenParameter, modifiers, true
+	case definedModule:
+		return tokenNamespace, modifiers, true
+	}
+
+	return tokenVariable, modifiers, true
+}
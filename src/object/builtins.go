@@ -0,0 +1,236 @@
+package object
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Builtins are the functions available to every program by name, unless a
+// binding shadows them.
+var Builtins = map[string]*Builtin{
+	"len": {
+		Name:      "len",
+		Signature: "len(value)",
+		Doc:       "Returns the number of bytes of a string, or the number of elements of an array or a hash.",
+		Fn: func(rt Runtime, args ...Object) Object {
+			if len(args) != 1 {
+				return newError(TYPE_ERROR, "wrong number of arguments to `len`. got=%d, want=1", len(args))
+			}
+
+			switch arg := args[0].(type) {
+			case *String:
+				return &Integer{Value: int64(len(arg.Value))}
+			case *Array:
+				return &Integer{Value: int64(len(arg.Elements))}
+			case *Hash:
+				return &Integer{Value: int64(arg.Len())}
+			default:
+				return newError(TYPE_ERROR, "argument to `len` not supported, got=%s", args[0].Type())
+			}
+		},
+	},
+	"first": {
+		Name:      "first",
+		Signature: "first(array)",
+		Doc:       "Returns the first element of array, or null when it is empty.",
+		Fn: func(rt Runtime, args ...Object) Object {
+			if len(args) != 1 {
+				return newError(TYPE_ERROR, "wrong number of arguments to `len`. got=%d, want=1", len(args))
+			}
+
+			if args[0].Type() != ARRAY_OBJ {
+				return newError(TYPE_ERROR, "argument to `first` must be ARRAY, got %s", args[0].Type())
+			}
+
+			arr := args[0].(*Array)
+			if len(arr.Elements) > 0 {
+				return arr.Elements[0]
+			}
+
+			return NULL
+		},
+	},
+	"last": {
+		Name:      "last",
+		Signature: "last(array)",
+		Doc:       "Returns the last element of array, or null when it is empty.",
+		Fn: func(rt Runtime, args ...Object) Object {
+			if len(args) != 1 {
+				return newError(TYPE_ERROR, "wrong number of arguments to `len`. got=%d, want=1", len(args))
+			}
+
+			if args[0].Type() != ARRAY_OBJ {
+				return newError(TYPE_ERROR, "argument to `last` must be ARRAY, got %s", args[0].Type())
+			}
+
+			arr := args[0].(*Array)
+			if len(arr.Elements) > 0 {
+				return arr.Elements[len(arr.Elements)-1]
+			}
+
+			return NULL
+		},
+	},
+	"rest": {
+		Name:      "rest",
+		Signature: "rest(array)",
+		Doc:       "Returns a new array holding the elements of array after the first, or null when it is empty.",
+		Fn: func(rt Runtime, args ...Object) Object {
+			if len(args) != 1 {
+				return newError(TYPE_ERROR, "wrong number of arguments to `len`. got=%d, want=1", len(args))
+			}
+
+			if args[0].Type() != ARRAY_OBJ {
+				return newError(TYPE_ERROR, "argument to `rest` must be ARRAY, got %s", args[0].Type())
+			}
+
+			arr := args[0].(*Array)
+			if len(arr.Elements) > 0 {
+				if err := rt.Alloc(ArraySize(int64(len(arr.Elements) - 1))); err != nil {
+					return err
+				}
+
+				return &Array{
+					Elements: arr.Elements[1:],
+				}
+			}
+
+			return NULL
+		},
+	},
+	"push": {
+		Name:      "push",
+		Signature: "push(array, value)",
+		Doc:       "Returns a new array holding the elements of array followed by value.",
+		Fn: func(rt Runtime, args ...Object) Object {
+			if len(args) != 2 {
+				return newError(TYPE_ERROR, "wrong number of arguments to `len`. got=%d, want=2", len(args))
+			}
+
+			if args[0].Type() != ARRAY_OBJ {
+				return newError(TYPE_ERROR, "first argument to `push` must be ARRAY, got %s", args[0].Type())
+			}
+
+			arr := args[0].(*Array)
+			if err := rt.Alloc(ArraySize(int64(len(arr.Elements) + 1))); err != nil {
+				return err
+			}
+
+			elements := make([]Object, len(arr.Elements), len(arr.Elements)+1)
+			copy(elements, arr.Elements)
+
+			return &Array{
+				Elements: append(elements, args[1]),
+			}
+
+		},
+	},
+	"delete": {
+		Name:      "delete",
+		Signature: "delete(hash, key)",
+		Doc:       "Removes key from hash and returns its value, or null when it was not there.",
+		Fn: func(rt Runtime, args ...Object) Object {
+			if len(args) != 2 {
+				return newError(TYPE_ERROR, "wrong number of arguments to `delete`. got=%d, want=2", len(args))
+			}
+
+			hash, ok := args[0].(*Hash)
+			if !ok {
+				return newError(TYPE_ERROR, "first argument to `delete` must be HASH, got %s", args[0].Type())
+			}
+
+			key, ok := args[1].(Hashable)
+			if !ok {
+				return newError(TYPE_ERROR, "unusable as hash key: %s", args[1].Type())
+			}
+
+			if pair, ok := hash.Delete(key.HashKey()); ok {
+				return pair.Value
+			}
+
+			return NULL
+		},
+	},
+	"puts": {
+		Name:      "puts",
+		Signature: "puts(values...)",
+		Doc:       "Prints the values, separated by commas, and a newline.",
+		Fn: func(rt Runtime, args ...Object) Object {
+			values := []string{}
+			for _, arg := range args {
+				values = append(values, arg.Inspect())
+			}
+
+			fmt.Fprintln(rt.Stdout(), strings.Join(values, ", "))
+
+			return NULL
+		},
+	},
+	"gets": {
+		Name:      "gets",
+		Signature: "gets()",
+		Doc:       "Reads a line from stdin, without its newline. Returns null at the end of the input.",
+		Fn: func(rt Runtime, args ...Object) Object {
+			if len(args) != 0 {
+				return newError(TYPE_ERROR, "wrong number of arguments to `gets`. got=%d, want=0", len(args))
+			}
+
+			line, ok := rt.ReadLine()
+			if !ok {
+				return NULL
+			}
+
+			if err := rt.Alloc(StringSize(int64(len(line)))); err != nil {
+				return err
+			}
+
+			return &String{Value: line}
+		},
+	},
+	"range": {
+		Name:      "range",
+		Signature: "range(start, end)",
+		Doc:       "Returns the array of the integers from start up to, but not including, end.",
+		Fn: func(rt Runtime, args ...Object) Object {
+			if len(args) != 2 {
+				return newError(TYPE_ERROR, "wrong number of arguments to `range`. got=%d, want=2", len(args))
+			}
+
+			if args[0].Type() != INTEGER_OBJ || args[1].Type() != INTEGER_OBJ {
+				return newError(TYPE_ERROR, "arg must be INTEGERS")
+			}
+
+			start := args[0].(*Integer).Value
+			end := args[1].(*Integer).Value
+
+			if end < start {
+				end = start
+			}
+
+			// end - start overflows when start is negative enough
+			n := end - start
+			if n < 0 {
+				n = math.MaxInt64
+			}
+
+			if err := rt.Alloc(saturatingAdd(ArraySize(n), saturatingMul(n, IntegerSize))); err != nil {
+				return err
+			}
+
+			arr := make([]Object, n)
+
+			for i := range arr {
+				arr[i] = &Integer{Value: start + int64(i)}
+			}
+
+			return &Array{
+				Elements: arr,
+			}
+		},
+	},
+}
+
+func newError(kind ErrorKind, format string, a ...interface{}) *Error {
+	return &Error{Kind: kind, Message: fmt.Sprintf(format, a...)}
+}
@@ -1,7 +1,13 @@
 package object
 
+import "sort"
+
+// Environment holds bindings. The global environment maps names to values;
+// function calls and block scopes get environments of slots, indexed as the
+// resolver bound the names in them.
 type Environment struct {
 	store map[string]Object
+	slots []Object
 	outer *Environment
 }
 
@@ -17,6 +23,12 @@ func NewEnvironment() *Environment {
 	return &Environment{store: s}
 }
 
+// NewSlotEnvironment returns an environment with size slots enclosed by
+// outer.
+func NewSlotEnvironment(outer *Environment, size int) *Environment {
+	return &Environment{slots: make([]Object, size), outer: outer}
+}
+
 func (e *Environment) Get(name string) (Object, bool) {
 	obj, ok := e.store[name]
 	if !ok && e.outer != nil {
@@ -25,18 +37,49 @@ func (e *Environment) Get(name string) (Object, bool) {
 	return obj, ok
 }
 
+// Names returns the names bound by name in e, not in the environments
+// around it, sorted.
+func (e *Environment) Names() []string {
+	names := make([]string, 0, len(e.store))
+	for name := range e.store {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
 func (e *Environment) Set(name string, val Object) Object {
 	e.store[name] = val
 	return val
 }
 
+// GetAt returns the value in slot of the environment depth levels out, nil
+// when nothing was stored there yet.
+func (e *Environment) GetAt(depth, slot int) Object {
+	return e.ancestor(depth).slots[slot]
+}
+
+// SetAt stores val in slot of the environment depth levels out.
+func (e *Environment) SetAt(depth, slot int, val Object) {
+	e.ancestor(depth).slots[slot] = val
+}
+
+func (e *Environment) ancestor(depth int) *Environment {
+	env := e
+	for i := 0; i < depth; i++ {
+		env = env.outer
+	}
+	return env
+}
+
 func (e *Environment) UpdateValue(name string, val Object) bool {
 
 	var setValue func(env *Environment) bool
 	setValue = func(env *Environment) bool {
 		_, ok := env.store[name]
 		if !ok && env.outer != nil {
-			return setValue(e.outer)
+			return setValue(env.outer)
 		}
 
 		if !ok {
@@ -4,7 +4,11 @@ import (
 	"bytes"
 	"fmt"
 	"hash/fnv"
+	"math"
 	"monkey/src/ast"
+	"monkey/src/code"
+	"monkey/src/token"
+	"strconv"
 	"strings"
 )
 
@@ -12,15 +16,23 @@ type ObjectType string
 
 const (
 	INTEGER_OBJ      = "INTEGER"
+	FLOAT_OBJ        = "FLOAT"
 	BOOLEAN_OBJ      = "BOOLEAN"
 	NULL_OBJ         = "NULL"
 	RETURN_VALUE_OBJ = "RETURN_VALUE"
+	BREAK_OBJ        = "BREAK"
+	CONTINUE_OBJ     = "CONTINUE"
 	ERROR_OBJ        = "ERROR"
+	EXCEPTION_OBJ    = "EXCEPTION"
 	FUNCTION_OBJ     = "FUNCTION"
 	STRING_OBJ       = "STRING"
 	BUILTIN_OBJ      = "BUILTIN"
 	ARRAY_OBJ        = "ARRAY"
 	HASH_OBJ         = "HASH"
+	MODULE_OBJ       = "MODULE"
+
+	COMPILED_FUNCTION_OBJ = "COMPILED_FUNCTION"
+	CELL_OBJ              = "CELL"
 )
 
 type HashKey struct {
@@ -44,6 +56,24 @@ func (i *Integer) HashKey() HashKey {
 func (i *Integer) Inspect() string  { return fmt.Sprintf("%d", i.Value) }
 func (i *Integer) Type() ObjectType { return INTEGER_OBJ }
 
+type Float struct {
+	Value float64
+}
+
+func (f *Float) HashKey() HashKey {
+	return HashKey{Type: f.Type(), Value: math.Float64bits(f.Value)}
+}
+
+func (f *Float) Type() ObjectType { return FLOAT_OBJ }
+func (f *Float) Inspect() string {
+	s := strconv.FormatFloat(f.Value, 'g', -1, 64)
+	// keep floats that hold a whole number distinguishable from integers
+	if !strings.ContainsAny(s, ".eIN") {
+		s += ".0"
+	}
+	return s
+}
+
 type Boolean struct {
 	Value bool
 }
@@ -63,6 +93,14 @@ func (b *Boolean) HashKey() HashKey {
 func (i *Boolean) Inspect() string  { return fmt.Sprintf("%t", i.Value) }
 func (i *Boolean) Type() ObjectType { return BOOLEAN_OBJ }
 
+// The values there is only one of. Comparing against them by pointer is
+// how truthiness and equality are decided.
+var (
+	NULL  = &Null{}
+	TRUE  = &Boolean{Value: true}
+	FALSE = &Boolean{Value: false}
+)
+
 type Null struct{}
 
 func (n *Null) Inspect() string  { return "null" }
@@ -75,25 +113,137 @@ type ReturnValue struct {
 func (rv *ReturnValue) Inspect() string  { return rv.Value.Inspect() }
 func (rv *ReturnValue) Type() ObjectType { return RETURN_VALUE_OBJ }
 
+// Break and Continue are the signals produced by break and continue
+// statements. Like ReturnValue they travel up through blocks until the
+// enclosing loop consumes them.
+type Break struct{}
+
+func (b *Break) Inspect() string  { return "break" }
+func (b *Break) Type() ObjectType { return BREAK_OBJ }
+
+type Continue struct{}
+
+func (c *Continue) Inspect() string  { return "continue" }
+func (c *Continue) Type() ObjectType { return CONTINUE_OBJ }
+
+type ErrorKind string
+
+const (
+	TYPE_ERROR          ErrorKind = "TypeError"
+	NAME_ERROR          ErrorKind = "NameError"
+	INDEX_ERROR         ErrorKind = "IndexError"
+	ZERO_DIVISION_ERROR ErrorKind = "ZeroDivisionError"
+	THROWN_ERROR        ErrorKind = "Error"       // raised by a throw statement
+	LIMIT_ERROR         ErrorKind = "LimitError"  // an execution limit was hit
+	MEMORY_ERROR        ErrorKind = "MemoryError" // the memory quota was exceeded
+	IMPORT_ERROR        ErrorKind = "ImportError" // a module could not be loaded
+)
+
+// maxTracebackFrames caps how many calls Traceback prints, deep recursion
+// would otherwise bury the message.
+const maxTracebackFrames = 20
+
 type Error struct {
+	Kind    ErrorKind
 	Message string
+	Pos     token.Position // where evaluation failed
+	Stack   []Frame        // calls the error unwound through, innermost first
+	Value   Object         // the value given to throw, nil for runtime errors
+}
+
+// Frame is a call of a user defined function.
+type Frame struct {
+	Function string         // name of the called function, empty if anonymous
+	Pos      token.Position // position of the call
 }
 
-func (e *Error) Inspect() string  { return "Error: " + e.Message }
+func (e *Error) Inspect() string {
+	if e.Pos.IsValid() {
+		return e.Pos.String() + ": " + e.kind() + ": " + e.Message
+	}
+	return e.kind() + ": " + e.Message
+}
+
+// Traceback renders the error followed by the calls it unwound through,
+// innermost first, each with the position execution had reached in it.
+func (e *Error) Traceback() string {
+	var out bytes.Buffer
+
+	out.WriteString(e.kind() + ": " + e.Message + "\n")
+
+	pos := e.Pos
+	for i, frame := range e.Stack {
+		if i == maxTracebackFrames {
+			out.WriteString(fmt.Sprintf("    ... %d more calls\n", len(e.Stack)-i))
+			pos = e.Stack[len(e.Stack)-1].Pos
+			break
+		}
+
+		name := frame.Function
+		if name == "" {
+			name = "<anonymous>"
+		}
+		out.WriteString(fmt.Sprintf("    at %s (%s)\n", name, pos))
+		pos = frame.Pos
+	}
+	out.WriteString(fmt.Sprintf("    at <main> (%s)", pos))
+
+	return out.String()
+}
+
+func (e *Error) kind() string {
+	if e.Kind == "" {
+		return "Error"
+	}
+	return string(e.Kind)
+}
 func (e *Error) Type() ObjectType { return ERROR_OBJ }
 
+// Exception is an Error that was caught by a try statement. Unlike Error,
+// which aborts evaluation, it is an ordinary value that can be inspected
+// and thrown again.
+type Exception struct {
+	Err *Error
+}
+
+func (e *Exception) Type() ObjectType { return EXCEPTION_OBJ }
+func (e *Exception) Inspect() string  { return e.Err.kind() + ": " + e.Err.Message }
+
+// Field returns the exception's fields by name for e["name"] lookups.
+func (e *Exception) Field(name string) (Object, bool) {
+	switch name {
+	case "kind":
+		return &String{Value: e.Err.kind()}, true
+	case "message":
+		return &String{Value: e.Err.Message}, true
+	case "position":
+		return &String{Value: e.Err.Pos.String()}, true
+	case "traceback":
+		return &String{Value: e.Err.Traceback()}, true
+	case "value":
+		if e.Err.Value != nil {
+			return e.Err.Value, true
+		}
+	}
+	return nil, false
+}
+
 type Function struct {
+	Name       string
 	Parameters []*ast.Identifier
 	Body       *ast.BlockStatement
+	Slots      int // size of the environment of a call
 	Env        *Environment
 }
 
 func (f *Function) Type() ObjectType { return FUNCTION_OBJ }
-func (f *Function) Inspect() string {
+func (f *Function) Inspect() string  { return inspectFunction(f.Parameters, f.Body) }
+
+func inspectFunction(parameters []*ast.Identifier, body *ast.BlockStatement) string {
 	var out bytes.Buffer
 
 	params := []string{}
-	for _, p := range f.Parameters {
+	for _, p := range parameters {
 		params = append(params, p.String())
 	}
 
@@ -101,7 +251,7 @@ func (f *Function) Inspect() string {
 	out.WriteString("(")
 	out.WriteString(strings.Join(params, ", "))
 	out.WriteString("( {\n")
-	out.WriteString(f.Body.String())
+	out.WriteString(body.String())
 	out.WriteString("\n}")
 
 	return out.String()
@@ -121,15 +271,70 @@ func (s *String) HashKey() HashKey {
 func (s *String) Type() ObjectType { return STRING_OBJ }
 func (s *String) Inspect() string  { return s.Value }
 
+// CompiledFunction is a function literal lowered to bytecode. It lives in
+// the constant pool, a Closure is the value scripts see.
+type CompiledFunction struct {
+	Instructions  code.Instructions
+	SourceMap     *code.SourceMap
+	NumLocals     int
+	NumParameters int
+	Name          string
+	LocalNames    []string // for errors about unset locals, by slot
+	FreeNames     []string
+	Literal       *ast.FunctionLiteral // nil for the main program
+
+	// Constants is the pool of the program the function was compiled in.
+	// A function outlives its program when a later one calls it, as in
+	// the REPL, so it keeps the pool its instructions refer to.
+	Constants []Object
+}
+
+func (cf *CompiledFunction) Type() ObjectType { return COMPILED_FUNCTION_OBJ }
+func (cf *CompiledFunction) Inspect() string {
+	return fmt.Sprintf("CompiledFunction[%p]", cf)
+}
+
+// Closure is a CompiledFunction together with the variables it captured
+// and the globals of the program that created it, which differ from those
+// of the caller when the function comes from a module. It behaves like a
+// Function, including its type name.
+type Closure struct {
+	Fn      *CompiledFunction
+	Free    []*Cell
+	Globals *Environment
+}
+
+func (c *Closure) Type() ObjectType { return FUNCTION_OBJ }
+func (c *Closure) Inspect() string {
+	if c.Fn.Literal == nil {
+		return fmt.Sprintf("Closure[%p]", c)
+	}
+	return inspectFunction(c.Fn.Literal.Parameters, c.Fn.Literal.Body)
+}
+
+// Cell holds a variable that a closure captured, so that the closure and
+// the scope it was created in share updates to it.
+type Cell struct {
+	Value Object
+}
+
+func (c *Cell) Type() ObjectType { return CELL_OBJ }
+func (c *Cell) Inspect() string  { return fmt.Sprintf("Cell[%p]", c) }
+
 type Builtin struct {
 	Name string
 	Fn   BuiltinFunction
+
+	// Signature shows how the builtin is called and Doc what it does, for
+	// editors.
+	Signature string
+	Doc       string
 }
 
 func (b *Builtin) Type() ObjectType { return BUILTIN_OBJ }
 func (b *Builtin) Inspect() string  { return "builtin function: " + b.Name }
 
-type BuiltinFunction func(args ...Object) Object
+type BuiltinFunction func(rt Runtime, args ...Object) Object
 
 type Array struct {
 	Elements []Object
@@ -156,15 +361,98 @@ type HashPair struct {
 	Value Object
 }
 
+// Hash keeps its pairs in the order their keys were first set. The pairs
+// are indexed by key for lookups; deleting one leaves a hole in the order,
+// and the holes are compacted away once they make up half of it.
 type Hash struct {
-	Pairs map[HashKey]HashPair
+	index map[HashKey]int // position of each key's pair in pairs
+	pairs []HashPair      // holes have a nil Key
+	holes int
+}
+
+// NewHash returns an empty hash with room for size pairs.
+func NewHash(size int) *Hash {
+	return &Hash{index: make(map[HashKey]int, size), pairs: make([]HashPair, 0, size)}
+}
+
+// Len returns the number of pairs.
+func (h *Hash) Len() int { return len(h.pairs) - h.holes }
+
+// Get returns the pair stored under key.
+func (h *Hash) Get(key HashKey) (HashPair, bool) {
+	i, ok := h.index[key]
+	if !ok {
+		return HashPair{}, false
+	}
+	return h.pairs[i], true
+}
+
+// Set stores pair under key. A key that is already set keeps its place in
+// the order.
+func (h *Hash) Set(key HashKey, pair HashPair) {
+	if i, ok := h.index[key]; ok {
+		h.pairs[i] = pair
+		return
+	}
+
+	if h.index == nil {
+		h.index = make(map[HashKey]int)
+	}
+
+	h.index[key] = len(h.pairs)
+	h.pairs = append(h.pairs, pair)
+}
+
+// Delete removes the pair stored under key and returns it.
+func (h *Hash) Delete(key HashKey) (HashPair, bool) {
+	i, ok := h.index[key]
+	if !ok {
+		return HashPair{}, false
+	}
+
+	pair := h.pairs[i]
+	delete(h.index, key)
+	h.pairs[i] = HashPair{}
+	h.holes++
+
+	if h.holes > len(h.pairs)/2 {
+		h.compact()
+	}
+
+	return pair, true
+}
+
+func (h *Hash) compact() {
+	pairs := make([]HashPair, 0, h.Len())
+	for _, pair := range h.pairs {
+		if pair.Key == nil {
+			continue
+		}
+		h.index[pair.Key.(Hashable).HashKey()] = len(pairs)
+		pairs = append(pairs, pair)
+	}
+
+	h.pairs = pairs
+	h.holes = 0
+}
+
+// Pairs returns the pairs in order. The slice is a copy, so the hash may
+// change while it is iterated over.
+func (h *Hash) Pairs() []HashPair {
+	pairs := make([]HashPair, 0, h.Len())
+	for _, pair := range h.pairs {
+		if pair.Key != nil {
+			pairs = append(pairs, pair)
+		}
+	}
+	return pairs
 }
 
 func (h *Hash) Type() ObjectType { return HASH_OBJ }
 func (h *Hash) Inspect() string {
 	var out bytes.Buffer
 	pairs := []string{}
-	for _, pair := range h.Pairs {
+	for _, pair := range h.Pairs() {
 		pairs = append(pairs, fmt.Sprintf("%s: %s",
 			pair.Key.Inspect(), pair.Value.Inspect()))
 	}
@@ -174,6 +462,17 @@ func (h *Hash) Inspect() string {
 	return out.String()
 }
 
+// Module is what an import binds: the exported bindings of a file.
+type Module struct {
+	Name    string
+	Path    string
+	Exports map[string]Object
+	Names   []string // the exports in the order they are declared
+}
+
+func (m *Module) Type() ObjectType { return MODULE_OBJ }
+func (m *Module) Inspect() string  { return fmt.Sprintf("<module %s>", m.Name) }
+
 type Hashable interface {
 	HashKey() HashKey
 }
@@ -1,6 +1,10 @@
 package object
 
-import "testing"
+import (
+	"monkey/src/token"
+	"strings"
+	"testing"
+)
 
 func TestStringHashKey(t *testing.T) {
 	hello1 := &String{Value: "hello"}
@@ -21,3 +25,160 @@ func TestStringHashKey(t *testing.T) {
 		t.Errorf("string with different content have same hash keys")
 	}
 }
+
+func TestFloatInspect(t *testing.T) {
+	tests := []struct {
+		value    float64
+		expected string
+	}{
+		{3.14, "3.14"},
+		{2, "2.0"},
+		{-0.5, "-0.5"},
+		{1e21, "1e+21"},
+	}
+
+	for _, tt := range tests {
+		f := &Float{Value: tt.value}
+		if f.Inspect() != tt.expected {
+			t.Errorf("wrong Inspect for %g. want=%q, got=%q", tt.value, tt.expected, f.Inspect())
+		}
+	}
+}
+
+func TestErrorTraceback(t *testing.T) {
+	err := &Error{
+		Kind:    INDEX_ERROR,
+		Message: "index out of range",
+		Pos:     token.Position{Filename: "lib.mky", Line: 3, Column: 5},
+		Stack: []Frame{
+			{Function: "get", Pos: token.Position{Filename: "lib.mky", Line: 9, Column: 1}},
+		},
+	}
+
+	expected := `IndexError: index out of range
+    at get (lib.mky:3:5)
+    at <main> (lib.mky:9:1)`
+
+	if err.Traceback() != expected {
+		t.Errorf("wrong traceback. want=%q, got=%q", expected, err.Traceback())
+	}
+
+	if err.Inspect() != "lib.mky:3:5: IndexError: index out of range" {
+		t.Errorf("wrong Inspect. got=%q", err.Inspect())
+	}
+}
+
+func TestErrorTracebackTruncated(t *testing.T) {
+	err := &Error{Message: "deep"}
+	for i := 0; i < maxTracebackFrames+5; i++ {
+		err.Stack = append(err.Stack, Frame{Function: "f", Pos: token.Position{Line: 1, Column: i + 1}})
+	}
+
+	traceback := err.Traceback()
+
+	if !strings.Contains(traceback, "    ... 5 more calls\n") {
+		t.Errorf("traceback not truncated. got=%q", traceback)
+	}
+
+	if !strings.HasSuffix(traceback, "    at <main> (1:25)") {
+		t.Errorf("traceback does not end with outermost call. got=%q", traceback)
+	}
+}
+
+func TestHashOrder(t *testing.T) {
+	hash := NewHash(0)
+
+	set := func(key string, value int64) {
+		k := &String{Value: key}
+		hash.Set(k.HashKey(), HashPair{Key: k, Value: &Integer{Value: value}})
+	}
+	del := func(key string) bool {
+		_, ok := hash.Delete((&String{Value: key}).HashKey())
+		return ok
+	}
+
+	set("c", 1)
+	set("a", 2)
+	set("b", 3)
+	set("a", 4)
+
+	if hash.Inspect() != `{c: 1, a: 4, b: 3}` {
+		t.Errorf("wrong order. got=%s", hash.Inspect())
+	}
+
+	if !del("c") || del("c") {
+		t.Errorf("c should be deleted exactly once")
+	}
+
+	set("d", 5)
+	set("c", 6)
+
+	if !del("a") || !del("d") {
+		t.Errorf("a and d should be deleted")
+	}
+
+	if hash.Len() != 2 || hash.Inspect() != `{b: 3, c: 6}` {
+		t.Errorf("wrong pairs after deleting. got %d pairs: %s", hash.Len(), hash.Inspect())
+	}
+
+	pair, ok := hash.Get((&String{Value: "c"}).HashKey())
+	if !ok || pair.Value.Inspect() != "6" {
+		t.Errorf("c not found after compacting. got=%+v", pair)
+	}
+}
+
+func TestEnvironmentNames(t *testing.T) {
+	env := NewEnvironment()
+	env.Set("b", NULL)
+	env.Set("a", TRUE)
+
+	inner := NewEnclosedEnvironement(env)
+	inner.Set("c", NULL)
+
+	if names := env.Names(); len(names) != 2 || names[0] != "a" || names[1] != "b" {
+		t.Errorf("wrong names. got=%v", names)
+	}
+
+	if names := inner.Names(); len(names) != 1 || names[0] != "c" {
+		t.Errorf("wrong names. got=%v", names)
+	}
+}
+
+func TestUpdateValueInOuterEnvironments(t *testing.T) {
+	global := NewEnvironment()
+	global.Set("x", &Integer{Value: 1})
+	inner := NewEnclosedEnvironement(NewEnclosedEnvironement(global))
+
+	if !inner.UpdateValue("x", &Integer{Value: 2}) {
+		t.Fatalf("expected x to be updated")
+	}
+	if x, _ := global.Get("x"); x.(*Integer).Value != 2 {
+		t.Errorf("x not updated in the global environment. got=%s", x.Inspect())
+	}
+
+	if inner.UpdateValue("y", &Integer{Value: 3}) {
+		t.Errorf("expected y not to be updated, it is not bound")
+	}
+}
+
+func TestRetainedSize(t *testing.T) {
+	s := &String{Value: "hello"}
+	shared := &Array{Elements: []Object{s, s}}
+	cyclic := &Array{Elements: []Object{nil}}
+	cyclic.Elements[0] = cyclic
+
+	closed := NewSlotEnvironment(nil, 1)
+	closed.SetAt(0, 0, &String{Value: "held"})
+
+	env := NewEnvironment()
+	env.Set("a", shared)
+	env.Set("b", shared)
+	env.Set("c", cyclic)
+	env.Set("f", &Function{Env: closed})
+	env.Set("n", &Integer{Value: 1})
+
+	want := StringSize(5) + ArraySize(2) + ArraySize(1) + StringSize(4)
+	if got := RetainedSize(env); got != want {
+		t.Errorf("wrong size. want=%d, got=%d", want, got)
+	}
+}
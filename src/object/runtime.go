@@ -0,0 +1,122 @@
+package object
+
+import (
+	"io"
+	"math"
+)
+
+// Runtime is the part of a running interpreter that builtins can reach.
+type Runtime interface {
+	// Alloc accounts for size bytes the builtin is about to allocate. It
+	// returns an error, instead of allowing the allocation, once the run's
+	// memory quota is exceeded.
+	Alloc(size int64) *Error
+
+	Stdout() io.Writer
+	Stderr() io.Writer
+
+	// ReadLine reads a line from standard input without its line ending.
+	// It reports false once the input is exhausted.
+	ReadLine() (string, bool)
+}
+
+// Approximate sizes in bytes used to account allocations against a memory
+// quota. They only need to be in the right ballpark.
+const (
+	ObjectSize   = 16 // an element slot holding an object
+	IntegerSize  = 8
+	stringHeader = 16 // the header, the bytes themselves are added on top
+	arrayHeader  = 24
+	hashHeader   = 48
+	HashPairSize = 64 // key, value and the map entry
+)
+
+func StringSize(n int64) int64 { return saturatingAdd(stringHeader, n) }
+func ArraySize(n int64) int64  { return saturatingAdd(arrayHeader, saturatingMul(n, ObjectSize)) }
+func HashSize(n int64) int64   { return saturatingAdd(hashHeader, saturatingMul(n, HashPairSize)) }
+
+// saturatingMul and saturatingAdd compute sizes, which are never negative,
+// stopping at math.MaxInt64 instead of wrapping around.
+func saturatingMul(n, size int64) int64 {
+	if n > math.MaxInt64/size {
+		return math.MaxInt64
+	}
+	return n * size
+}
+
+func saturatingAdd(a, b int64) int64 {
+	if a > math.MaxInt64-b {
+		return math.MaxInt64
+	}
+	return a + b
+}
+
+// RetainedSize estimates, with the sizes above, the memory held by what is
+// bound in env, and by everything those values reach, such as the
+// environments functions close over. A value reached twice counts once.
+func RetainedSize(env *Environment) int64 {
+	var size int64
+	seen := map[any]bool{}
+	envs := []*Environment{env}
+	var objects []Object
+
+	for len(envs) > 0 || len(objects) > 0 {
+		if n := len(envs); n > 0 {
+			e := envs[n-1]
+			envs = envs[:n-1]
+			if e == nil || seen[e] {
+				continue
+			}
+			seen[e] = true
+
+			for _, obj := range e.store {
+				objects = append(objects, obj)
+			}
+			objects = append(objects, e.slots...)
+			envs = append(envs, e.outer)
+			continue
+		}
+
+		obj := objects[len(objects)-1]
+		objects = objects[:len(objects)-1]
+		if obj == nil || seen[obj] {
+			continue
+		}
+
+		switch obj := obj.(type) {
+		case *String:
+			seen[obj] = true
+			size = saturatingAdd(size, StringSize(int64(len(obj.Value))))
+		case *Array:
+			seen[obj] = true
+			size = saturatingAdd(size, ArraySize(int64(len(obj.Elements))))
+			objects = append(objects, obj.Elements...)
+		case *Hash:
+			seen[obj] = true
+			size = saturatingAdd(size, HashSize(int64(obj.Len())))
+			for _, pair := range obj.pairs {
+				objects = append(objects, pair.Key, pair.Value)
+			}
+		case *Function:
+			envs = append(envs, obj.Env)
+		case *Closure:
+			seen[obj] = true
+			for _, cell := range obj.Free {
+				objects = append(objects, cell)
+			}
+			envs = append(envs, obj.Globals)
+		case *Cell:
+			seen[obj] = true
+			objects = append(objects, obj.Value)
+		case *Exception:
+			objects = append(objects, obj.Err.Value)
+		case *Module:
+			seen[obj] = true
+			for _, export := range obj.Exports {
+				objects = append(objects, export)
+			}
+		}
+	}
+
+	return size
+}
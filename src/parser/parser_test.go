@@ -2,8 +2,10 @@ package parser
 
 import (
 	"fmt"
+	"io"
 	"monkey/src/ast"
 	"monkey/src/lexer"
+	"os"
 	"testing"
 )
 
@@ -193,6 +195,25 @@ return 993322;
 
 }
 
+func TestReturnStatementWithoutSemicolon(t *testing.T) {
+	program := setup(t, "let f = fn(x) { return x }; f")
+
+	if len(program.Statements) != 2 {
+		t.Fatalf("program.Statements does not contain 2 statements. got=%d", len(program.Statements))
+	}
+
+	fn := program.Statements[0].(*ast.LetStatement).Value.(*ast.FunctionLiteral)
+	if len(fn.Body.Statements) != 1 {
+		t.Fatalf("function body does not contain 1 statement. got=%d", len(fn.Body.Statements))
+	}
+
+	stmt, ok := fn.Body.Statements[0].(*ast.ReturnStatement)
+	if !ok {
+		t.Fatalf("stmt not *ast.ReturnStatement. got=%T", fn.Body.Statements[0])
+	}
+	testIdentifier(t, stmt.ReturnValue, "x")
+}
+
 func TestIdentifierExpression(t *testing.T) {
 	input := "foobar;"
 
@@ -250,6 +271,31 @@ func TestIntegerLiteralExpression(t *testing.T) {
 
 }
 
+func TestFloatLiteralExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{"3.14;", 3.14},
+		{"1e-9", 1e-9},
+		{"2.5e3", 2500},
+	}
+
+	for _, tt := range tests {
+		program := setup(t, tt.input)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		literal, ok := stmt.Expression.(*ast.FloatLiteral)
+		if !ok {
+			t.Fatalf("exp not *ast.FloatLiteral. got=%T", stmt.Expression)
+		}
+
+		if literal.Value != tt.expected {
+			t.Errorf("literal.Value not %g. got=%g", tt.expected, literal.Value)
+		}
+	}
+}
+
 func TestStringLiteralExpression(t *testing.T) {
 	input := `"hello world";`
 
@@ -391,6 +437,11 @@ func TestParsingInfixExpresssions(t *testing.T) {
 		{"5 > 5;", 5, ">", 5},
 		{"5 == 5;", 5, "==", 5},
 		{"5 != 5;", 5, "!=", 5},
+		{"5 <= 5;", 5, "<=", 5},
+		{"5 >= 5;", 5, ">=", 5},
+		{"5 % 5;", 5, "%", 5},
+		{"true && false", true, "&&", false},
+		{"alice || bob", "alice", "||", "bob"},
 		{"alice == bob;", "alice", "==", "bob"},
 		{"alice != bob;", "alice", "!=", "bob"},
 		{"alice != 5;", "alice", "!=", 5},
@@ -519,6 +570,30 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"a * [1, 2, 3, 4][b * c] * d",
 			"((a * ([1, 2, 3, 4][(b * c)])) * d)",
 		},
+		{
+			"-1.5 * 2 + 0.25",
+			"(((-1.5) * 2) + 0.25)",
+		},
+		{
+			"a + b % c * d",
+			"(a + ((b % c) * d))",
+		},
+		{
+			"a <= b == c >= d",
+			"((a <= b) == (c >= d))",
+		},
+		{
+			"a || b && c",
+			"(a || (b && c))",
+		},
+		{
+			"a && b || c && d",
+			"((a && b) || (c && d))",
+		},
+		{
+			"a == 1 && !b || c < 2",
+			"(((a == 1) && (!b)) || (c < 2))",
+		},
 	}
 
 	for _, tt := range tests {
@@ -607,6 +682,27 @@ func TestIfExpression(t *testing.T) {
 
 }
 
+// TestParsingPrintsNothing checks the parser does not write to stdout,
+// where the output of the programs run goes.
+func TestParsingPrintsNothing(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+
+	setup(t, "for i, v in [1] { while true { break } }")
+
+	os.Stdout = stdout
+	w.Close()
+
+	out, _ := io.ReadAll(r)
+	if len(out) != 0 {
+		t.Errorf("expected no output. got=%q", out)
+	}
+}
+
 func TestForStatement(t *testing.T) {
 	input := `
 for i, v in arr {
@@ -651,6 +747,81 @@ for i, v in arr {
 	testLetStatment(t, block, "x")
 }
 
+func TestWhileStatement(t *testing.T) {
+	input := `
+while x < 10 {
+	if (x == 5) { break; }
+	continue
+}
+`
+
+	program := setup(t, input)
+
+	if !testStatementsLen(t, program, 1) {
+		return
+	}
+
+	stmt, ok := program.Statements[0].(*ast.WhileStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] not of type (*ast.WhileStatement). got=%T", program.Statements[0])
+	}
+
+	if !testInfixExpression(t, stmt.Condition, "x", "<", 10) {
+		return
+	}
+
+	if len(stmt.Block.Statements) != 2 {
+		t.Fatalf("stmt.Block.Statements len not 2 got=%d", len(stmt.Block.Statements))
+	}
+
+	ifExp := stmt.Block.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.IfExpression)
+	if _, ok := ifExp.Consequence.Statements[0].(*ast.BreakStatement); !ok {
+		t.Fatalf("if consequence not BreakStatement. got=%T", ifExp.Consequence.Statements[0])
+	}
+
+	if _, ok := stmt.Block.Statements[1].(*ast.ContinueStatement); !ok {
+		t.Fatalf("body statement 2 not ContinueStatement. got=%T", stmt.Block.Statements[1])
+	}
+}
+
+func TestBreakOutsideLoop(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"break;", "1:1: break outside loop"},
+		{"if (true) { continue }", "1:13: continue outside loop"},
+		{"while true { let f = fn() { break; }; }", "1:29: break outside loop"},
+		{"for i, v in arr { fn() { 1 }; break; }", ""},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+
+		if tt.expected == "" {
+			checkParserErrors(t, p)
+			continue
+		}
+
+		if len(p.Errors()) != 1 || p.Errors()[0] != tt.expected {
+			t.Errorf("wrong errors for %q. want=%q, got=%q", tt.input, tt.expected, p.Errors())
+		}
+	}
+}
+
+func TestReturnWithoutSemicolon(t *testing.T) {
+	program := setup(t, "fn(x) { return x }; return 5")
+
+	if !testStatementsLen(t, program, 2) {
+		return
+	}
+
+	if _, ok := program.Statements[1].(*ast.ReturnStatement); !ok {
+		t.Fatalf("program.Statements[1] not ReturnStatement. got=%T", program.Statements[1])
+	}
+}
+
 func TestIfElseExpression(t *testing.T) {
 	input := "if (x < y) { x } else { y }"
 
@@ -707,6 +878,61 @@ func TestIfElseExpression(t *testing.T) {
 	}
 }
 
+func TestElseIfExpression(t *testing.T) {
+	input := "if (x < y) { x } else if (x > y) { y } else if (z) { z } else { 0 }"
+
+	program := setup(t, input)
+
+	if !testStatementsLen(t, program, 1) {
+		return
+	}
+
+	stmt, ok := testExpressionStatement(t, program)
+	if !ok {
+		return
+	}
+
+	exp, ok := stmt.Expression.(*ast.IfExpression)
+	if !ok {
+		t.Fatalf("stmt.Expression not of type (*ast.IfExpression). got=%T", stmt.Expression)
+	}
+
+	if exp.Alternative != nil {
+		t.Fatalf("exp.Alternative was not nil. got=%+v", exp.Alternative)
+	}
+
+	second := exp.ElseIf
+	if second == nil {
+		t.Fatalf("exp.ElseIf is nil")
+	}
+
+	if !testInfixExpression(t, second.Condition, "x", ">", "y") {
+		return
+	}
+
+	third := second.ElseIf
+	if third == nil {
+		t.Fatalf("second.ElseIf is nil")
+	}
+
+	if !testIdentifier(t, third.Condition, "z") {
+		return
+	}
+
+	if third.Alternative == nil || len(third.Alternative.Statements) != 1 {
+		t.Fatalf("third.Alternative wrong. got=%+v", third.Alternative)
+	}
+
+	expected := "if ((x < y)) { x } else if ((x > y)) { y } else if (z) { z } else { 0 }"
+	if exp.String() != expected {
+		t.Errorf("exp.String() wrong. want=%q, got=%q", expected, exp.String())
+	}
+
+	if exp.End().String() != "1:68" {
+		t.Errorf("exp.End() wrong. want=%q, got=%q", "1:68", exp.End().String())
+	}
+}
+
 func TestFunctionLiteral(t *testing.T) {
 	input := `fn(x, y) { x + y; }`
 
@@ -746,6 +972,20 @@ func TestFunctionLiteral(t *testing.T) {
 	testInfixExpression(t, bodyStmt.Expression, "x", "+", "y")
 }
 
+func TestFunctionLiteralName(t *testing.T) {
+	program := setup(t, "let add = fn(a, b) { a + b }; fn() {}")
+
+	named := program.Statements[0].(*ast.LetStatement).Value.(*ast.FunctionLiteral)
+	if named.Name != "add" {
+		t.Errorf("function literal name wrong. want=%q, got=%q", "add", named.Name)
+	}
+
+	anonymous := program.Statements[1].(*ast.ExpressionStatement).Expression.(*ast.FunctionLiteral)
+	if anonymous.Name != "" {
+		t.Errorf("function literal name not empty. got=%q", anonymous.Name)
+	}
+}
+
 func TestFunctionParameterParsing(t *testing.T) {
 	tests := []struct {
 		input          string
@@ -1092,3 +1332,227 @@ func testBooleanLiteral(t *testing.T, exp ast.Expression, value bool) bool {
 
 	return true
 }
+
+func TestNodePositions(t *testing.T) {
+	input := `let add = fn(a, b) {
+	a + b;
+};
+add(1, [2, 3][0]);`
+
+	program := setup(t, input)
+
+	tests := []struct {
+		node ast.Node
+		pos  string
+		end  string
+	}{
+		{program, "1:1", "4:18"},
+		{program.Statements[0], "1:1", "3:2"},
+		{program.Statements[0].(*ast.LetStatement).Value, "1:11", "3:2"},
+		{program.Statements[1], "4:1", "4:18"},
+		{program.Statements[1].(*ast.ExpressionStatement).Expression.(*ast.CallExpression).Arguments[1], "4:8", "4:17"},
+	}
+
+	for i, tt := range tests {
+		if tt.node.Pos().String() != tt.pos {
+			t.Errorf("tests[%d] - Pos() wrong. expected=%q, got=%q", i, tt.pos, tt.node.Pos().String())
+		}
+		if tt.node.End().String() != tt.end {
+			t.Errorf("tests[%d] - End() wrong. expected=%q, got=%q", i, tt.end, tt.node.End().String())
+		}
+	}
+}
+
+func TestParserErrorPositions(t *testing.T) {
+	l := lexer.NewFile("test.mky", "let x = 5;\nlet = 10;")
+	p := New(l)
+	p.ParseProgram()
+
+	errors := p.Errors()
+	if len(errors) == 0 {
+		t.Fatalf("expected parser errors")
+	}
+
+	expected := "test.mky:2:5: expected next token to be IDENT, got = instead"
+	if errors[0] != expected {
+		t.Errorf("wrong error. expected=%q, got=%q", expected, errors[0])
+	}
+}
+
+func TestCommentsCollected(t *testing.T) {
+	input := `// adds two numbers
+let add = fn(a, b) {
+	a + b; /* inline */
+};
+add(1, 2); // call`
+
+	l := lexer.New(input)
+	l.KeepComments(true)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 2 {
+		t.Fatalf("program.Statements does not contain 2 statements. got=%d", len(program.Statements))
+	}
+
+	expected := []struct {
+		text string
+		pos  string
+	}{
+		{" adds two numbers", "1:1"},
+		{" inline ", "3:9"},
+		{" call", "5:12"},
+	}
+
+	if len(program.Comments) != len(expected) {
+		t.Fatalf("program.Comments has wrong length. want=%d, got=%d", len(expected), len(program.Comments))
+	}
+
+	for i, c := range expected {
+		comment := program.Comments[i]
+		if comment.Text() != c.text {
+			t.Errorf("comment[%d] text wrong. want=%q, got=%q", i, c.text, comment.Text())
+		}
+		if comment.Pos().String() != c.pos {
+			t.Errorf("comment[%d] pos wrong. want=%q, got=%q", i, c.pos, comment.Pos().String())
+		}
+	}
+}
+
+func TestTryStatement(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"try { x } catch (e) { y }", "try { x } catch (e) { y }"},
+		{"try { x } finally { z }", "try { x } finally { z }"},
+		{"try { x } catch (err) { y } finally { z }", "try { x } catch (err) { y } finally { z }"},
+		{"throw \"boom\"", "throw boom;"},
+		{"throw x + 1;", "throw (x + 1);"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("program.Statements does not contain 1 statement. got=%d", len(program.Statements))
+		}
+
+		if program.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, program.String())
+		}
+	}
+}
+
+func TestUnterminatedComment(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"1 /* x", "1:3: comment not terminated"},
+		{"let x = /* 1;\n", "1:9: comment not terminated"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+
+		if len(p.Errors()) == 0 || p.Errors()[0] != tt.expected {
+			t.Errorf("wrong errors for %q. want=%q, got=%q", tt.input, tt.expected, p.Errors())
+		}
+	}
+}
+
+func TestErrorDetails(t *testing.T) {
+	p := New(lexer.NewFile("a.mky", "let x = 1;\nlet = 2"))
+	p.ParseProgram()
+
+	details := p.ErrorDetails()
+	if len(details) == 0 {
+		t.Fatalf("expected errors")
+	}
+
+	err := details[0]
+	if err.Pos.Filename != "a.mky" || err.Pos.Line != 2 || err.Pos.Column != 5 || err.Pos.Offset != 15 ||
+		err.Message != "expected next token to be IDENT, got = instead" {
+		t.Errorf("wrong error. got=%+v", err)
+	}
+	if p.Errors()[0] != "a.mky:2:5: "+err.Message {
+		t.Errorf("wrong message. got=%q", p.Errors()[0])
+	}
+}
+
+func TestTryStatementErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"try { x }", "1:10: expected catch or finally after try block, got EOF instead"},
+		{"try { x } catch { y }", "1:17: expected next token to be (, got { instead"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+
+		if len(p.Errors()) == 0 || p.Errors()[0] != tt.expected {
+			t.Errorf("wrong errors for %q. want=%q, got=%q", tt.input, tt.expected, p.Errors())
+		}
+	}
+}
+
+func TestModuleStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`import "lib/math.mky"`, `import "lib/math.mky";`},
+		{`import "math.mky" as m;`, `import "math.mky" as m;`},
+		{"export let x = 1;", "export let x = 1;"},
+		{"math.add(1, 2)", "(math.add)(1, 2)"},
+		{"a.b.c", "((a.b).c)"},
+		{"a.b[0]", "((a.b)[0])"},
+		{"-a.b", "(-(a.b))"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("program.Statements does not contain 1 statement. got=%d", len(program.Statements))
+		}
+
+		if program.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, program.String())
+		}
+	}
+}
+
+func TestModuleStatementErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"import math", "1:8: expected next token to be STRING, got IDENT instead"},
+		{`import "my-lib.mky"`, `1:8: cannot name module "my-lib.mky" after its file, import it with as`},
+		{`import "lib.mky" as 1`, "1:21: expected next token to be IDENT, got INT instead"},
+		{"export x = 1", "1:8: expected next token to be LET, got IDENT instead"},
+		{"fn() { export let x = 1 }", "1:8: export outside the top level of a module"},
+		{"a.1", "1:3: expected next token to be IDENT, got INT instead"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+
+		if len(p.Errors()) == 0 || p.Errors()[0] != tt.expected {
+			t.Errorf("wrong errors for %q. want=%q, got=%q", tt.input, tt.expected, p.Errors())
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package repl
+
+import (
+	"fmt"
+	"monkey/src/ast"
+	"monkey/src/lexer"
+	"monkey/src/parser"
+	"monkey/src/token"
+	"os"
+	"strings"
+	"time"
+)
+
+const commandHelp = `:env             list the bindings of the session
+:ast <program>   show the tree program parses to
+:tokens <input>  show the tokens input lexes to
+:load <file>     run file in the session
+:save <file>     write the inputs that ran without an error to file
+:reset           clear the bindings and loaded modules
+:time <program>  run program and report how long it took
+:help            show this help
+`
+
+// command runs a line starting with a colon.
+func (s *session) command(line string) {
+	name, arg, _ := strings.Cut(line, " ")
+	arg = strings.TrimSpace(arg)
+
+	needsArg := func(what string) bool {
+		if arg == "" {
+			fmt.Fprintf(s.out, "%s needs %s\n", name, what)
+			return false
+		}
+		return true
+	}
+
+	switch name {
+	case ":env":
+		for _, name := range s.env.Names() {
+			value, _ := s.env.Get(name)
+			fmt.Fprintf(s.out, "%s = %s\n", name, value.Inspect())
+		}
+
+	case ":ast":
+		if !needsArg("a program") {
+			return
+		}
+		p := parser.New(lexer.New(arg))
+		program := p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			printParserErrors(s.out, p.Errors())
+			return
+		}
+		fmt.Fprint(s.out, ast.Dump(program))
+
+	case ":tokens":
+		if !needsArg("some input") {
+			return
+		}
+		l := lexer.New(arg)
+		for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+			fmt.Fprintf(s.out, "%s\t%s\t%q\n", tok.Pos, tok.Type, tok.Literal)
+		}
+
+	case ":load":
+		if !needsArg("a file") {
+			return
+		}
+		src, err := os.ReadFile(arg)
+		if err != nil {
+			fmt.Fprintln(s.out, err)
+			return
+		}
+		if s.eval(arg, string(src)) {
+			s.inputs = append(s.inputs, string(src))
+		}
+
+	case ":save":
+		if !needsArg("a file") {
+			return
+		}
+		if err := os.WriteFile(arg, []byte(strings.Join(s.inputs, "")), 0o644); err != nil {
+			fmt.Fprintln(s.out, err)
+			return
+		}
+		fmt.Fprintf(s.out, "saved %d inputs to %s\n", len(s.inputs), arg)
+
+	case ":reset":
+		s.reset()
+
+	case ":time":
+		if !needsArg("a program") {
+			return
+		}
+		start := time.Now()
+		if s.eval("", arg+"\n") {
+			s.inputs = append(s.inputs, arg+"\n")
+		}
+		fmt.Fprintf(s.out, "took %s\n", time.Since(start))
+
+	case ":help":
+		fmt.Fprint(s.out, commandHelp)
+
+	default:
+		fmt.Fprintf(s.out, "unknown command %s, :help lists the commands\n", name)
+	}
+}
@@ -0,0 +1,124 @@
+package repl
+
+import (
+	"monkey/src/object"
+	"monkey/src/token"
+	"sort"
+	"strings"
+)
+
+// commandNames are the commands completed at the start of a line.
+var commandNames = []string{":ast", ":env", ":help", ":load", ":reset", ":save", ":time", ":tokens"}
+
+// complete returns the completions of the text before the cursor in line:
+// where the completed text starts, and what may replace it. After the
+// name of a hash and a [, the string keys of the hash are offered; after
+// a module and a dot, its exports; anywhere else the keywords, builtins and
+// bindings of the session starting with the word before the cursor.
+func (s *session) complete(line string) (int, []string) {
+	if strings.HasPrefix(line, ":") && !strings.Contains(line, " ") {
+		return 0, withPrefix(commandNames, line)
+	}
+
+	start := wordStart(line)
+	word := line[start:]
+
+	if start > 0 && line[start-1] == '.' {
+		if module, ok := s.lookup(line[wordStart(line[:start-1]) : start-1]).(*object.Module); ok {
+			return start, withPrefix(module.Names, word)
+		}
+		return start, nil
+	}
+
+	if start, keys, ok := s.completeKey(line); ok {
+		return start, keys
+	}
+
+	if word == "" {
+		return start, nil
+	}
+
+	names := token.Keywords()
+	for name := range object.Builtins {
+		names = append(names, name)
+	}
+	names = append(names, s.env.Names()...)
+
+	return start, withPrefix(names, word)
+}
+
+// completeKey completes the string key of a hash indexed at the end of
+// line, as in h[ or h["na. Keys are completed with their closing quote
+// and bracket.
+func (s *session) completeKey(line string) (int, []string, bool) {
+	start := len(line)
+
+	prefix := ""
+	if i := strings.LastIndexAny(line, `["`); i >= 0 && line[i] == '"' {
+		prefix = line[i+1:]
+		start = i
+		if strings.ContainsAny(prefix, `\]`) {
+			return 0, nil, false
+		}
+	}
+
+	open := strings.TrimRight(line[:start], " ")
+	if !strings.HasSuffix(open, "[") {
+		return 0, nil, false
+	}
+
+	name := open[:len(open)-1]
+	hash, ok := s.lookup(name[wordStart(name):]).(*object.Hash)
+	if !ok || name[wordStart(name):] == "" {
+		return 0, nil, false
+	}
+
+	keys := []string{}
+	for _, pair := range hash.Pairs() {
+		if key, ok := pair.Key.(*object.String); ok && strings.HasPrefix(key.Value, prefix) {
+			keys = append(keys, `"`+key.Value+`"]`)
+		}
+	}
+	sort.Strings(keys)
+
+	return start, keys, true
+}
+
+// lookup returns the value bound to name in the session, or nil.
+func (s *session) lookup(name string) object.Object {
+	if name == "" {
+		return nil
+	}
+
+	value, _ := s.env.Get(name)
+	return value
+}
+
+// wordStart returns where the identifier the text ends with starts.
+func wordStart(text string) int {
+	i := len(text)
+	for i > 0 && isIdentByte(text[i-1]) {
+		i--
+	}
+	return i
+}
+
+func isIdentByte(ch byte) bool {
+	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || '0' <= ch && ch <= '9' || ch == '_'
+}
+
+// withPrefix returns the distinct names starting with prefix, sorted.
+func withPrefix(names []string, prefix string) []string {
+	seen := map[string]bool{}
+	matches := []string{}
+
+	for _, name := range names {
+		if strings.HasPrefix(name, prefix) && !seen[name] {
+			seen[name] = true
+			matches = append(matches, name)
+		}
+	}
+	sort.Strings(matches)
+
+	return matches
+}
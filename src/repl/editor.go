@@ -0,0 +1,322 @@
+package repl
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// errInterrupted is returned by readLine when the user pressed Ctrl-C.
+var errInterrupted = errors.New("interrupted")
+
+// lineReader reads the lines typed at the REPL.
+type lineReader interface {
+	readLine(prompt string) (string, error)
+}
+
+// completer returns the completions of the text before the cursor: where
+// the text they replace starts, and the completions.
+type completer func(text string) (int, []string)
+
+// newLineReader returns a line editor with history and completion when in
+// and out are a terminal, and a plain reader otherwise. Both read in
+// through r, which the programs run read it through too.
+func newLineReader(in io.Reader, r *bufio.Reader, out io.Writer, historyFile string, complete completer) lineReader {
+	inFile, ok := in.(*os.File)
+	outFile, ok2 := out.(*os.File)
+	if ok && ok2 && isTerminal(int(inFile.Fd())) && isTerminal(int(outFile.Fd())) {
+		return &editor{
+			fd:       int(inFile.Fd()),
+			in:       r,
+			out:      outFile,
+			history:  loadHistory(historyFile),
+			complete: complete,
+		}
+	}
+
+	return &plainReader{in: r, out: out}
+}
+
+// plainReader reads lines from a pipe or a file.
+type plainReader struct {
+	in  *bufio.Reader
+	out io.Writer
+}
+
+func (r *plainReader) readLine(prompt string) (string, error) {
+	fmt.Fprint(r.out, prompt)
+
+	line, err := r.in.ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// editor reads lines from a terminal in raw mode, with the usual cursor
+// movement and editing keys, a history browsed with the arrow keys and
+// completion on tab.
+type editor struct {
+	fd       int
+	in       *bufio.Reader
+	out      io.Writer
+	history  *history
+	complete completer
+
+	prompt string
+	buf    []rune
+	pos    int // cursor position in buf
+}
+
+func (e *editor) readLine(prompt string) (string, error) {
+	restore, err := makeRaw(e.fd)
+	if err != nil {
+		return "", err
+	}
+	defer restore()
+
+	e.prompt = prompt
+	e.buf = e.buf[:0]
+	e.pos = 0
+	e.refresh()
+
+	// index of the history line shown, and the line being typed before
+	// browsing it
+	entry := len(e.history.lines)
+	typed := ""
+
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+
+		switch r {
+		case '\r', '\n':
+			line := string(e.buf)
+			io.WriteString(e.out, "\r\n")
+			e.history.add(line)
+			return line, nil
+
+		case ctrl('C'):
+			io.WriteString(e.out, "^C\r\n")
+			return "", errInterrupted
+
+		case ctrl('D'):
+			if len(e.buf) == 0 {
+				io.WriteString(e.out, "\r\n")
+				return "", io.EOF
+			}
+			e.delete(e.pos, e.pos+1)
+
+		case 127, ctrl('H'):
+			if e.pos > 0 {
+				e.delete(e.pos-1, e.pos)
+			}
+
+		case ctrl('A'):
+			e.pos = 0
+		case ctrl('E'):
+			e.pos = len(e.buf)
+		case ctrl('B'):
+			e.move(-1)
+		case ctrl('F'):
+			e.move(1)
+		case ctrl('K'):
+			e.buf = e.buf[:e.pos]
+		case ctrl('U'):
+			e.delete(0, e.pos)
+		case ctrl('W'):
+			e.delete(e.wordStart(), e.pos)
+		case '\t':
+			e.completeWord()
+		case ctrl('L'):
+			io.WriteString(e.out, "\x1b[H\x1b[2J")
+
+		case ctrl('P'):
+			entry, typed = e.browse(entry, -1, typed)
+		case ctrl('N'):
+			entry, typed = e.browse(entry, 1, typed)
+
+		case 27:
+			switch e.escape() {
+			case 'A':
+				entry, typed = e.browse(entry, -1, typed)
+			case 'B':
+				entry, typed = e.browse(entry, 1, typed)
+			case 'C':
+				e.move(1)
+			case 'D':
+				e.move(-1)
+			case 'H':
+				e.pos = 0
+			case 'F':
+				e.pos = len(e.buf)
+			case '~':
+				if e.pos < len(e.buf) {
+					e.delete(e.pos, e.pos+1)
+				}
+			}
+
+		default:
+			if unicode.IsPrint(r) {
+				e.insert(string(r))
+			}
+		}
+
+		e.refresh()
+	}
+}
+
+func ctrl(key rune) rune {
+	return key & 0x1f
+}
+
+// escape reads the rest of an escape sequence and returns the key it is
+// for: A to D for the arrows, H and F for home and end, ~ for delete, or 0
+// for sequences the editor ignores.
+func (e *editor) escape() rune {
+	r, _, err := e.in.ReadRune()
+	if err != nil || r != '[' && r != 'O' {
+		return 0
+	}
+
+	params := ""
+	for {
+		r, _, err = e.in.ReadRune()
+		if err != nil {
+			return 0
+		}
+		if r < '0' || r > '9' && r != ';' {
+			break
+		}
+		params += string(r)
+	}
+
+	if r != '~' {
+		return r
+	}
+
+	switch params {
+	case "1", "7":
+		return 'H'
+	case "4", "8":
+		return 'F'
+	case "3":
+		return '~'
+	}
+
+	return 0
+}
+
+// completeWord completes the text before the cursor. A single completion
+// replaces it; of several, their common prefix does, or when that adds
+// nothing they are listed below the line.
+func (e *editor) completeWord() {
+	text := string(e.buf[:e.pos])
+	start, completions := e.complete(text)
+
+	switch len(completions) {
+	case 0:
+		io.WriteString(e.out, "\a")
+		return
+	case 1:
+		e.replace(text, start, completions[0])
+		return
+	}
+
+	prefix := completions[0]
+	for _, c := range completions[1:] {
+		for !strings.HasPrefix(c, prefix) {
+			_, size := utf8.DecodeLastRuneInString(prefix)
+			prefix = prefix[:len(prefix)-size]
+		}
+	}
+
+	if len(prefix) > len(text)-start {
+		e.replace(text, start, prefix)
+		return
+	}
+
+	io.WriteString(e.out, "\r\n"+strings.Join(completions, "  ")+"\r\n")
+}
+
+// replace replaces what follows start in text, the text before the cursor,
+// with s.
+func (e *editor) replace(text string, start int, s string) {
+	e.delete(len([]rune(text[:start])), e.pos)
+	e.insert(s)
+}
+
+func (e *editor) insert(s string) {
+	runes := []rune(s)
+	e.buf = append(e.buf[:e.pos], append(runes, e.buf[e.pos:]...)...)
+	e.pos += len(runes)
+}
+
+func (e *editor) delete(from, to int) {
+	if to > len(e.buf) {
+		to = len(e.buf)
+	}
+	e.buf = append(e.buf[:from], e.buf[to:]...)
+	e.pos = from
+}
+
+func (e *editor) move(n int) {
+	if pos := e.pos + n; pos >= 0 && pos <= len(e.buf) {
+		e.pos = pos
+	}
+}
+
+// wordStart returns where the word before the cursor starts.
+func (e *editor) wordStart() int {
+	i := e.pos
+	for i > 0 && unicode.IsSpace(e.buf[i-1]) {
+		i--
+	}
+	for i > 0 && !unicode.IsSpace(e.buf[i-1]) {
+		i--
+	}
+	return i
+}
+
+// browse shows the history line step lines away from entry, and returns
+// the new entry and the line typed before browsing.
+func (e *editor) browse(entry, step int, typed string) (int, string) {
+	next := entry + step
+	if next < 0 || next > len(e.history.lines) {
+		return entry, typed
+	}
+
+	if entry == len(e.history.lines) {
+		typed = string(e.buf)
+	}
+
+	line := typed
+	if next < len(e.history.lines) {
+		line = e.history.lines[next]
+	}
+
+	e.buf = []rune(line)
+	e.pos = len(e.buf)
+
+	return next, typed
+}
+
+// refresh redraws the line and puts the cursor back in place.
+func (e *editor) refresh() {
+	var out strings.Builder
+
+	out.WriteString("\r" + e.prompt + string(e.buf) + "\x1b[K")
+	if back := len(e.buf) - e.pos; back > 0 {
+		fmt.Fprintf(&out, "\x1b[%dD", back)
+	}
+
+	io.WriteString(e.out, out.String())
+}
@@ -0,0 +1,98 @@
+package repl
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// maxHistory is the number of lines kept in the history.
+const maxHistory = 1000
+
+// HistoryFile returns the file the history of REPL sessions is kept in by
+// default: $MONKEY_HISTORY, or .monkey_history in the home directory.
+func HistoryFile() string {
+	if file := os.Getenv("MONKEY_HISTORY"); file != "" {
+		return file
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(home, ".monkey_history")
+}
+
+// history holds the lines entered in this and earlier sessions, oldest
+// first. New lines are appended to its file as they are added.
+type history struct {
+	lines []string
+	file  string
+}
+
+// loadHistory reads the history kept in file. A missing or unreadable file
+// gives an empty history; file is then created with the first line added.
+func loadHistory(file string) *history {
+	h := &history{file: file}
+	if file == "" {
+		return h
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return h
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		h.lines = append(h.lines, scanner.Text())
+	}
+	if len(h.lines) > 2*maxHistory {
+		h.lines = h.lines[len(h.lines)-maxHistory:]
+		h.save()
+	} else if len(h.lines) > maxHistory {
+		h.lines = h.lines[len(h.lines)-maxHistory:]
+	}
+
+	return h
+}
+
+// save replaces the file with the lines kept, so it does not grow without
+// end as lines are appended.
+func (h *history) save() {
+	var out strings.Builder
+	for _, line := range h.lines {
+		out.WriteString(line + "\n")
+	}
+
+	os.WriteFile(h.file, []byte(out.String()), 0o600)
+}
+
+// add appends line to the history, unless it is blank or repeats the line
+// before it.
+func (h *history) add(line string) {
+	if line == "" || len(h.lines) > 0 && h.lines[len(h.lines)-1] == line {
+		return
+	}
+
+	h.lines = append(h.lines, line)
+	if len(h.lines) > maxHistory {
+		h.lines = h.lines[1:]
+	}
+
+	if h.file == "" {
+		return
+	}
+
+	// failing to save the history should not end the session
+	f, err := os.OpenFile(h.file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	f.WriteString(line + "\n")
+}
@@ -0,0 +1,48 @@
+package repl
+
+import "strings"
+
+// incomplete reports whether src stops in the middle of a program: inside
+// a string or a block comment, or with brackets, braces or parentheses left
+// open. The REPL keeps reading lines until the input is complete;
+// unbalanced closing brackets are left for the parser to report.
+func incomplete(src string) bool {
+	depth := 0
+
+	for i := 0; i < len(src); i++ {
+		switch src[i] {
+		case '"':
+			for i++; i < len(src) && src[i] != '"'; i++ {
+				if src[i] == '\\' {
+					i++
+				}
+			}
+			if i >= len(src) {
+				return true
+			}
+
+		case '/':
+			switch {
+			case strings.HasPrefix(src[i:], "//"):
+				for i < len(src) && src[i] != '\n' {
+					i++
+				}
+
+			case strings.HasPrefix(src[i:], "/*"):
+				end := strings.Index(src[i+2:], "*/")
+				if end < 0 {
+					return true
+				}
+				i += 2 + end + 1
+			}
+
+		case '(', '[', '{':
+			depth++
+
+		case ')', ']', '}':
+			depth--
+		}
+	}
+
+	return depth > 0
+}
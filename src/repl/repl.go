@@ -2,45 +2,135 @@ package repl
 
 import (
 	"bufio"
-	"bytes"
-	"fmt"
+	"context"
 	"io"
 	"monkey/src/evaluator"
 	"monkey/src/lexer"
 	"monkey/src/object"
 	"monkey/src/parser"
+	"monkey/src/vm"
+	"strings"
 )
 
 const PROMPT = ">> "
 
-func Start(in io.Reader, out io.Writer) {
-	scanner := bufio.NewScanner(in)
-	env := object.NewEnvironment()
+// CONTINUATION_PROMPT is shown while the input so far is incomplete.
+const CONTINUATION_PROMPT = ".. "
+
+// The engines Start can run programs with.
+const (
+	EngineEval = "eval" // the tree-walking evaluator
+	EngineVM   = "vm"   // the bytecode compiler and virtual machine
+)
+
+// Options configure a REPL session.
+type Options struct {
+	Engine     string   // EngineEval when empty
+	ImportPath []string // directories searched for imported modules
+
+	// HistoryFile keeps the lines typed at a terminal across sessions.
+	// There is no history when it is empty.
+	HistoryFile string
+}
+
+// Start reads programs from in and prints their values to out until in
+// ends. A program may span several lines: lines are read until strings and
+// brackets are closed. Lines starting with a colon are commands, see
+// :help.
+func Start(in io.Reader, out io.Writer, opts Options) {
+	s := newSession(in, out, opts)
+	lines := newLineReader(in, s.in, out, opts.HistoryFile, s.complete)
+
+	var input strings.Builder
 
 	for {
-		fmt.Print(PROMPT)
-		scanned := scanner.Scan()
-		if !scanned {
-			return
+		prompt := PROMPT
+		if input.Len() > 0 {
+			prompt = CONTINUATION_PROMPT
 		}
 
-		line := scanner.Text()
+		line, err := lines.readLine(prompt)
+		if err == errInterrupted {
+			input.Reset()
+			continue
+		}
+		if err != nil {
+			return
+		}
 
-		l := lexer.New(line)
-		p := parser.New(l)
+		if input.Len() == 0 && strings.HasPrefix(strings.TrimSpace(line), ":") {
+			s.command(strings.TrimSpace(line))
+			continue
+		}
 
-		program := p.ParseProgram()
-		if len(p.Errors()) != 0 {
-			printParserErrors(out, p.Errors())
+		input.WriteString(line + "\n")
+		if incomplete(input.String()) {
+			continue
 		}
 
-		evaluated := evaluator.Eval(program, env, &bytes.Buffer{})
-		if evaluated != nil {
-			io.WriteString(out, evaluated.Inspect())
-			io.WriteString(out, "\n")
+		src := input.String()
+		input.Reset()
+
+		if s.eval("", src) {
+			s.inputs = append(s.inputs, src)
 		}
+	}
+}
 
+// session is the state a REPL keeps between inputs.
+type session struct {
+	in   *bufio.Reader // shared by the lines typed and the programs' gets
+	out  io.Writer
+	opts Options
+
+	interp *evaluator.Interpreter
+	env    *object.Environment
+	inputs []string // the inputs that ran without an error, for :save
+}
+
+func newSession(in io.Reader, out io.Writer, opts Options) *session {
+	s := &session{in: bufio.NewReader(in), out: out, opts: opts}
+	s.reset()
+
+	return s
+}
+
+// reset starts the session over, with no bindings and no modules loaded.
+func (s *session) reset() {
+	s.interp = &evaluator.Interpreter{Stdout: s.out, Stderr: s.out, Stdin: s.in, ImportPath: s.opts.ImportPath}
+	s.env = s.interp.Globals()
+	s.inputs = nil
+}
+
+// eval runs the program src read from file, which is empty when it was
+// typed, and prints its value. It reports whether it ran without an error.
+func (s *session) eval(file, src string) bool {
+	p := parser.New(lexer.NewFile(file, src))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		printParserErrors(s.out, p.Errors())
+		return false
 	}
+
+	var evaluated object.Object
+	if s.opts.Engine == EngineVM {
+		evaluated = vm.Run(context.Background(), program, s.env, s.interp)
+	} else {
+		evaluated = s.interp.Eval(program, s.env)
+	}
+
+	if err, ok := evaluated.(*object.Error); ok {
+		io.WriteString(s.out, err.Traceback())
+		io.WriteString(s.out, "\n")
+		return false
+	}
+
+	if evaluated != nil {
+		io.WriteString(s.out, evaluated.Inspect())
+		io.WriteString(s.out, "\n")
+	}
+
+	return true
 }
 
 const MONKEY_FACE = `MONKEY`
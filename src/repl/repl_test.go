@@ -0,0 +1,227 @@
+package repl
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIncomplete(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"1 + 2", false},
+		{"let f = fn(x) {", true},
+		{"let f = fn(x) {\n x }", false},
+		{"[1, 2,", true},
+		{"puts(1,", true},
+		{`"abc`, true},
+		{`"a { b"`, false},
+		{`"say \"hi"`, false},
+		{`"say \"`, true},
+		{"1 // {", false},
+		{"fn() { // }\n", true},
+		{"/* { */ 1", false},
+		{"fn() { /* } */", true},
+		{"1 /* a\n", true},
+		{"/*/ { */", false},
+		{"1 // x\n*2", false},
+		{"}", false},
+	}
+
+	for _, tt := range tests {
+		if got := incomplete(tt.input); got != tt.expected {
+			t.Errorf("incomplete(%q) = %t, want %t", tt.input, got, tt.expected)
+		}
+	}
+}
+
+func TestStart(t *testing.T) {
+	input := `let add = fn(a, b) {
+  a + b
+};
+puts(add(1, 2))
+for i, v in [1, 2] {
+  puts(v)
+}
+let x = ;
+"two
+lines"
+x
+`
+	var out bytes.Buffer
+	Start(strings.NewReader(input), &out, Options{})
+
+	expected := []string{
+		">> .. .. >> 3",
+		"null",
+		">> .. .. 1",
+		"2",
+		"null",
+		">> " + MONKEY_FACE + "Woops! We ran into some monkey business here!",
+		" parser errors:",
+		"\t1:9: no prefix parse func for ; found",
+		">> .. two",
+		"lines",
+		">> NameError: identifier not found: x",
+		"    at <main> (1:1)",
+		">> ",
+	}
+	if out.String() != strings.Join(expected, "\n") {
+		t.Errorf("wrong output.\nwant=%q\ngot= %q", strings.Join(expected, "\n"), out.String())
+	}
+}
+
+// TestStartGets checks programs read the lines after them, the lines the
+// REPL has not read yet.
+func TestStartGets(t *testing.T) {
+	input := "let name = gets()\nmonkey\nputs(name, gets())\nbusiness\n"
+
+	var out bytes.Buffer
+	Start(strings.NewReader(input), &out, Options{})
+
+	expected := ">> >> monkey, business\nnull\n>> "
+	if out.String() != expected {
+		t.Errorf("wrong output.\nwant=%q\ngot= %q", expected, out.String())
+	}
+}
+
+func TestHistory(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "history")
+
+	h := loadHistory(file)
+	for _, line := range []string{"let a = 1", "", "a", "a", "a + 1"} {
+		h.add(line)
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "let a = 1\na\na + 1\n" {
+		t.Errorf("wrong history file. got=%q", data)
+	}
+
+	if lines := loadHistory(file).lines; strings.Join(lines, "|") != "let a = 1|a|a + 1" {
+		t.Errorf("wrong history loaded. got=%q", lines)
+	}
+}
+
+func TestHistoryTrimmed(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "history")
+
+	var data strings.Builder
+	for i := 0; i < 2*maxHistory+1; i++ {
+		data.WriteString(strings.Repeat("x", i%7+1) + "\n")
+	}
+	os.WriteFile(file, []byte(data.String()), 0o600)
+
+	h := loadHistory(file)
+	if len(h.lines) != maxHistory {
+		t.Fatalf("expected %d lines. got=%d", maxHistory, len(h.lines))
+	}
+
+	if lines := loadHistory(file).lines; len(lines) != maxHistory || lines[0] != h.lines[0] {
+		t.Errorf("expected the file to be trimmed. got %d lines", len(lines))
+	}
+}
+
+func TestCommands(t *testing.T) {
+	dir := t.TempDir()
+	saved := filepath.Join(dir, "session.mky")
+	os.WriteFile(filepath.Join(dir, "lib.mky"), []byte("let y = 10;\n"), 0o644)
+
+	input := strings.Join([]string{
+		"let x = 2",
+		":env",
+		":tokens x + 1",
+		":ast -x",
+		"x / 0",
+		":load " + filepath.Join(dir, "lib.mky"),
+		":save " + saved,
+		":reset",
+		":env",
+		":load " + saved,
+		"x + y",
+		":load",
+		":nope",
+	}, "\n")
+
+	var out bytes.Buffer
+	Start(strings.NewReader(input), &out, Options{})
+
+	expected := []string{
+		">> >> args = []",
+		"x = 2",
+		">> 1:1\tIDENT\t\"x\"",
+		"1:3\t+\t\"+\"",
+		"1:5\tINT\t\"1\"",
+		">> Program 1:1",
+		"  Statements[0]: ExpressionStatement 1:1",
+		"    Expression: PrefixExpression 1:1 Operator=\"-\"",
+		"      Right: Identifier 1:2 Value=\"x\"",
+		">> ZeroDivisionError: division by zero",
+		"    at <main> (1:1)",
+		">> >> saved 2 inputs to " + saved,
+		">> >> args = []",
+		">> >> 12",
+		">> :load needs a file",
+		">> unknown command :nope, :help lists the commands",
+		">> ",
+	}
+	if out.String() != strings.Join(expected, "\n") {
+		t.Errorf("wrong output.\nwant=%q\ngot= %q", strings.Join(expected, "\n"), out.String())
+	}
+
+	data, _ := os.ReadFile(saved)
+	if string(data) != "let x = 2\nlet y = 10;\n" {
+		t.Errorf("wrong session saved. got=%q", data)
+	}
+}
+
+func TestTimeCommand(t *testing.T) {
+	var out bytes.Buffer
+	Start(strings.NewReader(":time 1 + 2\n"), &out, Options{})
+
+	if !strings.HasPrefix(out.String(), ">> 3\ntook ") {
+		t.Errorf("wrong output. got=%q", out.String())
+	}
+}
+
+func TestComplete(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "geo.mky"), []byte("export let area = 1; export let angle = 2; let hidden = 3;"), 0o644)
+
+	s := newSession(strings.NewReader(""), &bytes.Buffer{}, Options{ImportPath: []string{dir}})
+	s.eval("", `let person = {"name": "x", "nick": "y", 1: 2}; let pull = 1; import "geo.mky"`)
+
+	tests := []struct {
+		text     string
+		start    int
+		expected []string
+	}{
+		{"pu", 0, []string{"pull", "push", "puts"}},
+		{"let x = fir", 8, []string{"first"}},
+		{"wh", 0, []string{"while"}},
+		{"pers", 0, []string{"person"}},
+		{"x + ", 4, nil},
+		{"person[", 7, []string{`"name"]`, `"nick"]`}},
+		{`person["ni`, 7, []string{`"nick"]`}},
+		{`puts(person[ "na`, 13, []string{`"name"]`}},
+		{"pull[", 5, nil},
+		{"geo.a", 4, []string{"angle", "area"}},
+		{"geo.h", 4, nil},
+		{":t", 0, []string{":time", ":tokens"}},
+	}
+
+	for _, tt := range tests {
+		start, got := s.complete(tt.text)
+
+		if start != tt.start || strings.Join(got, " ") != strings.Join(tt.expected, " ") {
+			t.Errorf("complete(%q) = %d, %q. want %d, %q", tt.text, start, got, tt.start, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,375 @@
+// Package resolver binds identifiers before a program runs. Names bound in
+// a function or block scope get the depth and slot of their binding, so the
+// evaluator reaches them by index instead of looking them up by name, and
+// names that are never bound are reported before anything is evaluated.
+package resolver
+
+import (
+	"fmt"
+	"monkey/src/ast"
+	"monkey/src/object"
+	"sort"
+)
+
+// scope is one environment of the running program: the globals, a function
+// call, an iteration of a for loop or a catch block. if, while and try blocks
+// bind names in the scope around them, as they do when evaluated.
+type scope struct {
+	outer    *scope
+	function bool
+	names    map[string]*binding
+	size     int
+	info     *Scope // what Inspect reports of the scope
+}
+
+type binding struct {
+	slot    int
+	defined bool            // false until the let binding the name has been passed
+	def     *ast.Identifier // the latest identifier binding the name
+}
+
+type resolver struct {
+	env    *object.Environment
+	scope  *scope
+	errors []*object.Error
+	info   *Info // nil unless inspecting
+}
+
+// Info is what Inspect found out about the names of a program.
+type Info struct {
+	Refs   []Ref    // every name the program binds or uses
+	Scopes []*Scope // a scope comes after the scopes around it
+}
+
+// Ref is an identifier of the program and the one binding the name it
+// refers to: itself where the name is bound, nil for builtins and names the
+// program does not bind.
+type Ref struct {
+	Ident *ast.Identifier
+	Def   *ast.Identifier
+}
+
+// Scope is a scope of the program. Node is the function literal, for
+// statement or catch block it is the scope of, nil for the program.
+type Scope struct {
+	Outer *Scope
+	Node  ast.Node
+	Names map[string]*ast.Identifier // the latest binding of each name
+}
+
+// Resolve annotates the identifiers of program and reports the names it
+// uses without binding them. env holds the globals defined before program
+// runs, such as those of earlier REPL inputs.
+//
+// A let binding is visible from where it appears on, so `let x = x + 1`
+// refers to any outer x on its right. Functions see every binding of the
+// scopes around them: they run later, when those may have been made.
+func Resolve(program *ast.Program, env *object.Environment) []*object.Error {
+	r := &resolver{env: env}
+	r.run(program)
+
+	return r.errors
+}
+
+// Inspect resolves program as Resolve does, with no globals bound before
+// it, and reports where its names are bound and used, for tools such as the
+// language server. Programs with syntax errors are inspected as far as they
+// were parsed.
+func Inspect(program *ast.Program) *Info {
+	r := &resolver{env: object.NewEnvironment(), info: &Info{}}
+	r.run(program)
+
+	return r.info
+}
+
+func (r *resolver) run(program *ast.Program) {
+	r.enterScope(false, nil)
+
+	r.declare(program.Statements)
+	r.statements(program.Statements)
+
+	r.leaveScope()
+
+	sort.SliceStable(r.errors, func(i, j int) bool {
+		return r.errors[i].Pos.Offset < r.errors[j].Pos.Offset
+	})
+}
+
+func (r *resolver) global() bool {
+	return r.scope.outer == nil
+}
+
+// declare binds the names the let statements among stmts define in the
+// current scope, before they are defined, so nested functions see them.
+func (r *resolver) declare(stmts []ast.Statement) {
+	for _, s := range stmts {
+		switch s := s.(type) {
+		case *ast.LetStatement:
+			r.bind(s.Name)
+		case *ast.ExportStatement:
+			r.bind(s.Statement.Name)
+		case *ast.ImportStatement:
+			r.bind(s.Name)
+		case *ast.ExpressionStatement:
+			for ie, ok := s.Expression.(*ast.IfExpression); ok && ie != nil; ie = ie.ElseIf {
+				r.declareBlock(ie.Consequence)
+				r.declareBlock(ie.Alternative)
+			}
+		case *ast.WhileStatement:
+			r.declareBlock(s.Block)
+		case *ast.TryStatement:
+			r.declareBlock(s.Block)
+			r.declareBlock(s.Finally)
+		}
+	}
+}
+
+func (r *resolver) declareBlock(block *ast.BlockStatement) {
+	if block != nil {
+		r.declare(block.Statements)
+	}
+}
+
+// bind adds the name of ident to the current scope, giving it the next
+// free slot. Binding a name again keeps its slot.
+func (r *resolver) bind(ident *ast.Identifier) *binding {
+	if b, ok := r.scope.names[ident.Value]; ok {
+		return b
+	}
+
+	b := &binding{slot: r.scope.size, def: ident}
+	r.scope.names[ident.Value] = b
+	r.scope.size++
+
+	return b
+}
+
+// define binds ident in the current scope and makes it visible.
+func (r *resolver) define(ident *ast.Identifier) {
+	if ident == nil {
+		return
+	}
+
+	b := r.bind(ident)
+	b.defined = true
+	b.def = ident
+
+	ident.Local = !r.global()
+	ident.Depth = 0
+	ident.Slot = b.slot
+
+	r.record(ident, ident)
+}
+
+// enterScope opens the scope of node, a function literal, for statement or
+// catch block, or of the program when node is nil.
+func (r *resolver) enterScope(function bool, node ast.Node) {
+	r.scope = &scope{outer: r.scope, function: function, names: map[string]*binding{}}
+
+	if r.info != nil {
+		r.scope.info = &Scope{Node: node, Names: map[string]*ast.Identifier{}}
+		if r.scope.outer != nil {
+			r.scope.info.Outer = r.scope.outer.info
+		}
+		r.info.Scopes = append(r.info.Scopes, r.scope.info)
+	}
+}
+
+func (r *resolver) leaveScope() int {
+	if r.info != nil {
+		for name, b := range r.scope.names {
+			r.scope.info.Names[name] = b.def
+		}
+	}
+
+	size := r.scope.size
+	r.scope = r.scope.outer
+	return size
+}
+
+// record notes that ident refers to the name def binds.
+func (r *resolver) record(ident, def *ast.Identifier) {
+	if r.info != nil {
+		r.info.Refs = append(r.info.Refs, Ref{Ident: ident, Def: def})
+	}
+}
+
+// lookup resolves a use of ident. It reports whether the name is bound at
+// all; a name only bound further on is reported as used before its
+// declaration here.
+func (r *resolver) lookup(ident *ast.Identifier) bool {
+	name := ident.Value
+	depth := 0
+	crossed := false  // whether the use is in a function nested in the scope
+	declared := false // whether the name is bound later in a scope passed
+
+	for s := r.scope; s != nil; s = s.outer {
+		if b, ok := s.names[name]; ok {
+			if b.defined || crossed {
+				ident.Local = s.outer != nil
+				ident.Depth = depth
+				ident.Slot = b.slot
+				r.record(ident, b.def)
+				return true
+			}
+			declared = true
+		}
+
+		crossed = crossed || s.function
+		depth++
+	}
+
+	ident.Local = false
+	r.record(ident, nil)
+
+	if _, ok := r.env.Get(name); ok {
+		return true
+	}
+	if _, ok := object.Builtins[name]; ok {
+		return true
+	}
+
+	if declared {
+		r.errorf(ident, "%s used before declaration", name)
+	}
+
+	return declared
+}
+
+func (r *resolver) errorf(node ast.Node, format string, a ...interface{}) {
+	r.errors = append(r.errors, &object.Error{
+		Kind:    object.NAME_ERROR,
+		Message: fmt.Sprintf(format, a...),
+		Pos:     node.Pos(),
+	})
+}
+
+func (r *resolver) statements(stmts []ast.Statement) {
+	for _, s := range stmts {
+		r.resolve(s)
+	}
+}
+
+func (r *resolver) block(block *ast.BlockStatement) {
+	if block != nil {
+		r.statements(block.Statements)
+	}
+}
+
+func (r *resolver) resolve(node ast.Node) {
+	switch node := node.(type) {
+	case *ast.LetStatement:
+		r.resolve(node.Value)
+		r.define(node.Name)
+
+	case *ast.ImportStatement:
+		r.define(node.Name)
+
+	case *ast.ExportStatement:
+		r.resolve(node.Statement)
+
+	case *ast.MemberExpression:
+		r.resolve(node.Object)
+
+	case *ast.AssignStatement:
+		r.resolve(node.Value)
+		if !r.lookup(node.Variable) {
+			r.errorf(node.Variable, "invalid assignment to non declared identifier %s", node.Variable.Value)
+		}
+
+	case *ast.Identifier:
+		if !r.lookup(node) {
+			r.errorf(node, "identifier not found: %s", node.Value)
+		}
+
+	case *ast.ReturnStatement:
+		r.resolve(node.ReturnValue)
+
+	case *ast.ExpressionStatement:
+		r.resolve(node.Expression)
+
+	case *ast.ThrowStatement:
+		r.resolve(node.Value)
+
+	case *ast.BlockStatement:
+		r.block(node)
+
+	case *ast.IfExpression:
+		r.resolve(node.Condition)
+		r.block(node.Consequence)
+		if node.ElseIf != nil {
+			r.resolve(node.ElseIf)
+		}
+		r.block(node.Alternative)
+
+	case *ast.WhileStatement:
+		r.resolve(node.Condition)
+		r.block(node.Block)
+
+	case *ast.ForStatement:
+		r.resolve(node.Iterator)
+
+		r.enterScope(false, node)
+		r.define(node.Index)
+		r.define(node.Value)
+		r.declareBlock(node.Block)
+		r.block(node.Block)
+		node.Slots = r.leaveScope()
+
+	case *ast.TryStatement:
+		r.block(node.Block)
+
+		if node.Catch != nil {
+			r.enterScope(false, node.Catch)
+			r.define(node.Param)
+			r.declareBlock(node.Catch)
+			r.block(node.Catch)
+			node.CatchSlots = r.leaveScope()
+		}
+
+		r.block(node.Finally)
+
+	case *ast.FunctionLiteral:
+		r.enterScope(true, node)
+		for _, p := range node.Parameters {
+			r.define(p)
+		}
+		r.declareBlock(node.Body)
+		r.block(node.Body)
+		node.Slots = r.leaveScope()
+
+	case *ast.PrefixExpression:
+		r.resolve(node.Right)
+
+	case *ast.InfixExpression:
+		r.resolve(node.Left)
+		r.resolve(node.Right)
+
+	case *ast.CallExpression:
+		r.resolve(node.Function)
+		for _, a := range node.Arguments {
+			r.resolve(a)
+		}
+
+	case *ast.ArrayLiteral:
+		for _, e := range node.Elements {
+			r.resolve(e)
+		}
+
+	case *ast.HashLiteral:
+		for _, k := range node.Keys {
+			r.resolve(k)
+			r.resolve(node.Pairs[k])
+		}
+
+	case *ast.IndexExpression:
+		r.resolve(node.Left)
+		r.resolve(node.Index)
+
+	case *ast.IndexAssignmentExpression:
+		if node.Index != nil {
+			r.resolve(node.Index)
+		}
+		r.resolve(node.Value)
+	}
+}
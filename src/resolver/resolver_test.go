@@ -0,0 +1,158 @@
+package resolver
+
+import (
+	"monkey/src/ast"
+	"monkey/src/lexer"
+	"monkey/src/object"
+	"monkey/src/parser"
+	"strings"
+	"testing"
+)
+
+func parse(t *testing.T, input string) *ast.Program {
+	t.Helper()
+
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+
+	return program
+}
+
+func TestSlots(t *testing.T) {
+	input := `let f = fn(a, b) {
+	let c = a;
+	for i, v in [c] {
+		let g = fn() { v + b + h };
+	}
+	let h = 1;
+};`
+
+	program := parse(t, input)
+	if errs := Resolve(program, object.NewEnvironment()); len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	f := program.Statements[0].(*ast.LetStatement)
+	if f.Name.Local {
+		t.Errorf("f should be a global")
+	}
+
+	fn := f.Value.(*ast.FunctionLiteral)
+	if fn.Slots != 4 {
+		t.Errorf("wrong number of slots for f. want=4, got=%d", fn.Slots)
+	}
+
+	loop := fn.Body.Statements[1].(*ast.ForStatement)
+	if loop.Slots != 3 {
+		t.Errorf("wrong number of slots for the loop. want=3, got=%d", loop.Slots)
+	}
+
+	g := loop.Block.Statements[0].(*ast.LetStatement).Value.(*ast.FunctionLiteral)
+	sum := g.Body.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.InfixExpression)
+	left := sum.Left.(*ast.InfixExpression)
+
+	tests := []struct {
+		ident *ast.Identifier
+		depth int
+		slot  int
+	}{
+		{fn.Parameters[1], 0, 1},
+		{fn.Body.Statements[0].(*ast.LetStatement).Name, 0, 2},
+		{left.Left.(*ast.Identifier), 1, 1},  // v
+		{left.Right.(*ast.Identifier), 2, 1}, // b
+		{sum.Right.(*ast.Identifier), 2, 3},  // h, bound after g
+	}
+
+	for _, tt := range tests {
+		if !tt.ident.Local || tt.ident.Depth != tt.depth || tt.ident.Slot != tt.slot {
+			t.Errorf("%s resolved wrongly. want=%d/%d, got=%t %d/%d",
+				tt.ident.Value, tt.depth, tt.slot, tt.ident.Local, tt.ident.Depth, tt.ident.Slot)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"foobar", []string{"1:1: identifier not found: foobar"}},
+		{"foobar = 1", []string{"1:1: invalid assignment to non declared identifier foobar"}},
+		{"x; let x = 1", []string{"1:1: x used before declaration"}},
+		{"let f = fn() { x + 1; let x = 2 }", []string{"1:16: x used before declaration"}},
+		{"let f = fn(a) { a + b }; let c = d", []string{
+			"1:21: identifier not found: b",
+			"1:34: identifier not found: d",
+		}},
+		{"for i, v in [1] { } i", []string{"1:21: identifier not found: i"}},
+		{"try { } catch (e) { } e", []string{"1:23: identifier not found: e"}},
+		{"let f = fn() { g() }; let g = fn() { 1 }", nil},
+		{"let x = 1; let f = fn() { let x = x + 1; x }", nil},
+		{"if (true) { let y = 1 } y", nil},
+		{"puts(len(\"abc\"))", nil},
+		{"defined + 1", nil},
+	}
+
+	for _, tt := range tests {
+		env := object.NewEnvironment()
+		env.Set("defined", &object.Integer{Value: 1})
+
+		errs := Resolve(parse(t, tt.input), env)
+
+		if len(errs) != len(tt.expected) {
+			t.Errorf("%q: wrong number of errors. want=%d, got=%v", tt.input, len(tt.expected), errs)
+			continue
+		}
+
+		for i, err := range errs {
+			if err.Kind != object.NAME_ERROR {
+				t.Errorf("%q: wrong error kind. got=%s", tt.input, err.Kind)
+			}
+			if got := err.Pos.String() + ": " + err.Message; got != tt.expected[i] {
+				t.Errorf("%q: wrong error. want=%q, got=%q", tt.input, tt.expected[i], got)
+			}
+		}
+	}
+}
+
+func TestInspect(t *testing.T) {
+	input := "let x = 1;\nlet f = fn(a) { let g = fn() { a + x + h }; let h = 2; puts(a) };\nlet x = f;"
+
+	info := Inspect(parse(t, input))
+
+	got := []string{}
+	for _, ref := range info.Refs {
+		def := "-"
+		if ref.Def != nil {
+			def = ref.Def.Pos().String()
+		}
+		got = append(got, ref.Ident.Value+"@"+ref.Ident.Pos().String()+">"+def)
+	}
+
+	want := []string{
+		"x@1:5>1:5",
+		"a@2:12>2:12",
+		"a@2:32>2:12", "x@2:36>1:5", "h@2:40>2:49",
+		"g@2:21>2:21",
+		"h@2:49>2:49",
+		"puts@2:56>-", "a@2:61>2:12",
+		"f@2:5>2:5",
+		"f@3:9>2:5", "x@3:5>3:5",
+	}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("wrong refs.\nwant=%v\ngot= %v", want, got)
+	}
+
+	if len(info.Scopes) != 3 || info.Scopes[0].Node != nil || info.Scopes[2].Outer != info.Scopes[1] {
+		t.Fatalf("wrong scopes. got=%+v", info.Scopes)
+	}
+	if x := info.Scopes[0].Names["x"]; x == nil || x.Pos().String() != "3:5" {
+		t.Errorf("x should name its last binding. got=%v", x)
+	}
+	if _, ok := info.Scopes[1].Node.(*ast.FunctionLiteral); !ok || len(info.Scopes[1].Names) != 3 {
+		t.Errorf("wrong function scope. got=%+v", info.Scopes[1])
+	}
+}
@@ -2,62 +2,343 @@ package server
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
 	"monkey/src/evaluator"
 	"monkey/src/lexer"
 	"monkey/src/object"
 	"monkey/src/parser"
+	"monkey/src/token"
+	"monkey/src/vm"
 	"net/http"
+	"runtime/debug"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
-
-	"flag"
-	"log"
+	"golang.org/x/net/websocket"
 )
 
 type ExecuteBody struct {
-	Code string `json:"code" binding:"required"`
+	Code   string `json:"code" binding:"required"`
+	Engine string `json:"engine"` // "eval" (the default) or "vm"
+}
+
+// ExecuteResponse is the body of every /execute response. Status tells
+// what happened: "ok", or why the program did not run to the end.
+type ExecuteResponse struct {
+	Status          string        `json:"status"`
+	Stdout          string        `json:"stdout"`
+	StdoutTruncated bool          `json:"stdout_truncated,omitempty"`
+	Result          *Value        `json:"result,omitempty"` // nil when the program has no value
+	Error           *ErrorDetail  `json:"error,omitempty"`  // the runtime error that stopped the program
+	Errors          []ErrorDetail `json:"errors,omitempty"` // parse errors, or what is wrong with the request
+}
+
+// The statuses of an ExecuteResponse.
+const (
+	StatusOK            = "ok"
+	StatusBadRequest    = "bad_request"
+	StatusParseError    = "parse_error"
+	StatusRuntimeError  = "runtime_error"
+	StatusLimitExceeded = "limit_exceeded"
+	StatusInternalError = "internal_error" // the interpreter failed, not the program
+
+	// for the session routes
+	StatusNotFound        = "not_found"
+	StatusTooManySessions = "too_many_sessions"
+)
+
+type Value struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// ErrorDetail describes an error. Line and Column are 0 when the error has
+// no position.
+type ErrorDetail struct {
+	Kind      string       `json:"kind,omitempty"`
+	Message   string       `json:"message"`
+	Position  string       `json:"position,omitempty"`
+	Line      int          `json:"line,omitempty"`
+	Column    int          `json:"column,omitempty"`
+	Traceback string       `json:"traceback,omitempty"`
+	Stack     []StackFrame `json:"stack,omitempty"`
+}
+
+type StackFrame struct {
+	Function string `json:"function"`
+	Position string `json:"position"`
+}
+
+// Config sets what a single /execute request may use, and how many
+// sessions may be kept and for how long.
+type Config struct {
+	Limits      evaluator.Limits
+	MaxBodySize int64 // bytes of the request body
+	MaxOutput   int   // bytes of stdout returned, the rest is dropped
+
+	MaxSessions int           // sessions alive at once, no limit when 0
+	SessionIdle time.Duration // a session unused for this long expires, never when 0
+
+	// Dir is served next to the API, for the web playground. Nothing is
+	// served when it is empty.
+	Dir string
+
+	// AccessLog gets a line per request when it is set.
+	AccessLog io.Writer
+}
+
+// DefaultConfig keeps a single /execute request from running away.
+var DefaultConfig = Config{
+	Limits: evaluator.Limits{
+		MaxSteps:     10_000_000,
+		MaxCallDepth: 1000,
+		MaxMemory:    64 << 20,
+		Timeout:      5 * time.Second,
+	},
+	MaxBodySize: 1 << 20,
+	MaxOutput:   1 << 20,
+	MaxSessions: 100,
+	SessionIdle: 30 * time.Minute,
 }
 
-func Start() {
-	r := gin.Default()
+// NewHandler returns the HTTP API: GET /ping, and POST /execute, which runs
+// the program in its body and reports its output, value and errors. The
+// same is streamed as the program runs by POST /execute/stream, as
+// server-sent events, and by GET /execute/ws over a WebSocket.
+//
+// POST /sessions creates a session, whose programs, run by POST
+// /sessions/:id/execute, share their environment. GET /sessions/:id lists
+// its bindings and DELETE /sessions/:id ends it.
+func NewHandler(cfg Config) http.Handler {
+	r := gin.New()
+	r.HandleMethodNotAllowed = true
+	if cfg.AccessLog != nil {
+		r.Use(gin.LoggerWithWriter(cfg.AccessLog))
+	}
+	r.Use(gin.Recovery())
+
 	r.GET("/ping", func(c *gin.Context) {
-		c.JSON(200, gin.H{
+		c.JSON(http.StatusOK, gin.H{
 			"message": "pong",
 		})
 	})
-	r.POST("/execute", func(ctx *gin.Context) {
-		var payload = ExecuteBody{}
-		ctx.ShouldBindJSON(&payload)
-
-		l := lexer.New(payload.Code)
-		p := parser.New(l)
-		program := p.ParseProgram()
-
-		if len(p.Errors()) > 0 {
-			ctx.JSON(200, gin.H{
-				"errors": p.Errors(),
-			})
-			return
+	r.POST("/execute", func(c *gin.Context) {
+		execute(c, cfg)
+	})
+	r.POST("/execute/stream", func(c *gin.Context) {
+		executeStream(c, cfg)
+	})
+	r.GET("/execute/ws", gin.WrapH(websocket.Server{Handler: func(ws *websocket.Conn) {
+		executeWebSocket(ws, cfg)
+	}}))
+
+	ss := newSessions(cfg)
+	r.POST("/sessions", func(c *gin.Context) {
+		createSession(c, cfg, ss)
+	})
+	r.GET("/sessions/:id", func(c *gin.Context) {
+		getSession(c, ss)
+	})
+	r.DELETE("/sessions/:id", func(c *gin.Context) {
+		deleteSession(c, ss)
+	})
+	r.POST("/sessions/:id/execute", func(c *gin.Context) {
+		executeInSession(c, cfg, ss)
+	})
+
+	if cfg.Dir != "" {
+		r.NoRoute(gin.WrapH(http.FileServer(http.Dir(cfg.Dir))))
+	}
+
+	return r
+}
+
+// Start serves the API on listen.
+func Start(listen string, cfg Config) error {
+	gin.SetMode(gin.ReleaseMode)
+	log.Printf("listening on %q...", listen)
+
+	return http.ListenAndServe(listen, NewHandler(cfg))
+}
+
+func execute(c *gin.Context, cfg Config) {
+	payload, ok := bindBody(c, cfg)
+	if !ok {
+		return
+	}
+
+	stdout := &limitedBuffer{max: cfg.MaxOutput}
+	code, response := run(c.Request.Context(), payload, cfg, stdout)
+
+	response.Stdout = stdout.String()
+	response.StdoutTruncated = stdout.truncated
+
+	c.JSON(code, response)
+}
+
+// bindBody reads the ExecuteBody of a request. When it is missing, too
+// large or invalid, the response is sent and it reports false.
+func bindBody(c *gin.Context, cfg Config) (ExecuteBody, bool) {
+	if cfg.MaxBodySize > 0 {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, cfg.MaxBodySize)
+	}
+
+	var payload ExecuteBody
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			badRequest(c, http.StatusRequestEntityTooLarge, "request body larger than "+strconv.FormatInt(tooLarge.Limit, 10)+" bytes")
+			return payload, false
 		}
+		badRequest(c, http.StatusBadRequest, "invalid request: "+err.Error())
+		return payload, false
+	}
 
-		evaluated := evaluator.Eval(program, object.NewEnvironment(), &bytes.Buffer{})
+	if msg := payload.check(); msg != "" {
+		badRequest(c, http.StatusBadRequest, msg)
+		return payload, false
+	}
 
-		ctx.JSON(200, gin.H{
-			"message": "success",
-			"output":  evaluated.Inspect(),
-		})
-	})
-	http.ListenAndServe(":8080", r)
+	return payload, true
 }
 
-var (
-	listen = flag.String("listen", ":8080", "listen address")
-	dir    = flag.String("dir", ".", "directory to serve")
-)
+// check returns what is wrong with the body, or "".
+func (b ExecuteBody) check() string {
+	if b.Code == "" {
+		return "invalid request: missing code"
+	}
+	return checkEngine(b.Engine)
+}
+
+// checkEngine returns what is wrong with the engine asked for, or "".
+func checkEngine(engine string) string {
+	if engine != "" && engine != "eval" && engine != "vm" {
+		return "unknown engine " + engine
+	}
+	return ""
+}
+
+func badRequest(c *gin.Context, code int, msg string) {
+	c.JSON(code, badRequestResponse(msg))
+}
+
+func badRequestResponse(msg string) ExecuteResponse {
+	return ExecuteResponse{Status: StatusBadRequest, Errors: []ErrorDetail{{Message: msg}}}
+}
+
+// run parses and runs the program of payload until it ends or ctx is done,
+// writing its output to stdout. It returns the response to send, without
+// the output, and its HTTP status code.
+func run(ctx context.Context, payload ExecuteBody, cfg Config, stdout io.Writer) (int, ExecuteResponse) {
+	interp := &evaluator.Interpreter{Stdout: stdout, Limits: cfg.Limits, DisableImports: true}
+	return runIn(ctx, payload, interp, interp.Globals())
+}
+
+// runIn is run with the program running in env, with the output and
+// limits of interp.
+func runIn(ctx context.Context, payload ExecuteBody, interp *evaluator.Interpreter, env *object.Environment) (code int, response ExecuteResponse) {
+	// a bug in the interpreter ends the run, not the server, and the client
+	// still gets a response it can read
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("panic running a program: %v\n%s", r, debug.Stack())
+
+			code = http.StatusInternalServerError
+			response = ExecuteResponse{Status: StatusInternalError, Error: &ErrorDetail{Message: fmt.Sprint("internal error: ", r)}}
+		}
+	}()
+
+	p := parser.New(lexer.New(payload.Code))
+	program := p.ParseProgram()
+
+	if len(p.Errors()) > 0 {
+		details := []ErrorDetail{}
+		for _, err := range p.ErrorDetails() {
+			details = append(details, parseErrorDetail(err))
+		}
+		return http.StatusUnprocessableEntity, ExecuteResponse{Status: StatusParseError, Errors: details}
+	}
+
+	var evaluated object.Object
+	if payload.Engine == "vm" {
+		evaluated = vm.Run(ctx, program, env, interp)
+	} else {
+		evaluated = interp.EvalContext(ctx, program, env)
+	}
+
+	response = ExecuteResponse{Status: StatusOK}
+
+	if err, ok := evaluated.(*object.Error); ok {
+		response.Status = StatusRuntimeError
+		if evaluator.IsLimitError(err) {
+			response.Status = StatusLimitExceeded
+		}
+		response.Error = errorDetail(err)
+
+		return http.StatusUnprocessableEntity, response
+	}
+
+	if evaluated != nil {
+		response.Result = &Value{Type: string(evaluated.Type()), Value: evaluated.Inspect()}
+	}
+
+	return http.StatusOK, response
+}
+
+// parseErrorDetail describes a syntax error.
+func parseErrorDetail(err parser.Error) ErrorDetail {
+	detail := ErrorDetail{Message: err.Message}
+	setPosition(&detail, err.Pos)
+
+	return detail
+}
+
+func errorDetail(err *object.Error) *ErrorDetail {
+	detail := &ErrorDetail{
+		Kind:      string(err.Kind),
+		Message:   err.Message,
+		Traceback: err.Traceback(),
+	}
+
+	setPosition(detail, err.Pos)
+
+	for _, frame := range err.Stack {
+		detail.Stack = append(detail.Stack, StackFrame{Function: frame.Function, Position: frame.Pos.String()})
+	}
+
+	return detail
+}
+
+// setPosition fills in where the error of detail happened, when it is
+// known.
+func setPosition(detail *ErrorDetail, pos token.Position) {
+	if pos.IsValid() {
+		detail.Position = pos.String()
+		detail.Line = pos.Line
+		detail.Column = pos.Column
+	}
+}
+
+// limitedBuffer keeps the first max bytes written to it, all of them when
+// max is 0. Writes never fail, so programs run the same whatever is kept.
+type limitedBuffer struct {
+	bytes.Buffer
+	max       int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if b.max > 0 && b.Len()+len(p) > b.max {
+		p = p[:b.max-b.Len()]
+		b.truncated = true
+	}
+	b.Buffer.Write(p)
 
-func RunServer() {
-	flag.Parse()
-	log.Printf("listening on %q...", *listen)
-	err := http.ListenAndServe(*listen, http.FileServer(http.Dir(*dir)))
-	log.Fatalln(err)
+	return n, nil
 }
@@ -0,0 +1,163 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+func post(t *testing.T, handler http.Handler, body string) (int, ExecuteResponse) {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodPost, "/execute", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	var response ExecuteResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("%s: invalid response %q: %s", body, rec.Body.String(), err)
+	}
+
+	return rec.Code, response
+}
+
+func TestExecute(t *testing.T) {
+	cfg := DefaultConfig
+	cfg.Limits.MaxSteps = 10000
+	cfg.MaxBodySize = 200
+	cfg.MaxOutput = 8
+	handler := NewHandler(cfg)
+
+	tests := []struct {
+		body   string
+		code   int
+		status string
+		stdout string
+		result *Value
+	}{
+		{`{"code": "puts(1); 1 + 2"}`, http.StatusOK, StatusOK, "1\n", &Value{"INTEGER", "3"}},
+		{`{"code": "puts(\"hi\"); [1, \"a\"]", "engine": "vm"}`, http.StatusOK, StatusOK, "hi\n", &Value{"ARRAY", "[1, a]"}},
+		{`{"code": "let x = 1"}`, http.StatusOK, StatusOK, "", nil},
+		{`{"code": "puts(\"0123456789\")"}`, http.StatusOK, StatusOK, "01234567", &Value{"NULL", "null"}},
+		{`{"code": "let = 1"}`, http.StatusUnprocessableEntity, StatusParseError, "", nil},
+		{`{"code": "puts(1); 1 / 0"}`, http.StatusUnprocessableEntity, StatusRuntimeError, "1\n", nil},
+		{`{"code": "while true { }"}`, http.StatusUnprocessableEntity, StatusLimitExceeded, "", nil},
+		{`{"code": "range(0, 2305843009213693953)"}`, http.StatusUnprocessableEntity, StatusLimitExceeded, "", nil},
+		{`{"code": "1", "engine": "jit"}`, http.StatusBadRequest, StatusBadRequest, "", nil},
+		{`{"engine": "vm"}`, http.StatusBadRequest, StatusBadRequest, "", nil},
+		{`{"code": `, http.StatusBadRequest, StatusBadRequest, "", nil},
+		{`{"code": "` + strings.Repeat("1;", 100) + `"}`, http.StatusRequestEntityTooLarge, StatusBadRequest, "", nil},
+	}
+
+	for _, tt := range tests {
+		code, response := post(t, handler, tt.body)
+
+		if code != tt.code || response.Status != tt.status {
+			t.Errorf("%s: want %d %s, got %d %s (%+v)", tt.body, tt.code, tt.status, code, response.Status, response)
+		}
+
+		if response.Stdout != tt.stdout {
+			t.Errorf("%s: wrong stdout. want=%q, got=%q", tt.body, tt.stdout, response.Stdout)
+		}
+
+		if (tt.result == nil) != (response.Result == nil) || tt.result != nil && *tt.result != *response.Result {
+			t.Errorf("%s: wrong result. want=%+v, got=%+v", tt.body, tt.result, response.Result)
+		}
+	}
+}
+
+func TestExecuteErrors(t *testing.T) {
+	handler := NewHandler(DefaultConfig)
+
+	_, response := post(t, handler, `{"code": "let f = fn(x) {\n  x - \"a\"\n};\nf(1)"}`)
+	err := response.Error
+	if err == nil || err.Kind != "TypeError" || err.Message != "type mismatch: INTEGER - STRING" ||
+		err.Position != "2:3" || err.Line != 2 || err.Column != 3 {
+		t.Fatalf("wrong error. got=%+v", err)
+	}
+	if len(err.Stack) != 1 || err.Stack[0].Function != "f" || err.Stack[0].Position != "4:1" {
+		t.Errorf("wrong stack. got=%+v", err.Stack)
+	}
+
+	_, response = post(t, handler, `{"code": "let = 1"}`)
+	if len(response.Errors) == 0 || response.Errors[0].Message != "expected next token to be IDENT, got = instead" ||
+		response.Errors[0].Position != "1:5" || response.Errors[0].Line != 1 || response.Errors[0].Column != 5 {
+		t.Errorf("wrong parse errors. got=%+v", response.Errors)
+	}
+
+	_, response = post(t, handler, `{"code": 1}`)
+	if len(response.Errors) != 1 || !strings.HasPrefix(response.Errors[0].Message, "invalid request: ") {
+		t.Errorf("wrong request errors. got=%+v", response.Errors)
+	}
+}
+
+func TestExecuteImports(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "secret.mky")
+	if err := os.WriteFile(file, []byte(`export let secret = "hunter";`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	handler := NewHandler(DefaultConfig)
+
+	check := func(what string, code int, response ExecuteResponse) {
+		t.Helper()
+		err := response.Error
+		if code != http.StatusUnprocessableEntity || err == nil || err.Kind != "ImportError" || err.Message != "imports are disabled" {
+			t.Errorf("%s: expected the import to be rejected. got %d %+v", what, code, response)
+		}
+	}
+
+	for _, engine := range []string{"eval", "vm"} {
+		for _, path := range []string{file, "/etc/hostname", "../secret.mky"} {
+			body, _ := json.Marshal(ExecuteBody{Code: `import "` + path + `" as s; s.secret`, Engine: engine})
+
+			code, response := post(t, handler, string(body))
+			check(engine+" "+path, code, response)
+
+			session := newSession(t, handler, `{"engine": "`+engine+`"}`)
+			code, response = executeIn(t, handler, session.ID, string(body))
+			check("session "+engine+" "+path, code, response)
+		}
+	}
+}
+
+// panicWriter fails the way a bug in the interpreter would.
+type panicWriter struct{}
+
+func (panicWriter) Write(p []byte) (int, error) { panic("write failed") }
+
+func TestExecutePanic(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	for _, engine := range []string{"eval", "vm"} {
+		code, response := run(context.Background(), ExecuteBody{Code: `puts("boom")`, Engine: engine}, DefaultConfig, panicWriter{})
+
+		if code != http.StatusInternalServerError || response.Status != StatusInternalError ||
+			response.Error == nil || response.Error.Message != "internal error: write failed" {
+			t.Errorf("%s: expected an internal error. got %d %+v", engine, code, response)
+		}
+	}
+}
+
+func TestExecuteMethod(t *testing.T) {
+	rec := httptest.NewRecorder()
+	NewHandler(DefaultConfig).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/execute", nil))
+
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected %d. got=%d", http.StatusMethodNotAllowed, rec.Code)
+	}
+}
@@ -0,0 +1,281 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"monkey/src/evaluator"
+	"monkey/src/object"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SessionBody is the body of POST /sessions. It may be left out.
+type SessionBody struct {
+	Engine string `json:"engine"` // "eval" (the default) or "vm"
+}
+
+// SessionResponse describes a session: what it was created with and, for
+// GET /sessions/:id, the bindings its programs have made.
+type SessionResponse struct {
+	ID       string    `json:"id"`
+	Engine   string    `json:"engine"`
+	Created  time.Time `json:"created"`
+	LastUsed time.Time `json:"last_used"`
+	Bindings []Binding `json:"bindings,omitempty"`
+}
+
+type Binding struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// session is an environment kept between requests, so that a program can
+// use what the programs run before it defined.
+type session struct {
+	// mu is held while a program runs in the session or it is inspected,
+	// as environments are not safe for concurrent use.
+	mu     sync.Mutex
+	interp *evaluator.Interpreter
+	env    *object.Environment
+
+	id      string
+	engine  string
+	created time.Time
+
+	// guarded by the mutex of the store
+	lastUsed time.Time
+	users    int // requests holding or waiting for mu
+}
+
+// sessions holds the live sessions. A session expires when it has not
+// been used for idle; expired sessions are dropped whenever the store is
+// used, but never while a request uses them.
+type sessions struct {
+	mu   sync.Mutex
+	byID map[string]*session
+	max  int
+	idle time.Duration
+	now  func() time.Time
+}
+
+var (
+	errTooManySessions = errors.New("too many sessions")
+	errNoSession       = errors.New("no such session")
+)
+
+func newSessions(cfg Config) *sessions {
+	return &sessions{
+		byID: map[string]*session{},
+		max:  cfg.MaxSessions,
+		idle: cfg.SessionIdle,
+		now:  time.Now,
+	}
+}
+
+// create starts a session running its programs with engine.
+func (ss *sessions) create(engine string) (*session, error) {
+	id, err := newSessionID()
+	if err != nil {
+		return nil, err
+	}
+
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	ss.expire()
+	if ss.max > 0 && len(ss.byID) >= ss.max {
+		return nil, errTooManySessions
+	}
+
+	interp := &evaluator.Interpreter{DisableImports: true}
+	s := &session{
+		interp:   interp,
+		env:      interp.Globals(),
+		id:       id,
+		engine:   engine,
+		created:  ss.now(),
+		lastUsed: ss.now(),
+	}
+	ss.byID[id] = s
+
+	return s, nil
+}
+
+// acquire returns the session id and locks it. It must be given back with
+// release.
+func (ss *sessions) acquire(id string) (*session, error) {
+	ss.mu.Lock()
+	ss.expire()
+	s, ok := ss.byID[id]
+	if ok {
+		s.users++
+		s.lastUsed = ss.now()
+	}
+	ss.mu.Unlock()
+
+	if !ok {
+		return nil, errNoSession
+	}
+
+	s.mu.Lock()
+	return s, nil
+}
+
+func (ss *sessions) release(s *session) {
+	s.mu.Unlock()
+
+	ss.mu.Lock()
+	s.users--
+	s.lastUsed = ss.now()
+	ss.mu.Unlock()
+}
+
+// remove drops the session id. Requests already using it finish.
+func (ss *sessions) remove(id string) error {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	ss.expire()
+	if _, ok := ss.byID[id]; !ok {
+		return errNoSession
+	}
+	delete(ss.byID, id)
+
+	return nil
+}
+
+// expire drops the sessions idle for too long. ss.mu must be held.
+func (ss *sessions) expire() {
+	if ss.idle <= 0 {
+		return
+	}
+
+	for id, s := range ss.byID {
+		if s.users == 0 && ss.now().Sub(s.lastUsed) > ss.idle {
+			delete(ss.byID, id)
+		}
+	}
+}
+
+func newSessionID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// describe returns the session as sent to clients. s.mu must be held when
+// bindings are wanted.
+func (s *session) describe(lastUsed time.Time, bindings bool) SessionResponse {
+	response := SessionResponse{ID: s.id, Engine: s.engine, Created: s.created, LastUsed: lastUsed}
+	if !bindings {
+		return response
+	}
+
+	response.Bindings = []Binding{}
+	for _, name := range s.env.Names() {
+		value, _ := s.env.Get(name)
+		if value == nil {
+			continue
+		}
+		response.Bindings = append(response.Bindings, Binding{Name: name, Type: string(value.Type()), Value: value.Inspect()})
+	}
+
+	return response
+}
+
+func createSession(c *gin.Context, cfg Config, ss *sessions) {
+	if cfg.MaxBodySize > 0 {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, cfg.MaxBodySize)
+	}
+
+	var payload SessionBody
+	if err := c.ShouldBindJSON(&payload); err != nil && !errors.Is(err, io.EOF) {
+		badRequest(c, http.StatusBadRequest, "invalid request: "+err.Error())
+		return
+	}
+	if msg := checkEngine(payload.Engine); msg != "" {
+		badRequest(c, http.StatusBadRequest, msg)
+		return
+	}
+	if payload.Engine == "" {
+		payload.Engine = "eval"
+	}
+
+	s, err := ss.create(payload.Engine)
+	if errors.Is(err, errTooManySessions) {
+		c.JSON(http.StatusTooManyRequests, ExecuteResponse{Status: StatusTooManySessions, Errors: []ErrorDetail{{Message: err.Error()}}})
+		return
+	}
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, s.describe(s.created, false))
+}
+
+// executeInSession runs the program of the request body in the session, in
+// the environment left by the programs run in it before. It answers like
+// /execute.
+func executeInSession(c *gin.Context, cfg Config, ss *sessions) {
+	payload, ok := bindBody(c, cfg)
+	if !ok {
+		return
+	}
+
+	s, err := ss.acquire(c.Param("id"))
+	if err != nil {
+		notFound(c, err)
+		return
+	}
+	defer ss.release(s)
+
+	if payload.Engine != "" && payload.Engine != s.engine {
+		badRequest(c, http.StatusBadRequest, "the session runs programs with engine "+s.engine)
+		return
+	}
+	payload.Engine = s.engine
+
+	stdout := &limitedBuffer{max: cfg.MaxOutput}
+	s.interp.Stdout = stdout
+	s.interp.Limits = cfg.Limits
+
+	code, response := runIn(c.Request.Context(), payload, s.interp, s.env)
+
+	response.Stdout = stdout.String()
+	response.StdoutTruncated = stdout.truncated
+
+	c.JSON(code, response)
+}
+
+func getSession(c *gin.Context, ss *sessions) {
+	s, err := ss.acquire(c.Param("id"))
+	if err != nil {
+		notFound(c, err)
+		return
+	}
+	defer ss.release(s)
+
+	c.JSON(http.StatusOK, s.describe(ss.now(), true))
+}
+
+func deleteSession(c *gin.Context, ss *sessions) {
+	if err := ss.remove(c.Param("id")); err != nil {
+		notFound(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func notFound(c *gin.Context, err error) {
+	c.JSON(http.StatusNotFound, ExecuteResponse{Status: StatusNotFound, Errors: []ErrorDetail{{Message: err.Error()}}})
+}
@@ -0,0 +1,241 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func request(handler http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	return rec
+}
+
+func newSession(t *testing.T, handler http.Handler, body string) SessionResponse {
+	t.Helper()
+
+	rec := request(handler, http.MethodPost, "/sessions", body)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("could not create a session: %d %s", rec.Code, rec.Body.String())
+	}
+
+	var session SessionResponse
+	json.Unmarshal(rec.Body.Bytes(), &session)
+
+	return session
+}
+
+func executeIn(t *testing.T, handler http.Handler, id, body string) (int, ExecuteResponse) {
+	t.Helper()
+
+	rec := request(handler, http.MethodPost, "/sessions/"+id+"/execute", body)
+
+	var response ExecuteResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("%s: invalid response %q: %s", body, rec.Body.String(), err)
+	}
+
+	return rec.Code, response
+}
+
+func TestSessions(t *testing.T) {
+	handler := NewHandler(DefaultConfig)
+
+	for _, engine := range []string{"eval", "vm"} {
+		session := newSession(t, handler, `{"engine": "`+engine+`"}`)
+		if session.ID == "" || session.Engine != engine {
+			t.Fatalf("wrong session. got=%+v", session)
+		}
+
+		tests := []struct {
+			body   string
+			status string
+			stdout string
+			result string
+		}{
+			{`{"code": "let add = fn(a, b) { a + b }; let n = 1"}`, StatusOK, "", ""},
+			{`{"code": "puts(add(n, 2)); n = add(n, 10); n"}`, StatusOK, "3\n", "11"},
+			{`{"code": "let = 1"}`, StatusParseError, "", ""},
+			{`{"code": "n / 0"}`, StatusRuntimeError, "", ""},
+			{`{"code": "n"}`, StatusOK, "", "11"},
+		}
+
+		for _, tt := range tests {
+			_, response := executeIn(t, handler, session.ID, tt.body)
+			if response.Status != tt.status || response.Stdout != tt.stdout {
+				t.Errorf("%s %s: want %s %q, got=%+v", engine, tt.body, tt.status, tt.stdout, response)
+			}
+			if tt.result != "" && (response.Result == nil || response.Result.Value != tt.result) {
+				t.Errorf("%s %s: wrong result. want=%s, got=%+v", engine, tt.body, tt.result, response.Result)
+			}
+		}
+
+		rec := request(handler, http.MethodGet, "/sessions/"+session.ID, "")
+		var got SessionResponse
+		json.Unmarshal(rec.Body.Bytes(), &got)
+
+		bindings := []string{}
+		for _, b := range got.Bindings {
+			bindings = append(bindings, b.Name+" "+b.Type)
+		}
+		want := []string{"add FUNCTION", "args ARRAY", "n INTEGER"}
+		if rec.Code != http.StatusOK || strings.Join(bindings, "|") != strings.Join(want, "|") {
+			t.Errorf("%s: wrong bindings. want=%q, got=%d %q", engine, want, rec.Code, bindings)
+		} else if got.Bindings[2].Value != "11" {
+			t.Errorf("%s: wrong value of n. got=%q", engine, got.Bindings[2].Value)
+		}
+
+		if rec := request(handler, http.MethodDelete, "/sessions/"+session.ID, ""); rec.Code != http.StatusNoContent {
+			t.Errorf("%s: could not delete the session: %d", engine, rec.Code)
+		}
+		if code, response := executeIn(t, handler, session.ID, `{"code": "n"}`); code != http.StatusNotFound || response.Status != StatusNotFound {
+			t.Errorf("%s: the deleted session still runs programs: %d %+v", engine, code, response)
+		}
+	}
+}
+
+func TestSessionErrors(t *testing.T) {
+	cfg := DefaultConfig
+	cfg.MaxSessions = 1
+	handler := NewHandler(cfg)
+
+	session := newSession(t, handler, "")
+	if session.Engine != "eval" {
+		t.Errorf("expected the eval engine by default. got=%q", session.Engine)
+	}
+
+	tests := []struct {
+		method string
+		path   string
+		body   string
+		code   int
+	}{
+		{http.MethodPost, "/sessions", "", http.StatusTooManyRequests},
+		{http.MethodPost, "/sessions", `{"engine": "jit"}`, http.StatusBadRequest},
+		{http.MethodPost, "/sessions", `{"engine": `, http.StatusBadRequest},
+		{http.MethodPost, "/sessions/" + session.ID + "/execute", `{"code": "1", "engine": "vm"}`, http.StatusBadRequest},
+		{http.MethodPost, "/sessions/" + session.ID + "/execute", `{"engine": "eval"}`, http.StatusBadRequest},
+		{http.MethodPost, "/sessions/nope/execute", `{"code": "1"}`, http.StatusNotFound},
+		{http.MethodGet, "/sessions/nope", "", http.StatusNotFound},
+		{http.MethodDelete, "/sessions/nope", "", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		rec := request(handler, tt.method, tt.path, tt.body)
+		if rec.Code != tt.code {
+			t.Errorf("%s %s %s: expected %d. got=%d %s", tt.method, tt.path, tt.body, tt.code, rec.Code, rec.Body.String())
+		}
+	}
+
+	request(handler, http.MethodDelete, "/sessions/"+session.ID, "")
+	newSession(t, handler, "")
+}
+
+// TestSessionMemory checks the memory quota covers everything a session
+// keeps, not only what one request allocates.
+func TestSessionMemory(t *testing.T) {
+	cfg := DefaultConfig
+	cfg.Limits.MaxMemory = 1 << 20
+	handler := NewHandler(cfg)
+
+	for _, engine := range []string{"eval", "vm"} {
+		session := newSession(t, handler, `{"engine": "`+engine+`"}`)
+
+		if _, response := executeIn(t, handler, session.ID, `{"code": "let a = range(0, 30000); 1"}`); response.Status != StatusOK {
+			t.Fatalf("%s: unexpected response %+v", engine, response)
+		}
+		if _, response := executeIn(t, handler, session.ID, `{"code": "let b = range(0, 30000); 1"}`); response.Status != StatusLimitExceeded {
+			t.Errorf("%s: expected the session to run out of memory. got=%+v", engine, response)
+		}
+
+		// what the session no longer holds is not counted
+		for i := 0; i < 3; i++ {
+			for _, code := range []string{`a = 0`, `a = range(0, 30000); 1`} {
+				if _, response := executeIn(t, handler, session.ID, `{"code": "`+code+`"}`); response.Status != StatusOK {
+					t.Fatalf("%s: %s: expected the memory of a to be given back. got=%+v", engine, code, response)
+				}
+			}
+		}
+
+		// what functions close over is
+		other := newSession(t, handler, `{"engine": "`+engine+`"}`)
+		if _, response := executeIn(t, handler, other.ID, `{"code": "let f = fn() { let big = range(0, 30000); fn() { big } }(); 1"}`); response.Status != StatusOK {
+			t.Fatalf("%s: unexpected response %+v", engine, response)
+		}
+		if _, response := executeIn(t, handler, other.ID, `{"code": "let b = range(0, 30000); 1"}`); response.Status != StatusLimitExceeded {
+			t.Errorf("%s: expected the closure to hold its memory. got=%+v", engine, response)
+		}
+
+		for i := 0; i < 2; i++ {
+			if _, response := post(t, handler, `{"code": "let a = range(0, 30000); 1", "engine": "`+engine+`"}`); response.Status != StatusOK {
+				t.Errorf("%s: expected /execute to get a fresh quota. got=%+v", engine, response)
+			}
+		}
+	}
+}
+
+func TestSessionExpiry(t *testing.T) {
+	ss := newSessions(Config{SessionIdle: time.Minute})
+	now := time.Now()
+	ss.now = func() time.Time { return now }
+
+	idle, _ := ss.create("eval")
+	used, _ := ss.create("eval")
+	busy, _ := ss.create("eval")
+
+	now = now.Add(50 * time.Second)
+	s, _ := ss.acquire(used.id)
+	ss.release(s)
+	ss.acquire(busy.id)
+
+	now = now.Add(20 * time.Second)
+	if _, err := ss.acquire(idle.id); err != errNoSession {
+		t.Errorf("expected the idle session to expire. got=%v", err)
+	}
+	if s, err := ss.acquire(used.id); err != nil {
+		t.Errorf("expected the session used to be kept. got=%v", err)
+	} else {
+		ss.release(s)
+	}
+
+	now = now.Add(time.Hour)
+	ss.mu.Lock()
+	ss.expire()
+	_, ok := ss.byID[busy.id]
+	ss.mu.Unlock()
+	if !ok {
+		t.Errorf("expected the session in use to be kept")
+	}
+}
+
+func TestSessionConcurrency(t *testing.T) {
+	handler := NewHandler(DefaultConfig)
+	session := newSession(t, handler, "")
+
+	executeIn(t, handler, session.ID, `{"code": "let n = 0"}`)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			request(handler, http.MethodPost, "/sessions/"+session.ID+"/execute", fmt.Sprintf(`{"code": "n = n + 1; let x%c = n"}`, 'a'+i))
+			request(handler, http.MethodGet, "/sessions/"+session.ID, "")
+		}(i)
+	}
+	wg.Wait()
+
+	_, response := executeIn(t, handler, session.ID, `{"code": "n"}`)
+	if response.Result == nil || response.Result.Value != "20" {
+		t.Errorf("expected every program to run once. got=%+v", response.Result)
+	}
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"context"
+	"io"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/websocket"
+)
+
+// Output is a chunk of what a program printed, sent as it is printed.
+type Output struct {
+	Text string `json:"text"`
+}
+
+// StreamMessage is a message sent over the WebSocket: Type "stdout" with
+// the Text printed, or "done" with the fields of the ExecuteResponse.
+type StreamMessage struct {
+	Type string `json:"type"`
+	Text string `json:"text,omitempty"`
+	*ExecuteResponse
+}
+
+// executeStream runs the program of the request body, sending what it
+// prints as "stdout" events and then the response, without the output, as
+// a "done" event. The program is cancelled when the client goes away.
+func executeStream(c *gin.Context, cfg Config) {
+	payload, ok := bindBody(c, cfg)
+	if !ok {
+		return
+	}
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+
+	stdout := &streamWriter{max: cfg.MaxOutput, send: func(text string) error {
+		c.SSEvent("stdout", Output{Text: text})
+		c.Writer.Flush()
+		return nil
+	}}
+
+	_, response := run(c.Request.Context(), payload, cfg, stdout)
+	response.StdoutTruncated = stdout.truncated
+
+	c.SSEvent("done", response)
+	c.Writer.Flush()
+}
+
+// executeWebSocket runs the program of the first message received, an
+// ExecuteBody, and answers with StreamMessages. Closing the socket
+// cancels the program.
+func executeWebSocket(ws *websocket.Conn, cfg Config) {
+	defer ws.Close()
+
+	if cfg.MaxBodySize > 0 {
+		ws.MaxPayloadBytes = int(cfg.MaxBodySize)
+	}
+
+	var payload ExecuteBody
+	if err := websocket.JSON.Receive(ws, &payload); err != nil {
+		response := badRequestResponse("invalid request: " + err.Error())
+		websocket.JSON.Send(ws, StreamMessage{Type: "done", ExecuteResponse: &response})
+		return
+	}
+	if msg := payload.check(); msg != "" {
+		response := badRequestResponse(msg)
+		websocket.JSON.Send(ws, StreamMessage{Type: "done", ExecuteResponse: &response})
+		return
+	}
+
+	ctx, cancel := context.WithCancel(ws.Request().Context())
+	defer cancel()
+
+	// nothing more is expected from the client, so reading only ends when
+	// it closes the socket
+	go func() {
+		io.Copy(io.Discard, ws)
+		cancel()
+	}()
+
+	stdout := &streamWriter{max: cfg.MaxOutput, send: func(text string) error {
+		return websocket.JSON.Send(ws, StreamMessage{Type: "stdout", Text: text})
+	}}
+	stdout.cancel = cancel
+
+	_, response := run(ctx, payload, cfg, stdout)
+	response.StdoutTruncated = stdout.truncated
+
+	websocket.JSON.Send(ws, StreamMessage{Type: "done", ExecuteResponse: &response})
+}
+
+// streamWriter sends every write to a client as it is made, up to max
+// bytes in all, or without limit when max is 0. Like limitedBuffer, its
+// writes never fail; when sending does, cancel is called if set.
+type streamWriter struct {
+	send      func(text string) error
+	cancel    func()
+	max       int
+	written   int
+	truncated bool
+}
+
+func (w *streamWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	if w.max > 0 && w.written+len(p) > w.max {
+		p = p[:w.max-w.written]
+		w.truncated = true
+	}
+	if len(p) == 0 {
+		return n, nil
+	}
+
+	w.written += len(p)
+	if err := w.send(string(p)); err != nil && w.cancel != nil {
+		w.cancel()
+	}
+
+	return n, nil
+}
@@ -0,0 +1,197 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+type event struct {
+	name string
+	data string
+}
+
+func readEvents(t *testing.T, body string) []event {
+	t.Helper()
+
+	events := []event{}
+	for _, block := range strings.Split(strings.TrimSpace(body), "\n\n") {
+		e := event{}
+		for _, line := range strings.Split(block, "\n") {
+			field, value, _ := strings.Cut(line, ":")
+			switch field {
+			case "event":
+				e.name = value
+			case "data":
+				e.data = value
+			}
+		}
+		events = append(events, e)
+	}
+
+	return events
+}
+
+func TestExecuteStream(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/execute/stream", strings.NewReader(`{"code": "puts(1); puts(\"two\"); 3"}`))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	NewHandler(DefaultConfig).ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("expected an event stream. got=%d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+
+	events := readEvents(t, rec.Body.String())
+	if len(events) != 3 {
+		t.Fatalf("expected 3 events. got=%+v", events)
+	}
+
+	for i, text := range []string{"1\n", "two\n"} {
+		var output Output
+		json.Unmarshal([]byte(events[i].data), &output)
+		if events[i].name != "stdout" || output.Text != text {
+			t.Errorf("wrong event %d. want stdout %q, got=%+v", i, text, events[i])
+		}
+	}
+
+	var response ExecuteResponse
+	json.Unmarshal([]byte(events[2].data), &response)
+	if events[2].name != "done" || response.Status != StatusOK || response.Result == nil || response.Result.Value != "3" {
+		t.Errorf("wrong done event. got=%+v", events[2])
+	}
+}
+
+func TestExecuteStreamBadRequest(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/execute/stream", strings.NewReader(`{"code": "1", "engine": "jit"}`))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	NewHandler(DefaultConfig).ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected %d. got=%d", http.StatusBadRequest, rec.Code)
+	}
+}
+
+// serve starts a server with cfg and returns its URL and a channel closed
+// when a request has been handled.
+func serve(t *testing.T, cfg Config) (string, chan struct{}) {
+	handled := make(chan struct{}, 1)
+	handler := NewHandler(cfg)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.ServeHTTP(w, r)
+		handled <- struct{}{}
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv.URL, handled
+}
+
+func waitHandled(t *testing.T, handled chan struct{}) {
+	t.Helper()
+
+	select {
+	case <-handled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the program kept running after the client went away")
+	}
+}
+
+// endless prints a line and then runs until it is cancelled.
+const endless = `{"code": "puts(\"started\"); while true { }"}`
+
+func TestExecuteStreamCancelled(t *testing.T) {
+	url, handled := serve(t, Config{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, url+"/execute/stream", strings.NewReader(endless))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	if err != nil || line != "event:stdout\n" {
+		t.Fatalf("expected the output to be streamed. got=%q, %v", line, err)
+	}
+
+	cancel()
+	waitHandled(t, handled)
+}
+
+func dial(t *testing.T, url string) *websocket.Conn {
+	t.Helper()
+
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(url, "http")+"/execute/ws", "", url)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return ws
+}
+
+func TestExecuteWebSocket(t *testing.T) {
+	url, _ := serve(t, DefaultConfig)
+
+	tests := []struct {
+		body     string
+		messages []string
+	}{
+		{`{"code": "puts(1); 1 / 0"}`, []string{"stdout 1\n", "done runtime_error"}},
+		{`{"code": "puts([1]); \"a\""}`, []string{"stdout [1]\n", "done ok"}},
+		{`{"code": "let = 1"}`, []string{"done parse_error"}},
+		{`{"code": "1", "engine": "jit"}`, []string{"done bad_request"}},
+		{`{"code": `, []string{"done bad_request"}},
+	}
+
+	for _, tt := range tests {
+		ws := dial(t, url)
+		websocket.Message.Send(ws, tt.body)
+
+		got := []string{}
+		for {
+			var msg StreamMessage
+			if err := websocket.JSON.Receive(ws, &msg); err != nil {
+				break
+			}
+			if msg.Type == "done" {
+				got = append(got, msg.Type+" "+msg.Status)
+			} else {
+				got = append(got, msg.Type+" "+msg.Text)
+			}
+		}
+		ws.Close()
+
+		if strings.Join(got, "|") != strings.Join(tt.messages, "|") {
+			t.Errorf("%s: wrong messages. want=%q, got=%q", tt.body, tt.messages, got)
+		}
+	}
+}
+
+func TestExecuteWebSocketCancelled(t *testing.T) {
+	url, handled := serve(t, Config{})
+
+	ws := dial(t, url)
+	websocket.Message.Send(ws, endless)
+
+	var msg StreamMessage
+	if err := websocket.JSON.Receive(ws, &msg); err != nil || msg.Text != "started\n" {
+		t.Fatalf("expected the output to be streamed. got=%+v, %v", msg, err)
+	}
+
+	ws.Close()
+	waitHandled(t, handled)
+}
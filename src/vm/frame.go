@@ -0,0 +1,22 @@
+package vm
+
+import (
+	"monkey/src/code"
+	"monkey/src/object"
+	"monkey/src/token"
+)
+
+type Frame struct {
+	cl      *object.Closure
+	ip      int
+	bp      int            // where the frame's locals start on the stack
+	callPos token.Position // of the call that created the frame
+}
+
+func NewFrame(cl *object.Closure, basePointer int, callPos token.Position) *Frame {
+	return &Frame{cl: cl, ip: 0, bp: basePointer, callPos: callPos}
+}
+
+func (f *Frame) Instructions() code.Instructions {
+	return f.cl.Fn.Instructions
+}
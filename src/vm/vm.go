@@ -0,0 +1,703 @@
+package vm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"monkey/src/ast"
+	"monkey/src/code"
+	"monkey/src/compiler"
+	"monkey/src/evaluator"
+	"monkey/src/object"
+	"monkey/src/resolver"
+	"monkey/src/token"
+	"unicode/utf8"
+)
+
+const StackSize = 2048
+
+var (
+	NULL  = object.NULL
+	TRUE  = object.TRUE
+	FALSE = object.FALSE
+)
+
+// VM runs bytecode from the compiler package. It takes its standard streams
+// and limits from an evaluator.Interpreter, and globals from the
+// environment it is given, so it can stand in for the evaluator.
+type VM struct {
+	main *object.CompiledFunction
+
+	env    *object.Environment
+	interp *evaluator.Interpreter
+	meter  *evaluator.Meter
+
+	stack []object.Object
+	sp    int // always points to the next free slot, the top is stack[sp-1]
+
+	frames   []*Frame
+	handlers []handler
+
+	lastPopped object.Object
+}
+
+// handler is an active try statement: where to continue when an error is
+// raised in its frame, and the stack height to unwind to.
+type handler struct {
+	frame  int
+	target int
+	sp     int
+}
+
+func New(bytecode *compiler.Bytecode, env *object.Environment, interp *evaluator.Interpreter) *VM {
+	return &VM{
+		main:   bytecode.Main,
+		env:    env,
+		interp: interp,
+		stack:  make([]object.Object, StackSize),
+	}
+}
+
+// Run compiles program and runs it against env, the bytecode counterpart
+// of interp.EvalContext.
+func Run(ctx context.Context, program *ast.Program, env *object.Environment, interp *evaluator.Interpreter) object.Object {
+	if errs := resolver.Resolve(program, env); len(errs) > 0 {
+		return errs[0]
+	}
+
+	c := compiler.New()
+	if err := c.Compile(program); err != nil {
+		kind := object.TYPE_ERROR
+		if errors.Is(err, compiler.ErrTooLarge) {
+			kind = object.LIMIT_ERROR
+		}
+		return &object.Error{Kind: kind, Message: err.Error()}
+	}
+
+	return New(c.Bytecode(), env, interp).Run(ctx)
+}
+
+// Run executes the program and returns what evaluating it would: the value
+// of the last statement, or the error that stopped it.
+func (vm *VM) Run(ctx context.Context) object.Object {
+	meter, done := vm.interp.Meter(ctx, vm.env)
+	defer done()
+	vm.meter = meter
+
+	return vm.run()
+}
+
+func (vm *VM) run() object.Object {
+	mainClosure := &object.Closure{Fn: vm.main, Globals: vm.env}
+	vm.frames = []*Frame{NewFrame(mainClosure, 0, token.Position{})}
+	vm.sp = vm.main.NumLocals
+	vm.ensureStack(vm.sp)
+
+	for {
+		frame := vm.currentFrame()
+		ins := frame.Instructions()
+
+		if frame.ip >= len(ins) {
+			return vm.lastPopped
+		}
+
+		if err := vm.meter.Step(); err != nil {
+			err.Pos = frame.cl.Fn.SourceMap.Lookup(frame.ip)
+			result, _ := vm.raise(err)
+			return result
+		}
+
+		result, done := vm.execute(frame, ins)
+		if done {
+			return result
+		}
+	}
+}
+
+// execute runs the instruction at frame.ip. It reports whether the program
+// is over and, if so, its result.
+func (vm *VM) execute(frame *Frame, ins code.Instructions) (object.Object, bool) {
+	ip := frame.ip
+	op := code.Opcode(ins[ip])
+
+	var err *object.Error
+
+	switch op {
+	case code.OpConstant:
+		frame.ip += 3
+		vm.push(frame.cl.Fn.Constants[code.ReadUint16(ins[ip+1:])])
+
+	case code.OpPop:
+		frame.ip++
+		vm.lastPopped = vm.pop()
+
+	case code.OpTrue:
+		frame.ip++
+		vm.push(TRUE)
+
+	case code.OpFalse:
+		frame.ip++
+		vm.push(FALSE)
+
+	case code.OpNull:
+		frame.ip++
+		vm.push(NULL)
+
+	case code.OpNil:
+		frame.ip++
+		vm.push(nil)
+
+	case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpMod,
+		code.OpEqual, code.OpNotEqual, code.OpLessThan, code.OpGreaterThan,
+		code.OpLessEqual, code.OpGreaterEqual:
+		frame.ip++
+		err = vm.executeBinaryOperation(op)
+
+	case code.OpMinus:
+		frame.ip++
+		err = vm.pushResult(evaluator.PrefixOperation("-", vm.pop()))
+
+	case code.OpBang:
+		frame.ip++
+		err = vm.pushResult(evaluator.PrefixOperation("!", vm.pop()))
+
+	case code.OpToBool:
+		frame.ip++
+		vm.stack[vm.sp-1] = nativeBoolToBooleanObject(evaluator.IsTruthy(vm.stack[vm.sp-1]))
+
+	case code.OpJump:
+		frame.ip = int(code.ReadUint16(ins[ip+1:]))
+
+	case code.OpJumpNotTruthy:
+		frame.ip += 3
+		if !evaluator.IsTruthy(vm.pop()) {
+			frame.ip = int(code.ReadUint16(ins[ip+1:]))
+		}
+
+	case code.OpGetGlobal:
+		frame.ip += 3
+		err = vm.getGlobal(frame.cl.Globals, constantName(frame, ins[ip+1:]))
+
+	case code.OpDefineGlobal:
+		frame.ip += 3
+		frame.cl.Globals.Set(constantName(frame, ins[ip+1:]), vm.pop())
+
+	case code.OpSetGlobal:
+		frame.ip += 3
+		name := constantName(frame, ins[ip+1:])
+		if !frame.cl.Globals.UpdateValue(name, vm.pop()) {
+			err = newError(object.NAME_ERROR, "invalid assignment to non declared identifier %s", name)
+		}
+
+	case code.OpGetLocal:
+		frame.ip += 3
+		index := int(code.ReadUint16(ins[ip+1:]))
+
+		val := vm.stack[frame.bp+index]
+		if cell, ok := val.(*object.Cell); ok {
+			val = cell.Value
+		}
+		if val == nil {
+			err = newError(object.NAME_ERROR, "identifier not found: %s", frame.cl.Fn.LocalNames[index])
+			break
+		}
+		vm.push(val)
+
+	case code.OpSetLocal:
+		frame.ip += 3
+		slot := &vm.stack[frame.bp+int(code.ReadUint16(ins[ip+1:]))]
+
+		if cell, ok := (*slot).(*object.Cell); ok {
+			cell.Value = vm.pop()
+		} else {
+			*slot = vm.pop()
+		}
+
+	case code.OpGetFree:
+		frame.ip += 3
+		index := int(code.ReadUint16(ins[ip+1:]))
+
+		val := frame.cl.Free[index].Value
+		if val == nil {
+			err = newError(object.NAME_ERROR, "identifier not found: %s", frame.cl.Fn.FreeNames[index])
+			break
+		}
+		vm.push(val)
+
+	case code.OpSetFree:
+		frame.ip += 3
+		frame.cl.Free[code.ReadUint16(ins[ip+1:])].Value = vm.pop()
+
+	case code.OpLocalCell:
+		// the local is captured: from now on it lives in a cell shared with
+		// the closures, reads and writes of the slot go through it
+		frame.ip += 3
+		slot := &vm.stack[frame.bp+int(code.ReadUint16(ins[ip+1:]))]
+
+		cell, ok := (*slot).(*object.Cell)
+		if !ok {
+			cell = &object.Cell{Value: *slot}
+			*slot = cell
+		}
+		vm.push(cell)
+
+	case code.OpFreeCell:
+		frame.ip += 3
+		vm.push(frame.cl.Free[code.ReadUint16(ins[ip+1:])])
+
+	case code.OpClearLocals:
+		frame.ip += 5
+		start := frame.bp + int(code.ReadUint16(ins[ip+1:]))
+		for i := start; i < start+int(code.ReadUint16(ins[ip+3:])); i++ {
+			vm.stack[i] = nil
+		}
+
+	case code.OpArray:
+		frame.ip += 3
+		err = vm.buildArray(int(code.ReadUint16(ins[ip+1:])))
+
+	case code.OpHash:
+		frame.ip += 3
+		err = vm.buildHash(int(code.ReadUint16(ins[ip+1:])))
+
+	case code.OpHashKey:
+		frame.ip++
+		key := vm.stack[vm.sp-1]
+		if _, ok := key.(object.Hashable); !ok {
+			err = newError(object.TYPE_ERROR, "unusable as hask key: %s", key.Type())
+		}
+
+	case code.OpIndex:
+		frame.ip++
+		index := vm.pop()
+		left := vm.pop()
+		err = vm.pushResult(evaluator.IndexOperation(left, index))
+
+	case code.OpSetIndex:
+		frame.ip++
+		left := vm.pop()
+		value := vm.pop()
+		index := vm.pop()
+		err = vm.pushResult(evaluator.IndexAssignment(left, index, value, vm))
+
+	case code.OpClosure:
+		frame.ip += 5
+		fn := frame.cl.Fn.Constants[code.ReadUint16(ins[ip+1:])].(*object.CompiledFunction)
+		numFree := int(code.ReadUint16(ins[ip+3:]))
+
+		free := make([]*object.Cell, numFree)
+		for i := 0; i < numFree; i++ {
+			free[i] = vm.stack[vm.sp-numFree+i].(*object.Cell)
+		}
+		vm.sp -= numFree
+
+		vm.push(&object.Closure{Fn: fn, Free: free, Globals: frame.cl.Globals})
+
+	case code.OpCall:
+		frame.ip += 3
+		err = vm.callFunction(int(code.ReadUint16(ins[ip+1:])), frame.cl.Fn.SourceMap.Lookup(ip))
+
+	case code.OpReturnValue:
+		returnValue := vm.pop()
+
+		if len(vm.frames) == 1 {
+			return returnValue, true
+		}
+
+		vm.popFrame()
+		vm.push(returnValue)
+
+	case code.OpIter:
+		frame.ip++
+		err = vm.pushResult(newIterator(vm.pop()))
+
+	case code.OpIterNext:
+		frame.ip += 3
+		it := vm.stack[vm.sp-1].(*iterator)
+
+		index, value, ok := it.next()
+		if !ok {
+			frame.ip = int(code.ReadUint16(ins[ip+1:]))
+			break
+		}
+		vm.push(value)
+		vm.push(index)
+
+	case code.OpSetupTry:
+		frame.ip += 3
+		vm.handlers = append(vm.handlers, handler{
+			frame:  len(vm.frames) - 1,
+			target: int(code.ReadUint16(ins[ip+1:])),
+			sp:     vm.sp,
+		})
+
+	case code.OpPopTry:
+		frame.ip++
+		vm.handlers = vm.handlers[:len(vm.handlers)-1]
+
+	case code.OpThrow:
+		frame.ip++
+		err = evaluator.Throw(vm.pop())
+
+	case code.OpImport:
+		frame.ip += 3
+		path := frame.cl.Fn.Constants[code.ReadUint16(ins[ip+1:])].(*object.String).Value
+		from := frame.cl.Fn.SourceMap.Lookup(ip).Filename
+		err = vm.pushResult(vm.interp.Import(path, from, vm.runModule))
+
+	default:
+		frame.ip++
+		err = newError(object.TYPE_ERROR, "unknown opcode %d", op)
+	}
+
+	if err != nil {
+		if !err.Pos.IsValid() {
+			err.Pos = frame.cl.Fn.SourceMap.Lookup(ip)
+		}
+		return vm.raise(err)
+	}
+
+	return nil, false
+}
+
+// runModule runs the program of an imported module in a VM of its own,
+// counted against the limits of this one.
+func (vm *VM) runModule(program *ast.Program, env *object.Environment) object.Object {
+	c := compiler.New()
+	if err := c.Compile(program); err != nil {
+		kind := object.TYPE_ERROR
+		if errors.Is(err, compiler.ErrTooLarge) {
+			kind = object.LIMIT_ERROR
+		}
+		return &object.Error{Kind: kind, Message: err.Error()}
+	}
+
+	module := New(c.Bytecode(), env, vm.interp)
+	module.meter = vm.meter
+
+	return module.run()
+}
+
+// raise unwinds to the innermost try statement that can catch err. When
+// there is none, or err is a limit error, the program is over and err
+// holds the calls it went through.
+func (vm *VM) raise(err *object.Error) (object.Object, bool) {
+	catchable := !evaluator.IsLimitError(err)
+
+	for {
+		frameIndex := len(vm.frames) - 1
+
+		if n := len(vm.handlers); catchable && n > 0 && vm.handlers[n-1].frame == frameIndex {
+			h := vm.handlers[n-1]
+			vm.handlers = vm.handlers[:n-1]
+
+			vm.sp = h.sp
+			vm.push(&object.Exception{Err: err})
+			vm.currentFrame().ip = h.target
+
+			return nil, false
+		}
+
+		if frameIndex == 0 {
+			return err, true
+		}
+
+		frame := vm.popFrame()
+		err.Stack = append(err.Stack, object.Frame{Function: frame.cl.Fn.Name, Pos: frame.callPos})
+	}
+}
+
+func (vm *VM) callFunction(numArgs int, pos token.Position) *object.Error {
+	callee := vm.stack[vm.sp-1-numArgs]
+
+	switch callee := callee.(type) {
+	case *object.Closure:
+		fn := callee.Fn
+		if numArgs != fn.NumParameters {
+			return newError(object.TYPE_ERROR, "wrong number of arguments to `%s`. got=%d, want=%d",
+				functionName(fn), numArgs, fn.NumParameters)
+		}
+
+		if err := vm.meter.Enter(); err != nil {
+			return err
+		}
+
+		frame := NewFrame(callee, vm.sp-numArgs, pos)
+		vm.frames = append(vm.frames, frame)
+
+		vm.sp = frame.bp + fn.NumLocals
+		vm.ensureStack(vm.sp)
+		for i := frame.bp + numArgs; i < vm.sp; i++ {
+			vm.stack[i] = nil
+		}
+
+		return nil
+
+	case *object.Builtin:
+		args := make([]object.Object, numArgs)
+		copy(args, vm.stack[vm.sp-numArgs:vm.sp])
+		vm.sp = vm.sp - numArgs - 1
+
+		return vm.pushResult(callee.Fn(vm, args...))
+
+	default:
+		return newError(object.TYPE_ERROR, "not a function: %s", callee.Type())
+	}
+}
+
+func functionName(fn *object.CompiledFunction) string {
+	if fn.Name == "" {
+		return "fn"
+	}
+	return fn.Name
+}
+
+func (vm *VM) popFrame() *Frame {
+	frame := vm.currentFrame()
+	frameIndex := len(vm.frames) - 1
+
+	for len(vm.handlers) > 0 && vm.handlers[len(vm.handlers)-1].frame >= frameIndex {
+		vm.handlers = vm.handlers[:len(vm.handlers)-1]
+	}
+
+	vm.frames = vm.frames[:frameIndex]
+	vm.sp = frame.bp - 1
+	vm.meter.Leave()
+
+	return frame
+}
+
+func (vm *VM) getGlobal(globals *object.Environment, name string) *object.Error {
+	if val, ok := globals.Get(name); ok {
+		vm.push(val)
+		return nil
+	}
+	if builtin, ok := object.Builtins[name]; ok {
+		vm.push(builtin)
+		return nil
+	}
+
+	return newError(object.NAME_ERROR, "identifier not found: %s", name)
+}
+
+func constantName(frame *Frame, operand code.Instructions) string {
+	return frame.cl.Fn.Constants[code.ReadUint16(operand)].(*object.String).Value
+}
+
+var binaryOperators = map[code.Opcode]string{
+	code.OpAdd:          "+",
+	code.OpSub:          "-",
+	code.OpMul:          "*",
+	code.OpDiv:          "/",
+	code.OpMod:          "%",
+	code.OpEqual:        "==",
+	code.OpNotEqual:     "!=",
+	code.OpLessThan:     "<",
+	code.OpGreaterThan:  ">",
+	code.OpLessEqual:    "<=",
+	code.OpGreaterEqual: ">=",
+}
+
+func (vm *VM) executeBinaryOperation(op code.Opcode) *object.Error {
+	right := vm.pop()
+	left := vm.pop()
+
+	// the common integer cases skip the evaluator's type switch
+	if l, ok := left.(*object.Integer); ok {
+		if r, ok := right.(*object.Integer); ok {
+			if result, ok := integerOperation(op, l.Value, r.Value); ok {
+				vm.push(result)
+				return nil
+			}
+		}
+	}
+
+	return vm.pushResult(evaluator.InfixOperation(binaryOperators[op], left, right, vm))
+}
+
+func integerOperation(op code.Opcode, left, right int64) (object.Object, bool) {
+	switch op {
+	case code.OpAdd:
+		return &object.Integer{Value: left + right}, true
+	case code.OpSub:
+		return &object.Integer{Value: left - right}, true
+	case code.OpMul:
+		return &object.Integer{Value: left * right}, true
+	case code.OpLessThan:
+		return nativeBoolToBooleanObject(left < right), true
+	case code.OpGreaterThan:
+		return nativeBoolToBooleanObject(left > right), true
+	case code.OpLessEqual:
+		return nativeBoolToBooleanObject(left <= right), true
+	case code.OpGreaterEqual:
+		return nativeBoolToBooleanObject(left >= right), true
+	case code.OpEqual:
+		return nativeBoolToBooleanObject(left == right), true
+	case code.OpNotEqual:
+		return nativeBoolToBooleanObject(left != right), true
+	}
+
+	return nil, false
+}
+
+func (vm *VM) buildArray(n int) *object.Error {
+	if err := vm.Alloc(object.ArraySize(int64(n))); err != nil {
+		return err
+	}
+
+	elements := make([]object.Object, n)
+	copy(elements, vm.stack[vm.sp-n:vm.sp])
+	vm.sp -= n
+
+	vm.push(&object.Array{Elements: elements})
+
+	return nil
+}
+
+func (vm *VM) buildHash(n int) *object.Error {
+	if err := vm.Alloc(object.HashSize(int64(n / 2))); err != nil {
+		return err
+	}
+
+	hash := object.NewHash(n / 2)
+
+	for i := vm.sp - n; i < vm.sp; i += 2 {
+		key := vm.stack[i]
+		value := vm.stack[i+1]
+
+		// OpHashKey checked every key
+		hashKey := key.(object.Hashable)
+
+		hash.Set(hashKey.HashKey(), object.HashPair{Key: key, Value: value})
+	}
+	vm.sp -= n
+
+	vm.push(hash)
+
+	return nil
+}
+
+// pushResult pushes what an operation returned, unless it is an error.
+func (vm *VM) pushResult(result object.Object) *object.Error {
+	if err, ok := result.(*object.Error); ok {
+		return err
+	}
+
+	vm.push(result)
+
+	return nil
+}
+
+func (vm *VM) push(o object.Object) {
+	if vm.sp >= len(vm.stack) {
+		vm.ensureStack(vm.sp + 1)
+	}
+
+	vm.stack[vm.sp] = o
+	vm.sp++
+}
+
+func (vm *VM) pop() object.Object {
+	o := vm.stack[vm.sp-1]
+	vm.sp--
+	return o
+}
+
+// ensureStack grows the stack to hold at least n values. Only the call
+// depth limit bounds how deep it gets.
+func (vm *VM) ensureStack(n int) {
+	if n <= len(vm.stack) {
+		return
+	}
+
+	size := len(vm.stack) * 2
+	for size < n {
+		size *= 2
+	}
+
+	stack := make([]object.Object, size)
+	copy(stack, vm.stack)
+	vm.stack = stack
+}
+
+func (vm *VM) currentFrame() *Frame {
+	return vm.frames[len(vm.frames)-1]
+}
+
+// LastPoppedStackElem is the value the last expression statement left.
+func (vm *VM) LastPoppedStackElem() object.Object {
+	return vm.lastPopped
+}
+
+// The VM is the object.Runtime its builtins run against.
+
+func (vm *VM) Alloc(size int64) *object.Error { return vm.meter.Alloc(size) }
+func (vm *VM) Stdout() io.Writer              { return vm.interp.StdoutWriter() }
+func (vm *VM) Stderr() io.Writer              { return vm.interp.StderrWriter() }
+func (vm *VM) ReadLine() (string, bool)       { return vm.interp.ReadLine() }
+
+func nativeBoolToBooleanObject(value bool) *object.Boolean {
+	if value {
+		return TRUE
+	}
+	return FALSE
+}
+
+func newError(kind object.ErrorKind, format string, a ...interface{}) *object.Error {
+	return &object.Error{Kind: kind, Message: fmt.Sprintf(format, a...)}
+}
+
+// iterator walks the array, string or hash of a for loop.
+type iterator struct {
+	next func() (index, value object.Object, ok bool)
+}
+
+func (it *iterator) Type() object.ObjectType { return "ITERATOR" }
+func (it *iterator) Inspect() string         { return "iterator" }
+
+func newIterator(obj object.Object) object.Object {
+	it := &iterator{}
+
+	switch obj := obj.(type) {
+	case *object.Array:
+		i := 0
+		it.next = func() (object.Object, object.Object, bool) {
+			if i >= len(obj.Elements) {
+				return nil, nil, false
+			}
+			index, value := &object.Integer{Value: int64(i)}, obj.Elements[i]
+			i++
+			return index, value, true
+		}
+
+	case *object.String:
+		offset := 0
+		it.next = func() (object.Object, object.Object, bool) {
+			if offset >= len(obj.Value) {
+				return nil, nil, false
+			}
+			r, size := utf8.DecodeRuneInString(obj.Value[offset:])
+			index, value := &object.Integer{Value: int64(offset)}, &object.String{Value: string(r)}
+			offset += size
+			return index, value, true
+		}
+
+	case *object.Hash:
+		pairs := obj.Pairs()
+		i := 0
+		it.next = func() (object.Object, object.Object, bool) {
+			if i >= len(pairs) {
+				return nil, nil, false
+			}
+			pair := pairs[i]
+			i++
+			return pair.Key, pair.Value, true
+		}
+
+	default:
+		return newError(object.TYPE_ERROR, "for iterator must resolve to array, string or hash got %s", obj.Type())
+	}
+
+	return it
+}
@@ -0,0 +1,500 @@
+package vm
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	goast "go/ast"
+	goparser "go/parser"
+	gotoken "go/token"
+	"monkey/src/ast"
+	"monkey/src/evaluator"
+	"monkey/src/lexer"
+	"monkey/src/object"
+	"monkey/src/parser"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// testLimits keep the programs of the evaluator tests that loop forever or
+// recurse without end from hanging the differential tests.
+var testLimits = evaluator.Limits{MaxSteps: 1000000, MaxCallDepth: 200, MaxMemory: 1 << 24}
+
+type result struct {
+	value  object.Object
+	output string
+}
+
+func runEval(t *testing.T, input string) result {
+	program := parse(t, input)
+
+	var out bytes.Buffer
+	interp := &evaluator.Interpreter{Stdout: &out, Limits: testLimits}
+
+	return result{interp.EvalContext(context.Background(), program, object.NewEnvironment()), out.String()}
+}
+
+func runVM(t *testing.T, input string) result {
+	program := parse(t, input)
+
+	var out bytes.Buffer
+	interp := &evaluator.Interpreter{Stdout: &out, Limits: testLimits}
+
+	return result{Run(context.Background(), program, object.NewEnvironment(), interp), out.String()}
+}
+
+func parse(t *testing.T, input string) *ast.Program {
+	t.Helper()
+
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors for %q: %v", input, p.Errors())
+	}
+
+	return program
+}
+
+// TestEvaluatorCases runs every program found in the evaluator's tests on
+// both engines and checks they agree.
+func TestEvaluatorCases(t *testing.T) {
+	inputs := evaluatorTestInputs(t)
+	if len(inputs) < 100 {
+		t.Fatalf("found only %d programs in the evaluator tests", len(inputs))
+	}
+
+	for _, input := range inputs {
+		expected := runEval(t, input)
+		got := runVM(t, input)
+
+		if !sameResult(expected.value, got.value) {
+			t.Errorf("%q: evaluator returned %s, vm returned %s", input, describe(expected.value), describe(got.value))
+		}
+
+		if expected.output != got.output {
+			t.Errorf("%q: evaluator printed %q, vm printed %q", input, expected.output, got.output)
+		}
+	}
+}
+
+// evaluatorTestInputs collects the string literals of evaluator_test.go
+// that parse as programs.
+func evaluatorTestInputs(t *testing.T) []string {
+	fset := gotoken.NewFileSet()
+	file, err := goparser.ParseFile(fset, "../evaluator/evaluator_test.go", nil, 0)
+	if err != nil {
+		t.Fatalf("cannot read the evaluator tests: %s", err)
+	}
+
+	seen := map[string]bool{}
+	inputs := []string{}
+
+	goast.Inspect(file, func(n goast.Node) bool {
+		lit, ok := n.(*goast.BasicLit)
+		if !ok || lit.Kind != gotoken.STRING {
+			return true
+		}
+
+		s, err := strconv.Unquote(lit.Value)
+		if err != nil || s == "" || seen[s] {
+			return true
+		}
+		seen[s] = true
+
+		p := parser.New(lexer.New(s))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			inputs = append(inputs, s)
+		}
+
+		return true
+	})
+
+	return inputs
+}
+
+func TestPrograms(t *testing.T) {
+	tests := []string{
+		"let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } }; fib(15)",
+		"let counter = fn() { let n = 0; fn() { n = n + 1; n } }; let c = counter(); c(); c(); c()",
+		"let f = fn() { let g = fn() { h() }; let h = fn() { 42 }; g() }; f()",
+		"let h = fn() { 1 }; let f = fn() { let a = h(); let h = fn() { 2 }; a + h() }; f()",
+		"let x = 1; let f = fn() { let x = x + 1; x }; f() + x",
+		"let fs = []; for i, v in [1, 2, 3] { fs = push(fs, fn() { v }) } fs[0]() + fs[2]()",
+		"let f = fn() { try { return 1 } finally { puts(\"finally\") } }; f()",
+		"let f = fn() { for i, v in [1, 2, 3] { try { if (v == 2) { return v } } finally { puts(v) } } }; f()",
+		"let r = 0; for i, v in [1, 2, 3, 4] { try { if (v % 2 == 0) { continue } r = r + v } finally { r = r + 10 } } r",
+		"let r = []; while true { try { break } finally { r = push(r, 1) } } r",
+		"try { throw \"a\" } catch (e) { try { throw e[\"message\"] + \"b\" } catch (e) { e[\"message\"] } }",
+		"let f = fn() { try { throw 1 } finally { return 2 } }; f()",
+		"let f = fn(n) { if (n == 0) { throw \"bottom\" } f(n - 1) }; try { f(3) } catch (e) { len(e[\"traceback\"]) }",
+		"let s = \"\"; for i, c in \"héllo\" { s = s + c + puts(i) } s",
+		"let h = {\"a\": 1}; let n = 0; for k, v in h { n = n + v } n",
+		"let a = [1, 2]; a[1] = 5; a",
+		"let h = {}; h[\"x\"] = 1; h[\"x\"] = h[\"x\"] + 1; h[\"x\"]",
+		"{[1]: puts(\"side\")}",
+		"{puts(\"key\"): puts(\"value\"), fn() {}: puts(\"never\")}",
+		"true && 0 || \"a\"",
+		"let f = fn(a, b) { a }; f(1)",
+		"let f = fn() { undefined }; f()",
+		"puts(1, \"two\", [3]); gets()",
+		"while true { }",
+		"let f = fn(n) { f(n + 1) }; f(0)",
+		"let s = \"a\"; while true { s = s + s }",
+		"1 / 0",
+		"let f = fn() { let x = 1; while x < 10 { let y = x; x = x + y } x }; f()",
+	}
+
+	for _, input := range tests {
+		expected := runEval(t, input)
+		got := runVM(t, input)
+
+		if !sameResult(expected.value, got.value) {
+			t.Errorf("%q: evaluator returned %s, vm returned %s", input, describe(expected.value), describe(got.value))
+		}
+
+		if expected.output != got.output {
+			t.Errorf("%q: evaluator printed %q, vm printed %q", input, expected.output, got.output)
+		}
+	}
+}
+
+// TestExpressionPositions puts every way of leaving an if expression in
+// every place an expression can go, with something printed before and
+// after it, and checks both engines run the same code in the same order.
+func TestExpressionPositions(t *testing.T) {
+	exits := []string{
+		`if (c) { break }`,
+		`if (c) { continue }`,
+		`if (c) { return "early" }`,
+		`if (c) { throw "thrown" }`,
+		`if (c) { 1 / 0 }`,
+		`if (c) { puts("then"); 3 } else { 4 }`,
+		`if (c) { let a = 1; }`,
+		`if (c) { } else { x = 2 }`,
+	}
+
+	places := []string{
+		`let x = %s; puts(x)`,
+		`x = %s`,
+		`puts(puts("left") + %s)`,
+		`puts(%s - puts("right"))`,
+		`puts(-%s)`,
+		`puts(!%s)`,
+		`puts("a", %s, puts("b"))`,
+		`puts([puts("a"), %s, puts("b")])`,
+		`puts({puts("k"): %s, "b": puts("b")})`,
+		`puts({%s: puts("v")})`,
+		`puts(%s[puts("i")])`,
+		`puts([1, 2][%s])`,
+		`h[puts("i")] = %s`,
+		`h[%s] = puts("v")`,
+		`puts(%s.length)`,
+		`puts(c && %s)`,
+		`puts(%s || puts("or"))`,
+		`if (%s) { puts("yes") }`,
+		`for k, v in [%s] { puts(v) }`,
+		`try { puts(%s) } catch (e) { puts(e["message"]) }`,
+		`try { puts("body") } finally { puts(%s) }`,
+		`return %s`,
+		`throw %s`,
+	}
+
+	loops := []string{
+		`let f = fn() { let h = {}; let x = 0; let i = 0; while (i < 3) { i = i + 1; let c = i == 2; %s puts(i) } "end" }; puts(f())`,
+		`let f = fn() { let h = {}; let x = 0; for i, v in [1, 2, 3] { let c = v == 2; %s puts(i) } "end" }; puts(f())`,
+		`let h = {}; let x = 0; for i, v in [1, 2, 3] { let c = v != 2; %s puts(i) } x`,
+	}
+
+	for _, loop := range loops {
+		for _, place := range places {
+			for _, exit := range exits {
+				input := fmt.Sprintf(loop, fmt.Sprintf(place, exit))
+
+				expected := runEval(t, input)
+				got := runVM(t, input)
+
+				if !sameResult(expected.value, got.value) {
+					t.Errorf("%q: evaluator returned %s, vm returned %s", input, describe(expected.value), describe(got.value))
+				}
+
+				if expected.output != got.output {
+					t.Errorf("%q: evaluator printed %q, vm printed %q", input, expected.output, got.output)
+				}
+			}
+		}
+	}
+}
+
+// name returns a name for the binding i, identifiers cannot hold digits.
+func name(i int) string {
+	s := ""
+	for ; i > 0 || s == ""; i /= 26 {
+		s = string(rune('a'+i%26)) + s
+	}
+	return "v" + s
+}
+
+// TestOperandLimits checks programs with more names, slots and arguments
+// than fit in a byte behave on the VM as they do in the evaluator.
+func TestOperandLimits(t *testing.T) {
+	var globals, lets, params, args, free strings.Builder
+
+	globals.WriteString("let x = 0; let i = 1; ")
+	for j := 0; j < 40000; j++ {
+		globals.WriteString("x = x + i; ")
+	}
+	globals.WriteString("x")
+
+	lets.WriteString("let f = fn() { ")
+	for j := 0; j < 300; j++ {
+		fmt.Fprintf(&lets, "let %s = %d; ", name(j), j)
+	}
+	lets.WriteString(name(0) + " + " + name(299) + " }; f()")
+
+	for j := 0; j < 300; j++ {
+		if j > 0 {
+			params.WriteString(", ")
+			args.WriteString(", ")
+		}
+		params.WriteString(name(j))
+		args.WriteString(strconv.Itoa(j))
+	}
+
+	free.WriteString("let f = fn() { ")
+	for j := 0; j < 300; j++ {
+		fmt.Fprintf(&free, "let %s = %d; ", name(j), j)
+	}
+	free.WriteString("fn() { " + strings.ReplaceAll(params.String(), ",", " +") + " } }; f()()")
+
+	tests := []string{
+		globals.String(),
+		lets.String(),
+		"let f = fn(" + params.String() + ") { " + name(0) + " + " + name(299) + " }; f(" + args.String() + ")",
+		"let f = fn(" + params.String() + ") { " + name(299) + " }; f(" + args.String() + ")",
+		free.String(),
+	}
+
+	for _, input := range tests {
+		expected := runEval(t, input)
+		got := runVM(t, input)
+
+		if !sameResult(expected.value, got.value) {
+			t.Errorf("%.40q...: evaluator returned %s, vm returned %s", input, describe(expected.value), describe(got.value))
+		}
+	}
+}
+
+// TestTooLarge checks a program whose constants cannot all be numbered is
+// refused instead of running wrong.
+func TestTooLarge(t *testing.T) {
+	var input strings.Builder
+	input.WriteString("let x = 0; ")
+	for j := 0; j < 70000; j++ {
+		fmt.Fprintf(&input, "x = %d; ", j)
+	}
+
+	err, ok := runVM(t, input.String()).value.(*object.Error)
+	if !ok || err.Kind != object.LIMIT_ERROR || !strings.HasSuffix(err.Message, "program too large: too many constants") {
+		t.Errorf("expected the program to be refused. got=%+v", err)
+	}
+}
+
+func TestErrorDetails(t *testing.T) {
+	input := "let f = fn(x) {\n  x - \"a\"\n};\nlet g = fn() { f(1) };\ng()"
+
+	err, ok := runVM(t, input).value.(*object.Error)
+	if !ok {
+		t.Fatalf("expected an error")
+	}
+
+	if err.Kind != object.TYPE_ERROR {
+		t.Errorf("wrong kind. got=%s", err.Kind)
+	}
+
+	if err.Pos.String() != "2:3" {
+		t.Errorf("wrong position. got=%s", err.Pos)
+	}
+
+	if len(err.Stack) != 2 || err.Stack[0].Function != "f" || err.Stack[0].Pos.String() != "4:16" ||
+		err.Stack[1].Function != "g" || err.Stack[1].Pos.String() != "5:1" {
+		t.Errorf("wrong stack. got=%+v", err.Stack)
+	}
+}
+
+// TestLimitErrorStack checks a limit error records the calls it stopped,
+// as it does in the evaluator.
+func TestLimitErrorStack(t *testing.T) {
+	tests := []struct {
+		input string
+		stack string
+	}{
+		{"let f = fn() { while true { } };\nlet g = fn() { f() };\ng()", "[{f 2:16} {g 3:1}]"},
+		{"let f = fn() { let s = \"a\"; while true { s = s + s } };\nf()", "[{f 2:1}]"},
+		{"let f = fn(n) { f(n + 1) };\nf(0)", ""},
+	}
+
+	for _, tt := range tests {
+		expected, ok := runEval(t, tt.input).value.(*object.Error)
+		if !ok || !evaluator.IsLimitError(expected) {
+			t.Fatalf("%q: expected a limit error from the evaluator. got=%v", tt.input, expected)
+		}
+
+		err, ok := runVM(t, tt.input).value.(*object.Error)
+		if !ok || err.Kind != expected.Kind {
+			t.Fatalf("%q: expected a %s. got=%v", tt.input, expected.Kind, err)
+		}
+
+		if len(err.Stack) != len(expected.Stack) || len(err.Stack) == 0 {
+			t.Errorf("%q: wrong stack. evaluator=%v, vm=%v", tt.input, expected.Stack, err.Stack)
+		}
+		if tt.stack != "" && fmt.Sprint(err.Stack) != tt.stack {
+			t.Errorf("%q: wrong stack. want=%s, got=%v", tt.input, tt.stack, err.Stack)
+		}
+	}
+}
+
+func TestCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	interp := &evaluator.Interpreter{}
+	result := Run(ctx, parse(t, "while true { }"), object.NewEnvironment(), interp)
+
+	err, ok := result.(*object.Error)
+	if !ok || err.Kind != object.LIMIT_ERROR || err.Message != "execution cancelled" {
+		t.Errorf("expected the run to be cancelled. got=%s", describe(result))
+	}
+}
+
+func TestGlobalsPersist(t *testing.T) {
+	env := object.NewEnvironment()
+	interp := &evaluator.Interpreter{}
+
+	Run(context.Background(), parse(t, "let double = fn(x) { x * 2 }; let a = 4"), env, interp)
+	Run(context.Background(), parse(t, "let b = double(a)"), env, interp)
+
+	result := Run(context.Background(), parse(t, "b + 1"), env, interp)
+	if result.Inspect() != "9" {
+		t.Errorf("expected globals to persist between runs. got=%s", describe(result))
+	}
+}
+
+func TestModules(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"math.mky":  "puts(\"loading\"); let sq = fn(x) { x * x }; export let sumSq = fn(a, b) { sq(a) + sq(b) }; export let n = 10;",
+		"user.mky":  "import \"math.mky\" as m; export let scaled = fn(x) { m.n * x };",
+		"a.mky":     "import \"b.mky\"; export let x = 1;",
+		"b.mky":     "import \"a.mky\"; export let y = 2;",
+		"error.mky": "export let x = 1 / 0;",
+	}
+	for name, src := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []string{
+		`import "math.mky"; math.sumSq(3, 4)`,
+		`import "math.mky"; import "user.mky"; user.scaled(2) + math.n`,
+		`let f = fn() { import "user.mky" as u; u.scaled(3) }; f() + f()`,
+		`import "math.mky"; math.sq`,
+		`import "math.mky"; math`,
+		`import "a.mky"`,
+		`import "error.mky"`,
+		`import "missing.mky"`,
+	}
+
+	run := func(input string, engine func(*ast.Program, *evaluator.Interpreter) object.Object) result {
+		program := parser.New(lexer.NewFile(filepath.Join(dir, "main.mky"), input)).ParseProgram()
+
+		var out bytes.Buffer
+		interp := &evaluator.Interpreter{Stdout: &out, Limits: testLimits}
+
+		return result{engine(program, interp), out.String()}
+	}
+
+	for _, input := range tests {
+		expected := run(input, func(program *ast.Program, interp *evaluator.Interpreter) object.Object {
+			return interp.EvalContext(context.Background(), program, object.NewEnvironment())
+		})
+		got := run(input, func(program *ast.Program, interp *evaluator.Interpreter) object.Object {
+			return Run(context.Background(), program, object.NewEnvironment(), interp)
+		})
+
+		if !sameResult(expected.value, got.value) {
+			t.Errorf("%q: evaluator returned %s, vm returned %s", input, describe(expected.value), describe(got.value))
+		}
+
+		if expected.output != got.output {
+			t.Errorf("%q: evaluator printed %q, vm printed %q", input, expected.output, got.output)
+		}
+	}
+}
+
+// sameResult compares results the way a user would see them. Limits count
+// steps differently in each engine, so only the kind of a limit error is
+// compared.
+func sameResult(a, b object.Object) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	if a.Type() != b.Type() {
+		return false
+	}
+
+	switch a := a.(type) {
+	case *object.Error:
+		b := b.(*object.Error)
+		if evaluator.IsLimitError(a) {
+			return a.Kind == b.Kind
+		}
+		return a.Kind == b.Kind && a.Message == b.Message && a.Pos == b.Pos && sameResult(a.Value, b.Value) &&
+			fmt.Sprint(a.Stack) == fmt.Sprint(b.Stack)
+
+	case *object.Exception:
+		return sameResult(a.Err, b.(*object.Exception).Err)
+
+	case *object.Array:
+		b := b.(*object.Array)
+		if len(a.Elements) != len(b.Elements) {
+			return false
+		}
+		for i := range a.Elements {
+			if !sameResult(a.Elements[i], b.Elements[i]) {
+				return false
+			}
+		}
+		return true
+
+	case *object.Hash:
+		aPairs, bPairs := a.Pairs(), b.(*object.Hash).Pairs()
+		if len(aPairs) != len(bPairs) {
+			return false
+		}
+		for i := range aPairs {
+			if !sameResult(aPairs[i].Key, bPairs[i].Key) || !sameResult(aPairs[i].Value, bPairs[i].Value) {
+				return false
+			}
+		}
+		return true
+	}
+
+	return a.Inspect() == b.Inspect()
+}
+
+func describe(obj object.Object) string {
+	switch obj := obj.(type) {
+	case nil:
+		return "nil"
+	case *object.Error:
+		return obj.Pos.String() + " " + obj.Inspect()
+	}
+
+	return obj.Inspect()
+}